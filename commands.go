@@ -0,0 +1,54 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Command is a subcommand of git-notes, e.g. `git-notes init`. Without a subcommand, git-notes runs
+// the daemon with the config file given as the first argument.
+type Command struct {
+	Usage string
+	Run   func(args []string) error
+	// Hidden commands are run by git, e.g. as a filter, and are not listed in the usage.
+	Hidden bool
+}
+
+var commands = map[string]Command{}
+
+func commandUsage() string {
+	var names []string
+	for name, command := range commands {
+		if !command.Hidden {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	lines := []string{"Usage:", "  git-notes <config-file>"}
+	for _, name := range names {
+		lines = append(lines, fmt.Sprintf("  git-notes %s", commands[name].Usage))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// parseInterspersed parses the flags even when they come after the positional arguments and returns
+// the positional arguments.
+func parseInterspersed(flags *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+	for {
+		err := flags.Parse(args)
+		if err != nil {
+			return nil, err
+		}
+
+		args = flags.Args()
+		if len(args) == 0 {
+			return positional, nil
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
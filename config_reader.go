@@ -1,29 +1,262 @@
 package main
 
 import (
+	"bytes"
 	"encoding/json"
-	"os"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
 )
 
 type Config struct {
-	Repos []string `json:"Repos"`
+	Repos          []string               `json:"repos" yaml:"repos" toml:"repos"`
+	MetricsAddress string                 `json:"metrics_address" yaml:"metrics_address" toml:"metrics_address"`
+	Notifications  NotificationConfig     `json:"notifications" yaml:"notifications" toml:"notifications"`
+	Discover       DiscoverConfig         `json:"discover" yaml:"discover" toml:"discover"`
+	SecretScan     SecretScanConfig       `json:"secret_scan" yaml:"secret_scan" toml:"secret_scan"`
+	DevicePolicy   DevicePolicyConfig     `json:"device_policy" yaml:"device_policy" toml:"device_policy"`
+	DeletionGuard  DeletionGuardConfig    `json:"deletion_guard" yaml:"deletion_guard" toml:"deletion_guard"`
+	ManualActivity ManualActivityConfig   `json:"manual_activity" yaml:"manual_activity" toml:"manual_activity"`
+	RepoOptions    map[string]RepoOptions `json:"repo_options" yaml:"repo_options" toml:"repo_options"`
+}
+
+// RepoOptions are the per-repo settings, keyed by the repo path in Config.RepoOptions.
+type RepoOptions struct {
+	SparseCheckout   []string          `json:"sparse_checkout" yaml:"sparse_checkout" toml:"sparse_checkout"`
+	PartialClone     string            `json:"partial_clone" yaml:"partial_clone" toml:"partial_clone"`
+	LFS              LFSOptions        `json:"lfs" yaml:"lfs" toml:"lfs"`
+	Encryption       EncryptionOptions `json:"encryption" yaml:"encryption" toml:"encryption"`
+	Schedule         ScheduleOptions   `json:"schedule" yaml:"schedule" toml:"schedule"`
+	Merge            MergeOptions      `json:"merge" yaml:"merge" toml:"merge"`
+	InterruptedMerge string            `json:"interrupted_merge" yaml:"interrupted_merge" toml:"interrupted_merge"`
+	Submodules       bool              `json:"submodules" yaml:"submodules" toml:"submodules"`
+}
+
+type DiscoverConfig struct {
+	Roots    []string `json:"roots" yaml:"roots" toml:"roots"`
+	Pattern  string   `json:"pattern" yaml:"pattern" toml:"pattern"`
+	Marker   string   `json:"marker" yaml:"marker" toml:"marker"`
+	Interval string   `json:"interval" yaml:"interval" toml:"interval"`
+}
+
+type NotificationConfig struct {
+	Desktop          bool     `json:"desktop" yaml:"desktop" toml:"desktop"`
+	Webhook          string   `json:"webhook" yaml:"webhook" toml:"webhook"`
+	Command          []string `json:"command" yaml:"command" toml:"command"`
+	FailureThreshold string   `json:"failure_threshold" yaml:"failure_threshold" toml:"failure_threshold"`
+	RateLimit        string   `json:"rate_limit" yaml:"rate_limit" toml:"rate_limit"`
 }
 
 type ConfigReader interface {
 	Read(path string) (*Config, error)
 }
 
+// ConfigError is a problem found in a config file. Line and Column are 0 when the position is unknown.
+type ConfigError struct {
+	Path    string
+	Line    int
+	Column  int
+	Message string
+}
+
+func (e *ConfigError) Error() string {
+	if e.Line == 0 {
+		return fmt.Sprintf("%s: %s", e.Path, e.Message)
+	}
+	if e.Column == 0 {
+		return fmt.Sprintf("%s:%d: %s", e.Path, e.Line, e.Message)
+	}
+	return fmt.Sprintf("%s:%d:%d: %s", e.Path, e.Line, e.Column, e.Message)
+}
+
+// ConfigErrors reports all the problems of a config file together.
+type ConfigErrors []error
+
+func (e ConfigErrors) Error() string {
+	var messages []string
+	for _, err := range e {
+		messages = append(messages, err.Error())
+	}
+	return strings.Join(messages, "\n")
+}
+
+func (e ConfigErrors) orNil() error {
+	if len(e) == 0 {
+		return nil
+	}
+	return e
+}
+
+// FileConfigReader chooses the format by the file extension and then resolves and checks the repos.
+type FileConfigReader struct{}
+
+func (c *FileConfigReader) Read(path string) (*Config, error) {
+	config, err := c.readFormat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	err = config.ResolveRepos(path)
+	if err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// readFormat reads the config without resolving the repos.
+func (c *FileConfigReader) readFormat(path string) (*Config, error) {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		return (&YamlConfigReader{}).Read(path)
+	case ".toml":
+		return (&TomlConfigReader{}).Read(path)
+	default:
+		return (&JsonConfigReader{}).Read(path)
+	}
+}
+
 type JsonConfigReader struct {}
 
 func (c *JsonConfigReader) Read(path string) (*Config, error) {
-	file, err := os.Open(path)
+	data, err := ioutil.ReadFile(path)
 	if err != nil {  return nil, err }
 
-	decoder := json.NewDecoder(file)
+	var raw interface{}
+	err = json.Unmarshal(data, &raw)
+	if err != nil {
+		var syntaxErr *json.SyntaxError
+		if errors.As(err, &syntaxErr) {
+			line, column := position(data, int(syntaxErr.Offset)-1)
+			return nil, &ConfigError{Path: path, Line: line, Column: column, Message: err.Error()}
+		}
+		return nil, &ConfigError{Path: path, Message: err.Error()}
+	}
 
-	var config Config
-	err = decoder.Decode(&config)
+	var errs ConfigErrors
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	err = checkJsonKeys(path, data, decoder, reflect.TypeOf(Config{}), "", &errs)
 	if err != nil {  return nil, err }
+	if len(errs) > 0 {  return nil, errs }
+
+	var config Config
+	err = json.Unmarshal(data, &config)
+	if err != nil {
+		var typeErr *json.UnmarshalTypeError
+		if errors.As(err, &typeErr) {
+			line, column := position(data, int(typeErr.Offset))
+			return nil, &ConfigError{Path: path, Line: line, Column: column, Message: fmt.Sprintf("%s must be %s, not %s", typeErr.Field, typeErr.Type, typeErr.Value)}
+		}
+		return nil, &ConfigError{Path: path, Message: err.Error()}
+	}
+
+	return &config, config.Validate(path)
+}
+
+// checkJsonKeys walks the JSON tokens and reports every key that doesn't exist in the Go type.
+func checkJsonKeys(path string, data []byte, decoder *json.Decoder, t reflect.Type, prefix string, errs *ConfigErrors) error {
+	token, err := decoder.Token()
+	if err != nil {
+		return jsonSyntaxError(path, data, decoder, err)
+	}
+
+	switch token {
+	case json.Delim('{'):
+		for decoder.More() {
+			token, err := decoder.Token()
+			if err != nil {
+				return jsonSyntaxError(path, data, decoder, err)
+			}
+			key := token.(string)
+
+			var fieldType reflect.Type
+			if t != nil {
+				var ok bool
+				fieldType, ok = lookupConfigField(t, key, "json", true)
+				if !ok {
+					offset := int(decoder.InputOffset())
+					start := bytes.LastIndexByte(data[:offset-1], '"')
+					line, column := position(data, start)
+					*errs = append(*errs, &ConfigError{Path: path, Line: line, Column: column, Message: fmt.Sprintf("unknown key %q", prefix+key)})
+				}
+			}
+
+			err = checkJsonKeys(path, data, decoder, fieldType, prefix+key+".", errs)
+			if err != nil {
+				return err
+			}
+		}
+		_, err = decoder.Token()
+	case json.Delim('['):
+		var elemType reflect.Type
+		if t != nil && (t.Kind() == reflect.Slice || t.Kind() == reflect.Array) {
+			elemType = t.Elem()
+		}
+		for decoder.More() {
+			err = checkJsonKeys(path, data, decoder, elemType, prefix, errs)
+			if err != nil {
+				return err
+			}
+		}
+		_, err = decoder.Token()
+	}
+
+	if err != nil {
+		return jsonSyntaxError(path, data, decoder, err)
+	}
+	return nil
+}
+
+// jsonSyntaxError reports where the token stream broke. The input has already been parsed once
+// by json.Unmarshal, so this is not expected to happen.
+func jsonSyntaxError(path string, data []byte, decoder *json.Decoder, err error) error {
+	line, column := position(data, int(decoder.InputOffset()))
+	return &ConfigError{Path: path, Line: line, Column: column, Message: err.Error()}
+}
+
+// lookupConfigField finds the type of the field named by key according to the given struct tag.
+// It returns false if the key doesn't belong to the type. Maps accept any key.
+func lookupConfigField(t reflect.Type, key string, tag string, caseInsensitive bool) (reflect.Type, bool) {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	switch t.Kind() {
+	case reflect.Map:
+		return t.Elem(), true
+	case reflect.Struct:
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			name := strings.Split(field.Tag.Get(tag), ",")[0]
+			if name == "-" {
+				continue
+			}
+			if name == "" {
+				name = field.Name
+			}
+
+			if name == key || (caseInsensitive && strings.EqualFold(name, key)) {
+				return field.Type, true
+			}
+		}
+		return nil, false
+	}
+
+	return nil, true
+}
+
+// position converts a byte offset into a 1-based line and column.
+func position(data []byte, offset int) (int, int) {
+	if offset < 0 {
+		offset = 0
+	}
+	if offset > len(data) {
+		offset = len(data)
+	}
 
-	return &config, nil
+	line := bytes.Count(data[:offset], []byte("\n")) + 1
+	column := offset - bytes.LastIndexByte(data[:offset], '\n')
+	return line, column
 }
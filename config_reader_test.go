@@ -1,7 +1,12 @@
 package main
 
 import (
+	"fmt"
 	"github.com/stretchr/testify/assert"
+	"github.com/tanin47/git-notes/internal/test_helpers"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"testing"
 )
 
@@ -12,3 +17,124 @@ func TestJsonConfigReader_Read(t *testing.T) {
 
 	assert.Equal(t, []string{"/Users/tanin/projects/personal-notes", "/Users/tanin/projects/another-personal-notes"}, config.Repos)
 }
+
+func TestYamlConfigReader_Read(t *testing.T) {
+	reader := YamlConfigReader{}
+	config, err := reader.Read("./git-notes.yaml.example")
+	assert.NoError(t, err)
+
+	assert.Equal(t, []string{"/Users/tanin/projects/personal-notes", "/Users/tanin/projects/another-personal-notes"}, config.Repos)
+}
+
+func TestTomlConfigReader_Read(t *testing.T) {
+	reader := TomlConfigReader{}
+	config, err := reader.Read("./git-notes.toml.example")
+	assert.NoError(t, err)
+
+	assert.Equal(t, []string{"/Users/tanin/projects/personal-notes", "/Users/tanin/projects/another-personal-notes"}, config.Repos)
+}
+
+func writeConfig(t *testing.T, name string, content string) (string, func()) {
+	dir, err := ioutil.TempDir("", "git-notes-config-dir")
+	assert.NoError(t, err)
+	test_helpers.WriteFile(t, dir, name, content)
+	return dir + "/" + name, func() { os.RemoveAll(dir) }
+}
+
+func TestFileConfigReader_Read(t *testing.T) {
+	repos := test_helpers.SetupRepos()
+	defer test_helpers.CleanupRepos(repos)
+
+	reader := FileConfigReader{}
+	files := map[string]string{
+		"git-notes.json": fmt.Sprintf(`{"repos": ["%s"], "notifications": {"desktop": true}}`, repos.Local),
+		"git-notes.yml":  fmt.Sprintf("repos: [%s]\nnotifications:\n  desktop: true\n", repos.Local),
+		"git-notes.yaml": fmt.Sprintf("repos: [%s]\nnotifications:\n  desktop: true\n", repos.Local),
+		"git-notes.toml": fmt.Sprintf("repos = [\"%s\"]\n[notifications]\ndesktop = true\n", repos.Local),
+	}
+
+	local, err := filepath.EvalSymlinks(repos.Local)
+	assert.NoError(t, err)
+
+	for name, content := range files {
+		path, cleanup := writeConfig(t, name, content)
+		config, err := reader.Read(path)
+		cleanup()
+
+		assert.NoError(t, err, name)
+		assert.Equal(t, &Config{Repos: []string{local}, Notifications: NotificationConfig{Desktop: true}}, config, name)
+	}
+}
+
+func TestFileConfigReader_ReadInvalidRepos(t *testing.T) {
+	path, cleanup := writeConfig(t, "git-notes.yaml", "repos: [/non-existent-notes, notes]\n")
+	defer cleanup()
+
+	_, err := (&FileConfigReader{}).Read(path)
+	assert.EqualError(t, err, path+": repos[0] (/non-existent-notes) does not exist\n"+path+": repos[1] (notes) is not an absolute path")
+}
+
+func TestJsonConfigReader_UnknownKeys(t *testing.T) {
+	path, cleanup := writeConfig(t, "git-notes.json", "{\n  \"repos\": [\"/notes\"],\n  \"notifications\": {\n    \"dekstop\": true\n  },\n  \"metrics\": \"\"\n}")
+	defer cleanup()
+
+	_, err := (&JsonConfigReader{}).Read(path)
+	assert.EqualError(t, err, path+":4:5: unknown key \"notifications.dekstop\"\n"+path+":6:3: unknown key \"metrics\"")
+}
+
+func TestJsonConfigReader_SyntaxError(t *testing.T) {
+	path, cleanup := writeConfig(t, "git-notes.json", "{\n  \"repos\": [\"/notes\",]\n}")
+	defer cleanup()
+
+	_, err := (&JsonConfigReader{}).Read(path)
+	assert.EqualError(t, err, path+":2:22: invalid character ']' looking for beginning of value")
+}
+
+func TestJsonConfigReader_TypeError(t *testing.T) {
+	path, cleanup := writeConfig(t, "git-notes.json", "{\n  \"repos\": \"/notes\"\n}")
+	defer cleanup()
+
+	_, err := (&JsonConfigReader{}).Read(path)
+	assert.EqualError(t, err, path+":2:20: repos must be []string, not string")
+}
+
+func TestYamlConfigReader_UnknownKeys(t *testing.T) {
+	path, cleanup := writeConfig(t, "git-notes.yaml", "repos:\n  - /notes\nnotifications:\n  webhok: http://localhost\nmetrics: true\n")
+	defer cleanup()
+
+	_, err := (&YamlConfigReader{}).Read(path)
+	assert.EqualError(t, err, path+":4:3: unknown key \"notifications.webhok\"\n"+path+":5:1: unknown key \"metrics\"")
+}
+
+func TestYamlConfigReader_SyntaxError(t *testing.T) {
+	path, cleanup := writeConfig(t, "git-notes.yaml", "repos:\n  - /notes\n bad indentation\n")
+	defer cleanup()
+
+	_, err := (&YamlConfigReader{}).Read(path)
+	assert.EqualError(t, err, path+":2: did not find expected key")
+}
+
+func TestYamlConfigReader_TypeError(t *testing.T) {
+	path, cleanup := writeConfig(t, "git-notes.yaml", "repos: /notes\n")
+	defer cleanup()
+
+	_, err := (&YamlConfigReader{}).Read(path)
+	assert.EqualError(t, err, path+":1: cannot unmarshal !!str `/notes` into []string")
+}
+
+func TestTomlConfigReader_UnknownKeys(t *testing.T) {
+	path, cleanup := writeConfig(t, "git-notes.toml", "repos = [\"/notes\"]\nmetrics = true\n\n[notifications]\n  desktp = true\n\n[extra]\nfoo = 1\n")
+	defer cleanup()
+
+	_, err := (&TomlConfigReader{}).Read(path)
+	assert.EqualError(t, err, path+":2:1: unknown key \"metrics\"\n"+path+":5:3: unknown key \"notifications.desktp\"\n"+path+":7:1: unknown key \"extra\"")
+}
+
+func TestTomlConfigReader_SyntaxError(t *testing.T) {
+	path, cleanup := writeConfig(t, "git-notes.toml", "repos = [\"/notes\"]\nmetrics_address = \n")
+	defer cleanup()
+
+	_, err := (&TomlConfigReader{}).Read(path)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), path+":2:")
+}
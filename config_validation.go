@@ -0,0 +1,296 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Validate runs the checks shared by every config format and reports all the problems together.
+func (c *Config) Validate(path string) error {
+	var errs ConfigErrors
+	invalid := func(format string, args ...interface{}) {
+		errs = append(errs, &ConfigError{Path: path, Message: fmt.Sprintf(format, args...)})
+	}
+
+	if len(c.Repos) == 0 && len(c.Discover.Roots) == 0 {
+		invalid("repos or discover.roots must contain at least one path")
+	}
+	for i, repo := range c.Repos {
+		if repo == "" {
+			invalid("repos[%d] is empty", i)
+		}
+	}
+
+	if c.Notifications.FailureThreshold != "" {
+		if _, err := time.ParseDuration(c.Notifications.FailureThreshold); err != nil {
+			invalid("notifications.failure_threshold is not a duration: %v", err)
+		}
+	}
+	if c.Notifications.RateLimit != "" {
+		if _, err := time.ParseDuration(c.Notifications.RateLimit); err != nil {
+			invalid("notifications.rate_limit is not a duration: %v", err)
+		}
+	}
+	if c.Discover.Interval != "" {
+		if _, err := time.ParseDuration(c.Discover.Interval); err != nil {
+			invalid("discover.interval is not a duration: %v", err)
+		}
+	}
+	if _, err := filepath.Match(c.Discover.Pattern, ""); err != nil {
+		invalid("discover.pattern is not a valid glob: %v", err)
+	}
+	for repo, options := range c.RepoOptions {
+		for i, pattern := range options.SparseCheckout {
+			if err := validateSparsePattern(pattern); err != nil {
+				invalid("repo_options[%s].sparse_checkout[%d] (%s) %v", repo, i, pattern, err)
+			}
+		}
+		if options.PartialClone != "" {
+			if err := validatePartialCloneFilter(options.PartialClone); err != nil {
+				invalid("repo_options[%s].partial_clone (%s) %v", repo, options.PartialClone, err)
+			}
+		}
+		if options.LFS.SizeThreshold != "" {
+			if _, err := ParseSize(options.LFS.SizeThreshold); err != nil {
+				invalid("repo_options[%s].lfs.size_threshold: %v", repo, err)
+			}
+		}
+		if options.Encryption.Enabled() && options.Encryption.KeyFile == "" {
+			invalid("repo_options[%s].encryption.key_file is required", repo)
+		}
+		for _, err := range options.Schedule.Validate() {
+			invalid("repo_options[%s].schedule.%v", repo, err)
+		}
+		if m := options.InterruptedMerge; m != "" && m != InterruptedMergeContinue && m != InterruptedMergeAbort {
+			invalid("repo_options[%s].interrupted_merge (%s) must be continue or abort", repo, m)
+		}
+		mergePatterns := []struct {
+			name     string
+			patterns []string
+		}{
+			{"text", options.Merge.Text},
+			{"union", options.Merge.Union},
+		}
+		for _, m := range mergePatterns {
+			for i, pattern := range m.patterns {
+				if strings.TrimSpace(pattern) == "" || strings.ContainsAny(pattern, " \t") {
+					invalid("repo_options[%s].merge.%s[%d] (%s) must be a .gitattributes pattern without spaces", repo, m.name, i, pattern)
+				}
+			}
+		}
+	}
+	for i, pattern := range c.SecretScan.Allowlist {
+		if _, err := regexp.Compile(pattern); err != nil {
+			invalid("secret_scan.allowlist[%d] is not a valid regular expression: %v", i, err)
+		}
+	}
+	for i, pattern := range c.SecretScan.AllowPaths {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			invalid("secret_scan.allow_paths[%d] is not a valid glob: %v", i, err)
+		}
+	}
+	if c.SecretScan.EntropyThreshold < 0 {
+		invalid("secret_scan.entropy_threshold must not be negative")
+	}
+	deviceRules := []struct {
+		name string
+		rule DeviceRule
+	}{
+		{"on_battery", c.DevicePolicy.OnBattery},
+		{"low_battery", c.DevicePolicy.LowBattery},
+		{"metered", c.DevicePolicy.Metered},
+	}
+	for _, r := range deviceRules {
+		if r.rule.SlowDown != 0 && r.rule.SlowDown < 1 {
+			invalid("device_policy.%s.slow_down must be at least 1", r.name)
+		}
+	}
+	if c.DevicePolicy.LowBatteryPercent < 0 || c.DevicePolicy.LowBatteryPercent > 100 {
+		invalid("device_policy.low_battery_percent must be between 0 and 100")
+	}
+	if c.ManualActivity.GracePeriod != "" {
+		if _, err := time.ParseDuration(c.ManualActivity.GracePeriod); err != nil {
+			invalid("manual_activity.grace_period is not a duration: %v", err)
+		}
+	}
+	if c.DeletionGuard.MaxFiles < 0 {
+		invalid("deletion_guard.max_files must not be negative")
+	}
+	if c.DeletionGuard.MaxPercent < 0 || c.DeletionGuard.MaxPercent > 100 {
+		invalid("deletion_guard.max_percent must be between 0 and 100")
+	}
+	for i, arg := range c.Notifications.Command {
+		if i == 0 && arg == "" {
+			invalid("notifications.command must start with a program")
+		}
+	}
+
+	return errs.orNil()
+}
+
+// ResolveRepos expands `~` and environment variables in the repo paths, resolves symlinks, and checks
+// that every repo is the root of a git work tree with the remote that its branch syncs with. The repo
+// paths are replaced with the resolved ones. The discovery roots are expanded and must be directories. The keys of the
+// repo options are resolved in the same way as the repo paths.
+func (c *Config) ResolveRepos(path string) error {
+	var errs ConfigErrors
+	invalid := func(format string, args ...interface{}) {
+		errs = append(errs, &ConfigError{Path: path, Message: fmt.Sprintf(format, args...)})
+	}
+
+	resolved := make([]string, len(c.Repos))
+	for i, repo := range c.Repos {
+		expanded, err := expandPath(repo)
+		if err != nil {
+			invalid("repos[%d] (%s): %v", i, repo, err)
+			continue
+		}
+		if !filepath.IsAbs(expanded) {
+			invalid("repos[%d] (%s) is not an absolute path", i, repo)
+			continue
+		}
+
+		real, err := filepath.EvalSymlinks(expanded)
+		if err != nil {
+			invalid("repos[%d] (%s) does not exist", i, repo)
+			continue
+		}
+		if info, err := os.Stat(real); err != nil || !info.IsDir() {
+			invalid("repos[%d] (%s) is not a directory", i, repo)
+			continue
+		}
+
+		err = checkGitWorkTree(real)
+		if err != nil {
+			invalid("repos[%d] (%s) %v", i, repo, err)
+			continue
+		}
+
+		resolved[i] = real
+	}
+
+	duplicate := make([]bool, len(resolved))
+	for i := range resolved {
+		for j := 0; j < i; j++ {
+			if resolved[i] == "" || resolved[j] == "" || duplicate[j] {
+				continue
+			}
+			if resolved[i] == resolved[j] {
+				invalid("repos[%d] (%s) is a duplicate of repos[%d] (%s)", i, c.Repos[i], j, c.Repos[j])
+				duplicate[i] = true
+				break
+			} else if isInside(resolved[i], resolved[j]) {
+				invalid("repos[%d] (%s) is nested inside repos[%d] (%s)", i, c.Repos[i], j, c.Repos[j])
+			} else if isInside(resolved[j], resolved[i]) {
+				invalid("repos[%d] (%s) is nested inside repos[%d] (%s)", j, c.Repos[j], i, c.Repos[i])
+			}
+		}
+	}
+
+	var roots []string
+	for i, root := range c.Discover.Roots {
+		expanded, err := expandPath(root)
+		if err != nil {
+			invalid("discover.roots[%d] (%s): %v", i, root, err)
+			continue
+		}
+		if !filepath.IsAbs(expanded) {
+			invalid("discover.roots[%d] (%s) is not an absolute path", i, root)
+			continue
+		}
+		if info, err := os.Stat(expanded); err != nil || !info.IsDir() {
+			invalid("discover.roots[%d] (%s) is not a directory", i, root)
+			continue
+		}
+		roots = append(roots, expanded)
+	}
+
+	var repoOptions map[string]RepoOptions
+	for repo, options := range c.RepoOptions {
+		expanded, err := expandPath(repo)
+		if err != nil {
+			invalid("repo_options[%s]: %v", repo, err)
+			continue
+		}
+		real, err := filepath.EvalSymlinks(expanded)
+		if err != nil {
+			invalid("repo_options[%s] names a repo that does not exist", repo)
+			continue
+		}
+		if options.Encryption.KeyFile != "" {
+			keyFile, err := expandPath(options.Encryption.KeyFile)
+			if err == nil {
+				_, err = ReadKey(keyFile)
+			}
+			if err != nil {
+				invalid("repo_options[%s].encryption.key_file: %v", repo, err)
+				continue
+			}
+			options.Encryption.KeyFile = keyFile
+		}
+		if repoOptions == nil {
+			repoOptions = map[string]RepoOptions{}
+		}
+		repoOptions[real] = options
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+	c.Repos = resolved
+	c.Discover.Roots = roots
+	c.RepoOptions = repoOptions
+	return nil
+}
+
+func expandPath(path string) (string, error) {
+	path = os.ExpandEnv(path)
+	if path == "~" || strings.HasPrefix(path, "~/") {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", fmt.Errorf("unable to expand ~. Err: %v", err)
+		}
+		path = home + path[1:]
+	}
+	return filepath.Clean(path), nil
+}
+
+func isInside(path string, parent string) bool {
+	return strings.HasPrefix(path, strings.TrimSuffix(parent, string(filepath.Separator))+string(filepath.Separator))
+}
+
+func checkGitWorkTree(path string) error {
+	out, err := runCmd(path, "git", "rev-parse", "--show-toplevel")
+	if err != nil {
+		return fmt.Errorf("is not a git work tree")
+	}
+
+	toplevel, err := filepath.EvalSymlinks(strings.TrimSpace(out))
+	if err != nil || toplevel != path {
+		return fmt.Errorf("is not the root of its git work tree (%s)", strings.TrimSpace(out))
+	}
+
+	remotes, err := Remotes(path)
+	if err != nil {
+		return err
+	}
+	if len(remotes) == 0 {
+		return fmt.Errorf("has no remote")
+	}
+
+	// A detached HEAD isn't synced, so any remote will do until a branch is checked out.
+	tracking, err := CurrentTracking(path)
+	if err != nil {
+		return nil
+	}
+	for _, remote := range remotes {
+		if remote == tracking.Remote {
+			return nil
+		}
+	}
+	return fmt.Errorf("syncs with the remote %s, which doesn't exist", tracking.Remote)
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/tanin47/git-notes/internal/test_helpers"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestConfig_Validate(t *testing.T) {
+	config := Config{
+		Repos: []string{"/notes", ""},
+		Notifications: NotificationConfig{
+			FailureThreshold: "an hour",
+			Command:          []string{""},
+		},
+	}
+
+	err := config.Validate("git-notes.json")
+	assert.Error(t, err)
+	assert.Equal(t, 3, len(err.(ConfigErrors)))
+	assert.Contains(t, err.Error(), "git-notes.json: repos[1] is empty")
+	assert.Contains(t, err.Error(), "git-notes.json: notifications.failure_threshold is not a duration")
+	assert.Contains(t, err.Error(), "git-notes.json: notifications.command must start with a program")
+}
+
+func TestConfig_ValidateNoRepos(t *testing.T) {
+	config := Config{}
+	assert.EqualError(t, config.Validate("git-notes.json"), "git-notes.json: repos or discover.roots must contain at least one path")
+}
+
+func resolvedPath(t *testing.T, path string) string {
+	resolved, err := filepath.EvalSymlinks(path)
+	assert.NoError(t, err)
+	return resolved
+}
+
+func TestConfig_ResolveRepos(t *testing.T) {
+	repos := test_helpers.SetupRepos()
+	defer test_helpers.CleanupRepos(repos)
+
+	linkDir, err := ioutil.TempDir("", "git-notes-link-dir")
+	assert.NoError(t, err)
+	defer os.RemoveAll(linkDir)
+	assert.NoError(t, os.Symlink(repos.Local, linkDir+"/notes"))
+
+	oldHome := os.Getenv("HOME")
+	assert.NoError(t, os.Setenv("HOME", linkDir))
+	defer os.Setenv("HOME", oldHome)
+	assert.NoError(t, os.Setenv("GIT_NOTES_TEST_DIR", linkDir))
+	defer os.Unsetenv("GIT_NOTES_TEST_DIR")
+
+	for _, repo := range []string{repos.Local, linkDir + "/notes", "~/notes", "$GIT_NOTES_TEST_DIR/notes"} {
+		config := Config{Repos: []string{repo}}
+		assert.NoError(t, config.ResolveRepos("git-notes.json"), repo)
+		assert.Equal(t, []string{resolvedPath(t, repos.Local)}, config.Repos, repo)
+	}
+}
+
+func TestCheckGitWorkTree_Remotes(t *testing.T) {
+	repo := test_helpers.SetupGitRepo("remotes", false)
+	defer os.RemoveAll(repo)
+	repo = resolvedPath(t, repo)
+
+	test_helpers.PerformCmd(t, repo, "git", "remote", "add", "upstream", "/somewhere")
+	assert.NoError(t, checkGitWorkTree(repo))
+
+	test_helpers.PerformCmd(t, repo, "git", "remote", "add", "backup", "/elsewhere")
+	assert.EqualError(t, checkGitWorkTree(repo), "syncs with the remote origin, which doesn't exist")
+
+	test_helpers.PerformCmd(t, repo, "git", "config", "branch.master.remote", "backup")
+	assert.NoError(t, checkGitWorkTree(repo))
+}
+
+func TestConfig_ResolveReposReportsAllProblems(t *testing.T) {
+	repos := test_helpers.SetupRepos()
+	defer test_helpers.CleanupRepos(repos)
+
+	noRemote := test_helpers.SetupGitRepo("no_remote", false)
+	defer os.RemoveAll(noRemote)
+
+	notRepo, err := ioutil.TempDir("", "git-notes-not-repo")
+	assert.NoError(t, err)
+	defer os.RemoveAll(notRepo)
+
+	nested := repos.Local + "/nested"
+	assert.NoError(t, os.Mkdir(nested, 0755))
+	test_helpers.PerformCmd(t, nested, "git", "init")
+	test_helpers.SetupRemote(nested, repos.Remote)
+
+	subdir := repos.Local + "/subdir"
+	assert.NoError(t, os.Mkdir(subdir, 0755))
+
+	config := Config{Repos: []string{
+		repos.Local,
+		"relative/notes",
+		"/non-existent-notes",
+		noRemote,
+		notRepo,
+		repos.Local + "/",
+		nested,
+		subdir,
+	}}
+	err = config.ResolveRepos("git-notes.json")
+
+	assert.Equal(t, ConfigErrors{
+		&ConfigError{Path: "git-notes.json", Message: "repos[1] (relative/notes) is not an absolute path"},
+		&ConfigError{Path: "git-notes.json", Message: "repos[2] (/non-existent-notes) does not exist"},
+		&ConfigError{Path: "git-notes.json", Message: fmt.Sprintf("repos[3] (%s) has no remote", noRemote)},
+		&ConfigError{Path: "git-notes.json", Message: fmt.Sprintf("repos[4] (%s) is not a git work tree", notRepo)},
+		&ConfigError{Path: "git-notes.json", Message: fmt.Sprintf("repos[7] (%s) is not the root of its git work tree (%s)", subdir, resolvedPath(t, repos.Local))},
+		&ConfigError{Path: "git-notes.json", Message: fmt.Sprintf("repos[5] (%s/) is a duplicate of repos[0] (%s)", repos.Local, repos.Local)},
+		&ConfigError{Path: "git-notes.json", Message: fmt.Sprintf("repos[6] (%s) is nested inside repos[0] (%s)", nested, repos.Local)},
+	}, err)
+	assert.Equal(t, repos.Local, config.Repos[0])
+}
+
+func TestConfig_ValidateDiscover(t *testing.T) {
+	config := Config{Discover: DiscoverConfig{Roots: []string{"/notes"}, Pattern: "[", Interval: "often"}}
+
+	err := config.Validate("git-notes.json")
+	assert.Equal(t, 2, len(err.(ConfigErrors)))
+	assert.Contains(t, err.Error(), "git-notes.json: discover.interval is not a duration")
+	assert.Contains(t, err.Error(), "git-notes.json: discover.pattern is not a valid glob")
+}
+
+func TestConfig_ResolveReposDiscoverRoots(t *testing.T) {
+	root, err := ioutil.TempDir("", "git-notes-root")
+	assert.NoError(t, err)
+	defer os.RemoveAll(root)
+
+	oldHome := os.Getenv("HOME")
+	assert.NoError(t, os.Setenv("HOME", root))
+	defer os.Setenv("HOME", oldHome)
+
+	config := Config{Discover: DiscoverConfig{Roots: []string{"~", "/non-existent-root"}}}
+	assert.EqualError(t, config.ResolveRepos("git-notes.json"), "git-notes.json: discover.roots[1] (/non-existent-root) is not a directory")
+
+	config = Config{Discover: DiscoverConfig{Roots: []string{"~"}}}
+	assert.NoError(t, config.ResolveRepos("git-notes.json"))
+	assert.Equal(t, []string{root}, config.Discover.Roots)
+}
+
+func TestConfig_ValidateSecretScan(t *testing.T) {
+	config := Config{
+		Repos:      []string{"/notes"},
+		SecretScan: SecretScanConfig{Allowlist: []string{"("}, AllowPaths: []string{"["}, EntropyThreshold: -1},
+	}
+
+	err := config.Validate("git-notes.json")
+	assert.Equal(t, 3, len(err.(ConfigErrors)))
+	assert.Contains(t, err.Error(), "git-notes.json: secret_scan.allowlist[0] is not a valid regular expression")
+	assert.Contains(t, err.Error(), "git-notes.json: secret_scan.allow_paths[0] is not a valid glob")
+	assert.Contains(t, err.Error(), "git-notes.json: secret_scan.entropy_threshold must not be negative")
+}
+
+func TestConfig_ValidateSchedule(t *testing.T) {
+	config := Config{
+		Repos:       []string{"/notes"},
+		RepoOptions: map[string]RepoOptions{"/notes": {Schedule: ScheduleOptions{PushDays: []string{"someday"}}}},
+	}
+
+	assert.EqualError(t, config.Validate("git-notes.json"), "git-notes.json: repo_options[/notes].schedule.push_days[0]: someday is not a weekday")
+}
+
+func TestConfig_ValidateMerge(t *testing.T) {
+	config := Config{
+		Repos:       []string{"/notes"},
+		RepoOptions: map[string]RepoOptions{"/notes": {Merge: MergeOptions{Text: []string{"*.md", "my notes/*.md"}, Union: []string{""}}}},
+	}
+
+	err := config.Validate("git-notes.json")
+	assert.Contains(t, err.Error(), "git-notes.json: repo_options[/notes].merge.text[1] (my notes/*.md) must be a .gitattributes pattern without spaces")
+	assert.Contains(t, err.Error(), "git-notes.json: repo_options[/notes].merge.union[0] () must be a .gitattributes pattern without spaces")
+}
+
+func TestConfig_ValidateInterruptedMerge(t *testing.T) {
+	config := Config{
+		Repos:       []string{"/notes"},
+		RepoOptions: map[string]RepoOptions{"/notes": {InterruptedMerge: "retry"}},
+	}
+	assert.EqualError(t, config.Validate("git-notes.json"), "git-notes.json: repo_options[/notes].interrupted_merge (retry) must be continue or abort")
+
+	config.RepoOptions["/notes"] = RepoOptions{InterruptedMerge: InterruptedMergeAbort}
+	assert.NoError(t, config.Validate("git-notes.json"))
+}
+
+func TestConfig_ValidateManualActivity(t *testing.T) {
+	config := Config{
+		Repos:          []string{"/notes"},
+		ManualActivity: ManualActivityConfig{GracePeriod: "soon"},
+	}
+	assert.EqualError(t, config.Validate("git-notes.json"), `git-notes.json: manual_activity.grace_period is not a duration: time: invalid duration "soon"`)
+}
+
+func TestConfig_ValidateDevicePolicy(t *testing.T) {
+	config := Config{
+		Repos:        []string{"/notes"},
+		DevicePolicy: DevicePolicyConfig{LowBattery: DeviceRule{SlowDown: 0.5}, Metered: DeviceRule{SlowDown: -1}, LowBatteryPercent: 101},
+	}
+
+	err := config.Validate("git-notes.json")
+	assert.Equal(t, ConfigErrors{
+		&ConfigError{Path: "git-notes.json", Message: "device_policy.low_battery.slow_down must be at least 1"},
+		&ConfigError{Path: "git-notes.json", Message: "device_policy.metered.slow_down must be at least 1"},
+		&ConfigError{Path: "git-notes.json", Message: "device_policy.low_battery_percent must be between 0 and 100"},
+	}, err)
+}
+
+func TestConfig_ValidateDeletionGuard(t *testing.T) {
+	config := Config{Repos: []string{"/notes"}, DeletionGuard: DeletionGuardConfig{MaxFiles: -1, MaxPercent: 150}}
+
+	err := config.Validate("git-notes.json")
+	assert.Equal(t, ConfigErrors{
+		&ConfigError{Path: "git-notes.json", Message: "deletion_guard.max_files must not be negative"},
+		&ConfigError{Path: "git-notes.json", Message: "deletion_guard.max_percent must be between 0 and 100"},
+	}, err)
+}
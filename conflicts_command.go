@@ -0,0 +1,461 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+func init() {
+	commands["conflicts"] = Command{
+		Usage: "conflicts <repo> [--show base|ours|theirs <file>]",
+		Run:   runConflicts,
+	}
+	commands["resolve"] = Command{
+		Usage: "resolve <file> --ours|--theirs|--both|--edit",
+		Run:   runResolve,
+	}
+}
+
+// Conflict is a file that a merge left with conflict markers. Base, Ours, and Theirs are the commits of
+// the three versions. Base is empty when the branches have no common ancestor.
+type Conflict struct {
+	File       string    `json:"file"`
+	Base       string    `json:"base,omitempty"`
+	Ours       string    `json:"ours"`
+	Theirs     string    `json:"theirs"`
+	DetectedAt time.Time `json:"detected_at"`
+}
+
+// Version returns the commit of the base, ours, or theirs version.
+func (c Conflict) Version(name string) (string, error) {
+	switch name {
+	case "base":
+		if c.Base == "" {
+			return "", fmt.Errorf("%s has no base version because the branches have no common ancestor", c.File)
+		}
+		return c.Base, nil
+	case "ours":
+		return c.Ours, nil
+	case "theirs":
+		return c.Theirs, nil
+	}
+	return "", fmt.Errorf("%s is not a version. The versions are base, ours, and theirs", name)
+}
+
+// ConflictStore keeps the conflicts in a JSON file in the state directory. The daemon records them when
+// a merge leaves conflict markers, because the merge is committed right after and git forgets which
+// files were conflicted.
+type ConflictStore struct {
+	file *JSONStore
+}
+
+func NewConflictStore(dir string) *ConflictStore {
+	return &ConflictStore{file: NewJSONStore(filepath.Join(dir, "conflicts.json"))}
+}
+
+func DefaultConflictStore() (*ConflictStore, error) {
+	dir, err := StateDir()
+	if err != nil {
+		return nil, err
+	}
+	return NewConflictStore(dir), nil
+}
+
+func (s *ConflictStore) Load() (map[string][]Conflict, error) {
+	conflicts := map[string][]Conflict{}
+	err := s.file.Load(&conflicts)
+	if err != nil {
+		return nil, err
+	}
+	return conflicts, nil
+}
+
+// update writes the file only if fn reports a change.
+func (s *ConflictStore) update(fn func(conflicts map[string][]Conflict) bool) error {
+	conflicts := map[string][]Conflict{}
+	return s.file.Update(&conflicts, func() (bool, error) {
+		return fn(conflicts), nil
+	})
+}
+
+// Record adds the conflicts of the repo. A newer conflict of the same file replaces the older one.
+func (s *ConflictStore) Record(repo string, added []Conflict) error {
+	return s.update(func(conflicts map[string][]Conflict) bool {
+		files := map[string]bool{}
+		for _, conflict := range added {
+			files[conflict.File] = true
+		}
+		var kept []Conflict
+		for _, conflict := range conflicts[repo] {
+			if !files[conflict.File] {
+				kept = append(kept, conflict)
+			}
+		}
+		conflicts[repo] = append(kept, added...)
+		return true
+	})
+}
+
+// Resolve forgets the conflicts of the files.
+func (s *ConflictStore) Resolve(repo string, files ...string) error {
+	return s.update(func(conflicts map[string][]Conflict) bool {
+		var kept []Conflict
+		for _, conflict := range conflicts[repo] {
+			if !containsString(files, conflict.File) {
+				kept = append(kept, conflict)
+			}
+		}
+		if len(kept) == len(conflicts[repo]) {
+			return false
+		}
+		if len(kept) == 0 {
+			delete(conflicts, repo)
+		} else {
+			conflicts[repo] = kept
+		}
+		return true
+	})
+}
+
+// mergeVersions returns the commits of an unfinished merge.
+func mergeVersions(path string) (string, string, string, error) {
+	out, err := runCmd(path, "git", "rev-parse", "HEAD", "MERGE_HEAD")
+	if err != nil {
+		return "", "", "", fmt.Errorf("unable to find the merged commits. Out: %s, Err: %v", out, err)
+	}
+	commits := strings.Fields(out)
+	if len(commits) != 2 {
+		return "", "", "", fmt.Errorf("unable to parse the merged commits: %s", out)
+	}
+
+	base := ""
+	out, err = runCmd(path, "git", "merge-base", commits[0], commits[1])
+	if err == nil {
+		base = strings.TrimSpace(out)
+	}
+	return base, commits[0], commits[1], nil
+}
+
+// RecordConflicts remembers the conflicted files of the unfinished merge.
+func RecordConflicts(store *ConflictStore, path string, files []string, now time.Time) error {
+	base, ours, theirs, err := mergeVersions(path)
+	if err != nil {
+		return err
+	}
+
+	var conflicts []Conflict
+	for _, file := range files {
+		conflicts = append(conflicts, Conflict{File: file, Base: base, Ours: ours, Theirs: theirs, DetectedAt: now})
+	}
+	return store.Record(path, conflicts)
+}
+
+var conflictStart = regexp.MustCompile(`(?m)^<{7}( |$)`)
+var conflictEnd = regexp.MustCompile(`(?m)^>{7}( |$)`)
+
+func hasConflictMarkers(content []byte) bool {
+	return conflictStart.Match(content) && conflictEnd.Match(content)
+}
+
+// UnresolvedConflicts lists the recorded conflicts whose files still have conflict markers. The others
+// have been resolved by hand, and the next sync forgets them. It only reads the store.
+func UnresolvedConflicts(store *ConflictStore, repo string) ([]Conflict, error) {
+	all, err := store.Load()
+	if err != nil {
+		return nil, err
+	}
+
+	var unresolved []Conflict
+	for _, conflict := range all[repo] {
+		if conflictUnresolved(repo, conflict) {
+			unresolved = append(unresolved, conflict)
+		}
+	}
+	sort.Slice(unresolved, func(i, j int) bool { return unresolved[i].File < unresolved[j].File })
+	return unresolved, nil
+}
+
+// ForgetResolvedConflicts forgets the recorded conflicts whose files no longer have conflict markers and
+// returns their files.
+func ForgetResolvedConflicts(store *ConflictStore, repo string) ([]string, error) {
+	all, err := store.Load()
+	if err != nil {
+		return nil, err
+	}
+
+	var resolved []string
+	for _, conflict := range all[repo] {
+		if !conflictUnresolved(repo, conflict) {
+			resolved = append(resolved, conflict.File)
+		}
+	}
+	if len(resolved) == 0 {
+		return nil, nil
+	}
+	return resolved, store.Resolve(repo, resolved...)
+}
+
+func conflictUnresolved(repo string, conflict Conflict) bool {
+	content, err := ioutil.ReadFile(filepath.Join(repo, filepath.FromSlash(conflict.File)))
+	return err == nil && hasConflictMarkers(content)
+}
+
+// ResolveMarkers replaces every conflict with our side, their side, or both sides, ours first. The base
+// section of the diff3 style is dropped.
+func ResolveMarkers(content []byte, keep string) ([]byte, error) {
+	const (
+		outside = iota
+		inOurs
+		inBase
+		inTheirs
+	)
+	marker := func(line string, char string) bool {
+		line = strings.TrimRight(line, "\r\n")
+		prefix := strings.Repeat(char, 7)
+		return line == prefix || strings.HasPrefix(line, prefix+" ")
+	}
+
+	var result, ours, theirs []string
+	section := outside
+	for _, line := range splitLinesKeepEnds(content) {
+		switch {
+		case section == outside && marker(line, "<"):
+			section = inOurs
+			ours, theirs = nil, nil
+		case section == inOurs && marker(line, "|"):
+			section = inBase
+		case (section == inOurs || section == inBase) && marker(line, "="):
+			section = inTheirs
+		case section == inTheirs && marker(line, ">"):
+			section = outside
+			switch keep {
+			case "ours":
+				result = append(result, ours...)
+			case "theirs":
+				result = append(result, theirs...)
+			case "both":
+				result = append(result, withFinalNewline(ours)...)
+				result = append(result, theirs...)
+			default:
+				return nil, fmt.Errorf("%s is not a side. The sides are ours, theirs, and both", keep)
+			}
+		case section == outside:
+			result = append(result, line)
+		case section == inOurs:
+			ours = append(ours, line)
+		case section == inTheirs:
+			theirs = append(theirs, line)
+		}
+	}
+	if section != outside {
+		return nil, errors.New("a conflict has no end marker")
+	}
+	return []byte(strings.Join(result, "")), nil
+}
+
+// FinishResolve commits the resolved file, which also finishes the merge if the daemon couldn't commit
+// it. The daemon then pushes the commit on its next sync. While a merge has other conflicted files, the
+// file is only staged.
+func FinishResolve(repo string, file string) error {
+	out, err := runCmd(repo, "git", "add", "--", file)
+	if err != nil {
+		return fmt.Errorf("unable to stage %s. Out: %s, Err: %v", file, out, err)
+	}
+
+	_, err = runCmd(repo, "git", "rev-parse", "--verify", "--quiet", "MERGE_HEAD")
+	merging := err == nil
+	if merging {
+		conflicted, err := ConflictedFiles(repo)
+		if err != nil {
+			return err
+		}
+		if len(conflicted) > 0 {
+			log.Printf("%s still has conflicts in %s", repo, strings.Join(conflicted, ", "))
+			return nil
+		}
+	} else if _, err := runCmd(repo, "git", "diff", "--cached", "--quiet", "--", file); err == nil {
+		return nil
+	}
+	return Commit(repo, stdLogger())
+}
+
+// ResolveConflict resolves the conflict markers of the file by keeping ours, theirs, or both, or by
+// letting the user edit them.
+func ResolveConflict(repo string, file string, keep string) error {
+	path := filepath.Join(repo, filepath.FromSlash(file))
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if !hasConflictMarkers(content) {
+		return fmt.Errorf("%s has no conflict markers", file)
+	}
+
+	if keep == "edit" {
+		err = editFile(path)
+		if err != nil {
+			return err
+		}
+		content, err = ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		if hasConflictMarkers(content) {
+			return fmt.Errorf("%s still has conflict markers", file)
+		}
+	} else {
+		resolved, err := ResolveMarkers(content, keep)
+		if err != nil {
+			return fmt.Errorf("unable to resolve %s. Err: %v", file, err)
+		}
+		err = ioutil.WriteFile(path, resolved, 0644)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = FinishResolve(repo, file)
+	if err != nil {
+		return err
+	}
+	store, err := DefaultConflictStore()
+	if err != nil {
+		return err
+	}
+	return store.Resolve(repo, file)
+}
+
+// editFile opens the file in $VISUAL or $EDITOR, or vi, and waits until the editor exits.
+func editFile(path string) error {
+	editor := os.Getenv("VISUAL")
+	if editor == "" {
+		editor = os.Getenv("EDITOR")
+	}
+	if editor == "" {
+		editor = "vi"
+	}
+
+	// The editor may have arguments, e.g. `code --wait`.
+	cmd := exec.Command("sh", "-c", editor+` "$1"`, "sh", path)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	err := cmd.Run()
+	if err != nil {
+		return fmt.Errorf("%s failed. Err: %v", editor, err)
+	}
+	return nil
+}
+
+func runConflicts(args []string) error {
+	flags := flag.NewFlagSet("conflicts", flag.ContinueOnError)
+	show := flags.String("show", "", "Print the base, ours, or theirs version of the file")
+
+	positional, err := parseInterspersed(flags, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) < 1 || len(positional) > 2 || (*show == "") != (len(positional) == 1) {
+		return fmt.Errorf("usage: git-notes %s", commands["conflicts"].Usage)
+	}
+
+	repo, err := resolveRepoArg(positional[0])
+	if err != nil {
+		return err
+	}
+	store, err := DefaultConflictStore()
+	if err != nil {
+		return err
+	}
+	conflicts, err := UnresolvedConflicts(store, repo)
+	if err != nil {
+		return err
+	}
+
+	if *show != "" {
+		fileRepo, file, err := noteInRepo(positional[1])
+		if err != nil {
+			return err
+		}
+		for _, conflict := range conflicts {
+			if fileRepo != repo || conflict.File != file {
+				continue
+			}
+			commit, err := conflict.Version(*show)
+			if err != nil {
+				return err
+			}
+			// The filters run, so encrypted notes are shown decrypted.
+			out, err := runCmdStdout(repo, "git", "cat-file", "--filters", commit+":"+file)
+			if err != nil {
+				return fmt.Errorf("%s doesn't exist in the %s version. Err: %v", file, *show, err)
+			}
+			_, err = stdout.Write(out)
+			return err
+		}
+		return fmt.Errorf("%s has no unresolved conflict", positional[1])
+	}
+
+	if len(conflicts) == 0 {
+		fmt.Fprintf(stdout, "%s has no unresolved conflicts.\n", repo)
+		return nil
+	}
+	for _, conflict := range conflicts {
+		fmt.Fprintf(stdout, "%s  (since %s)\n", conflict.File, conflict.DetectedAt.Local().Format("2006-01-02 15:04:05"))
+		for _, name := range []string{"base", "ours", "theirs"} {
+			if commit, err := conflict.Version(name); err == nil {
+				fmt.Fprintf(stdout, "    %-7s %s\n", name+":", shortCommits([]string{commit})[0])
+			}
+		}
+	}
+	return nil
+}
+
+func runResolve(args []string) error {
+	flags := flag.NewFlagSet("resolve", flag.ContinueOnError)
+	ours := flags.Bool("ours", false, "Keep our side of every conflict")
+	theirs := flags.Bool("theirs", false, "Keep their side of every conflict")
+	both := flags.Bool("both", false, "Keep both sides of every conflict, ours first")
+	edit := flags.Bool("edit", false, "Edit the conflicts in $VISUAL or $EDITOR")
+
+	positional, err := parseInterspersed(flags, args)
+	if err != nil {
+		return err
+	}
+	var keep []string
+	for name, set := range map[string]bool{"ours": *ours, "theirs": *theirs, "both": *both, "edit": *edit} {
+		if set {
+			keep = append(keep, name)
+		}
+	}
+	if len(positional) != 1 || len(keep) != 1 {
+		return fmt.Errorf("usage: git-notes %s", commands["resolve"].Usage)
+	}
+
+	repo, file, err := noteInRepo(positional[0])
+	if err != nil {
+		return err
+	}
+	unlock, err := lockRepo(repo)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	err = ResolveConflict(repo, file, keep[0])
+	if err != nil {
+		return err
+	}
+	log.Printf("Resolved %s. It's pushed on the next sync", file)
+	return nil
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"github.com/tanin47/git-notes/internal/test_helpers"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+const conflicted = "a\n<<<<<<< HEAD\nours 1\nours 2\n=======\ntheirs\n>>>>>>> origin/master\nb\n<<<<<<< HEAD\nours 3\n||||||| base\nbase 3\n=======\ntheirs 3\n>>>>>>> origin/master\n"
+
+func TestResolveMarkers(t *testing.T) {
+	cases := map[string]string{
+		"ours":   "a\nours 1\nours 2\nb\nours 3\n",
+		"theirs": "a\ntheirs\nb\ntheirs 3\n",
+		"both":   "a\nours 1\nours 2\ntheirs\nb\nours 3\ntheirs 3\n",
+	}
+	for keep, expected := range cases {
+		resolved, err := ResolveMarkers([]byte(conflicted), keep)
+		assert.NoError(t, err)
+		assert.Equal(t, expected, string(resolved), keep)
+	}
+
+	_, err := ResolveMarkers([]byte(conflicted), "mine")
+	assert.EqualError(t, err, "mine is not a side. The sides are ours, theirs, and both")
+	_, err = ResolveMarkers([]byte("<<<<<<< HEAD\nours\n=======\n"), "ours")
+	assert.EqualError(t, err, "a conflict has no end marker")
+}
+
+func TestHasConflictMarkers(t *testing.T) {
+	assert.True(t, hasConflictMarkers([]byte(conflicted)))
+	assert.False(t, hasConflictMarkers([]byte("a\n<<<<<<<< not a marker\n>>>>>>>> not a marker\n")))
+	assert.False(t, hasConflictMarkers([]byte("a\n=======\nb\n")))
+}
+
+func TestConflictStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "git-notes-conflicts")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	store := NewConflictStore(dir)
+
+	assert.NoError(t, store.Record("/notes", []Conflict{{File: "a.md", Ours: "1"}, {File: "b.md", Ours: "1"}}))
+	assert.NoError(t, store.Record("/notes", []Conflict{{File: "a.md", Ours: "2"}}))
+	conflicts, err := store.Load()
+	assert.NoError(t, err)
+	assert.Equal(t, []Conflict{{File: "b.md", Ours: "1"}, {File: "a.md", Ours: "2"}}, conflicts["/notes"])
+
+	assert.NoError(t, store.Resolve("/notes", "a.md", "b.md"))
+	conflicts, err = store.Load()
+	assert.NoError(t, err)
+	assert.Empty(t, conflicts)
+}
+
+func setupConflict(t *testing.T, repos test_helpers.Repos) {
+	test_helpers.WriteFile(t, repos.Local, "test.md", "TestContent")
+	test_helpers.PerformCmd(t, repos.Local, "git", "add", "--all")
+	test_helpers.PerformCmd(t, repos.Local, "git", "commit", "-m", "Test local")
+	test_helpers.PerformCmd(t, repos.Local, "git", "push", "origin", "master", "-u")
+
+	makeConflict(t, repos.Remote)
+
+	test_helpers.WriteFile(t, repos.Local, "test.md", "TestContent2")
+	performSync(t, repos.Local)
+}
+
+func TestConflicts_ResolveTheirs(t *testing.T) {
+	repos := test_helpers.SetupRepos()
+	defer test_helpers.CleanupRepos(repos)
+	setupConflict(t, repos)
+
+	store, err := DefaultConflictStore()
+	assert.NoError(t, err)
+	conflicts, err := UnresolvedConflicts(store, repos.Local)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(conflicts))
+	assert.Equal(t, "test.md", conflicts[0].File)
+	assert.WithinDuration(t, time.Now(), conflicts[0].DetectedAt, time.Minute)
+
+	ours, err := runCmd(repos.Local, "git", "show", conflicts[0].Ours+":test.md")
+	assert.NoError(t, err)
+	assert.Equal(t, "TestContent2", ours)
+	theirs, err := runCmd(repos.Local, "git", "show", conflicts[0].Theirs+":test.md")
+	assert.NoError(t, err)
+	assert.Equal(t, "Cause conflict", theirs)
+	base, err := runCmd(repos.Local, "git", "show", conflicts[0].Base+":test.md")
+	assert.NoError(t, err)
+	assert.Equal(t, "TestContent", base)
+
+	var out bytes.Buffer
+	stdout = &out
+	defer func() { stdout = os.Stdout }()
+	assert.NoError(t, runConflicts([]string{repos.Local}))
+	assert.True(t, strings.HasPrefix(out.String(), "test.md  (since "), out.String())
+	assert.Contains(t, out.String(), "    theirs: "+conflicts[0].Theirs[:7]+"\n")
+
+	out.Reset()
+	assert.NoError(t, runConflicts([]string{repos.Local, "--show", "base", repos.Local + "/test.md"}))
+	assert.Equal(t, "TestContent", out.String())
+
+	// The warnings of the filters aren't part of the version.
+	test_helpers.PerformCmd(t, repos.Local, "git", "config", "filter.noisy.smudge", "echo warning >&2; cat")
+	test_helpers.WriteFile(t, repos.Local, ".git/info/attributes", "*.md filter=noisy\n")
+	out.Reset()
+	assert.NoError(t, runConflicts([]string{repos.Local, "--show", "base", repos.Local + "/test.md"}))
+	assert.Equal(t, "TestContent", out.String())
+	assert.NoError(t, os.Remove(repos.Local+"/.git/info/attributes"))
+
+	assert.NoError(t, runResolve([]string{repos.Local + "/test.md", "--theirs"}))
+	content, err := ioutil.ReadFile(repos.Local + "/test.md")
+	assert.NoError(t, err)
+	assert.Equal(t, "Cause conflict\n", string(content))
+	assertState(t, repos.Local, Ahead)
+
+	conflicts, err = UnresolvedConflicts(store, repos.Local)
+	assert.NoError(t, err)
+	assert.Empty(t, conflicts)
+
+	performSync(t, repos.Local)
+	assertState(t, repos.Local, Sync)
+}
+
+func TestConflicts_ResolvedByHand(t *testing.T) {
+	repos := test_helpers.SetupRepos()
+	defer test_helpers.CleanupRepos(repos)
+	setupConflict(t, repos)
+
+	test_helpers.WriteFile(t, repos.Local, "test.md", "Fixed by hand")
+	store, err := DefaultConflictStore()
+	assert.NoError(t, err)
+	conflicts, err := UnresolvedConflicts(store, repos.Local)
+	assert.NoError(t, err)
+	assert.Empty(t, conflicts)
+
+	assert.EqualError(t, runResolve([]string{repos.Local + "/test.md", "--ours"}), "test.md has no conflict markers")
+}
+
+func TestResolve_Edit(t *testing.T) {
+	repos := test_helpers.SetupRepos()
+	defer test_helpers.CleanupRepos(repos)
+	setupConflict(t, repos)
+
+	oldVisual := os.Getenv("VISUAL")
+	defer os.Setenv("VISUAL", oldVisual)
+
+	os.Setenv("VISUAL", "true")
+	assert.EqualError(t, runResolve([]string{repos.Local + "/test.md", "--edit"}), "test.md still has conflict markers")
+
+	os.Setenv("VISUAL", "printf 'Edited\\n' >")
+	assert.NoError(t, runResolve([]string{repos.Local + "/test.md", "--edit"}))
+	content, err := ioutil.ReadFile(repos.Local + "/test.md")
+	assert.NoError(t, err)
+	assert.Equal(t, "Edited\n", string(content))
+	assertState(t, repos.Local, Ahead)
+}
+
+func TestResolve_Usage(t *testing.T) {
+	assert.EqualError(t, runResolve([]string{"test.md", "--ours", "--theirs"}), "usage: git-notes resolve <file> --ours|--theirs|--both|--edit")
+	assert.EqualError(t, runResolve([]string{"test.md"}), "usage: git-notes resolve <file> --ours|--theirs|--both|--edit")
+}
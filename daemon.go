@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+const daemonTimeout = time.Second
+
+// SyncRequester is implemented by the monitors that sync a repo on request, e.g. when `s` is pressed
+// in the dashboard.
+type SyncRequester interface {
+	RequestSync(repo string) bool
+}
+
+// syncHandler serves `POST /sync?repo=<repo>` next to the metrics. The sync runs in the daemon, so
+// the request returns before it's done.
+func syncHandler(requester SyncRequester) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "use POST", http.StatusMethodNotAllowed)
+			return
+		}
+		repo := r.URL.Query().Get("repo")
+		if repo == "" {
+			http.Error(w, "the repo is missing", http.StatusBadRequest)
+			return
+		}
+		if !requester.RequestSync(repo) {
+			http.Error(w, fmt.Sprintf("%s isn't monitored", repo), http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+	})
+}
+
+// DaemonClient talks to a running daemon through its metrics address.
+type DaemonClient struct {
+	url    string
+	client *http.Client
+}
+
+// NewDaemonClient returns nil if the address isn't valid. A daemon listening on every interface is
+// reached through localhost.
+func NewDaemonClient(metricsAddress string) *DaemonClient {
+	host, port, err := net.SplitHostPort(metricsAddress)
+	if err != nil {
+		return nil
+	}
+	if host == "" || host == "0.0.0.0" || host == "::" {
+		host = "localhost"
+	}
+	return &DaemonClient{
+		url:    "http://" + net.JoinHostPort(host, port),
+		client: &http.Client{Timeout: daemonTimeout},
+	}
+}
+
+func (c *DaemonClient) Running() bool {
+	resp, err := c.client.Get(c.url + "/metrics")
+	if err != nil {
+		return false
+	}
+	resp.Body.Close()
+	return resp.StatusCode == http.StatusOK
+}
+
+func (c *DaemonClient) RequestSync(repo string) error {
+	resp, err := c.client.Post(c.url+"/sync?repo="+url.QueryEscape(repo), "", nil)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusAccepted:
+		return nil
+	case http.StatusNotFound:
+		return fmt.Errorf("the daemon doesn't monitor %s", repo)
+	}
+	return fmt.Errorf("the daemon answered %s", resp.Status)
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+type MockSyncRequester struct {
+	mutex     sync.Mutex
+	monitored []string
+	requested []string
+}
+
+func (m *MockSyncRequester) RequestSync(repo string) bool {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	for _, monitored := range m.monitored {
+		if monitored == repo {
+			m.requested = append(m.requested, repo)
+			return true
+		}
+	}
+	return false
+}
+
+func setupDaemon(t *testing.T, monitored ...string) (*httptest.Server, *MockSyncRequester, *DaemonClient) {
+	requester := &MockSyncRequester{monitored: monitored}
+	server := httptest.NewServer(metricsMux(requester))
+	client := NewDaemonClient(strings.TrimPrefix(server.URL, "http://"))
+	assert.NotNil(t, client)
+	return server, requester, client
+}
+
+func TestNewDaemonClient(t *testing.T) {
+	assert.Equal(t, "http://localhost:9100", NewDaemonClient(":9100").url)
+	assert.Equal(t, "http://localhost:9100", NewDaemonClient("0.0.0.0:9100").url)
+	assert.Equal(t, "http://[::1]:9100", NewDaemonClient("[::1]:9100").url)
+	assert.Equal(t, "http://127.0.0.1:9100", NewDaemonClient("127.0.0.1:9100").url)
+	assert.Nil(t, NewDaemonClient(""))
+}
+
+func TestDaemonClient_RequestSync(t *testing.T) {
+	server, requester, client := setupDaemon(t, "/notes")
+
+	assert.True(t, client.Running())
+	assert.NoError(t, client.RequestSync("/notes"))
+	assert.Equal(t, []string{"/notes"}, requester.requested)
+	assert.EqualError(t, client.RequestSync("/other-notes"), "the daemon doesn't monitor /other-notes")
+
+	resp, err := http.Get(server.URL + "/sync?repo=/notes")
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusMethodNotAllowed, resp.StatusCode)
+	assert.Equal(t, []string{"/notes"}, requester.requested)
+
+	server.Close()
+	assert.False(t, client.Running())
+	assert.Error(t, client.RequestSync("/notes"))
+}
+
+func TestMetricsMux_WithoutRequester(t *testing.T) {
+	recorder := httptest.NewRecorder()
+	metricsMux(nil).ServeHTTP(recorder, httptest.NewRequest("POST", "/sync?repo=/notes", nil))
+	assert.Equal(t, http.StatusNotFound, recorder.Code)
+}
@@ -0,0 +1,389 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	defaultMaxDeletedFiles   = 20
+	defaultMaxDeletedPercent = 50
+	// The percentage rule only applies from this many deletions. Otherwise, deleting one of two notes
+	// would need a confirmation.
+	minDeletionsForPercent = 5
+)
+
+type DeletionGuardConfig struct {
+	Disabled   bool    `json:"disabled" yaml:"disabled" toml:"disabled"`
+	MaxFiles   int     `json:"max_files" yaml:"max_files" toml:"max_files"`
+	MaxPercent float64 `json:"max_percent" yaml:"max_percent" toml:"max_percent"`
+}
+
+// PendingDeletion is a staged change that deletes too many files. The commit waits until the
+// deletion is confirmed. A confirmation only applies to exactly these files.
+type PendingDeletion struct {
+	Files      []string  `json:"files"`
+	Tracked    int       `json:"tracked"`
+	DetectedAt time.Time `json:"detected_at"`
+	Confirmed  bool      `json:"confirmed"`
+}
+
+// NeedsConfirmationError holds back the commit until the deletion is confirmed.
+type NeedsConfirmationError struct {
+	Pending PendingDeletion
+}
+
+func (e *NeedsConfirmationError) Error() string {
+	return fmt.Sprintf("the staged change deletes %d of %d files. Run `git-notes confirm <repo>` to commit it", len(e.Pending.Files), e.Pending.Tracked)
+}
+
+// ConfirmationStore keeps the pending deletions in a JSON file in the state directory. The daemon
+// writes them, and the confirm command marks them as confirmed.
+type ConfirmationStore struct {
+	file *JSONStore
+}
+
+func NewConfirmationStore(dir string) *ConfirmationStore {
+	return &ConfirmationStore{file: NewJSONStore(filepath.Join(dir, "confirmations.json"))}
+}
+
+func DefaultConfirmationStore() (*ConfirmationStore, error) {
+	dir, err := StateDir()
+	if err != nil {
+		return nil, err
+	}
+	return NewConfirmationStore(dir), nil
+}
+
+func (s *ConfirmationStore) Load() (map[string]PendingDeletion, error) {
+	pending := map[string]PendingDeletion{}
+	err := s.file.Load(&pending)
+	if err != nil {
+		return nil, err
+	}
+	return pending, nil
+}
+
+// update writes the file only if fn reports a change.
+func (s *ConfirmationStore) update(fn func(pending map[string]PendingDeletion) (bool, error)) error {
+	pending := map[string]PendingDeletion{}
+	return s.file.Update(&pending, func() (bool, error) {
+		return fn(pending)
+	})
+}
+
+// Confirm lets the daemon commit the pending deletion of the repo on its next sync.
+func (s *ConfirmationStore) Confirm(repo string) (PendingDeletion, error) {
+	var confirmed PendingDeletion
+	err := s.update(func(pending map[string]PendingDeletion) (bool, error) {
+		deletion, ok := pending[repo]
+		if !ok {
+			return false, fmt.Errorf("%s has no deletion waiting for a confirmation", repo)
+		}
+		deletion.Confirmed = true
+		pending[repo] = deletion
+		confirmed = deletion
+		return true, nil
+	})
+	return confirmed, err
+}
+
+func (s *ConfirmationStore) Clear(repo string) error {
+	return s.update(func(pending map[string]PendingDeletion) (bool, error) {
+		if _, ok := pending[repo]; !ok {
+			return false, nil
+		}
+		delete(pending, repo)
+		return true, nil
+	})
+}
+
+// Check records the deletion as pending unless the same files have been confirmed. It returns whether
+// the commit may go ahead.
+func (s *ConfirmationStore) Check(repo string, deletion PendingDeletion) (bool, error) {
+	allowed := false
+	err := s.update(func(pending map[string]PendingDeletion) (bool, error) {
+		existing, ok := pending[repo]
+		if ok && sameFiles(existing.Files, deletion.Files) {
+			if existing.Confirmed {
+				allowed = true
+				delete(pending, repo)
+				return true, nil
+			}
+			return false, nil
+		}
+		pending[repo] = deletion
+		return true, nil
+	})
+	return allowed, err
+}
+
+func sameFiles(a []string, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+type DeletionGuard struct {
+	disabled   bool
+	maxFiles   int
+	maxPercent float64
+	store      *ConfirmationStore
+	now        func() time.Time
+}
+
+func NewDeletionGuard(config DeletionGuardConfig) *DeletionGuard {
+	guard := &DeletionGuard{
+		disabled:   config.Disabled,
+		maxFiles:   config.MaxFiles,
+		maxPercent: config.MaxPercent,
+		now:        time.Now,
+	}
+	if guard.maxFiles == 0 {
+		guard.maxFiles = defaultMaxDeletedFiles
+	}
+	if guard.maxPercent == 0 {
+		guard.maxPercent = defaultMaxDeletedPercent
+	}
+	return guard
+}
+
+var defaultDeletionGuard = NewDeletionGuard(DeletionGuardConfig{})
+
+func (g *DeletionGuard) confirmations() (*ConfirmationStore, error) {
+	if g.store != nil {
+		return g.store, nil
+	}
+	return DefaultConfirmationStore()
+}
+
+func (g *DeletionGuard) exceeds(deleted int, tracked int) bool {
+	if deleted > g.maxFiles {
+		return true
+	}
+	return deleted >= minDeletionsForPercent && tracked > 0 && float64(deleted)*100/float64(tracked) > g.maxPercent
+}
+
+// Check is run on the staged change before committing.
+func (g *DeletionGuard) Check(path string) error {
+	if g.disabled {
+		return nil
+	}
+
+	outs, err := DiffStaged(path, "--name-only", "-M", "--diff-filter=D", "-z")
+	if err != nil {
+		return fmt.Errorf("unable to list the staged deletions. Err: %v", err)
+	}
+	var deleted []string
+	for _, file := range strings.Split(outs[0], "\x00") {
+		if file == "" {
+			continue
+		}
+		// During a merge, only the files that both sides still have were deleted here.
+		deletedHere := true
+		for _, out := range outs[1:] {
+			deletedHere = deletedHere && strings.Contains("\x00"+out, "\x00"+file+"\x00")
+		}
+		if deletedHere {
+			deleted = append(deleted, file)
+		}
+	}
+
+	store, err := g.confirmations()
+	if err != nil {
+		return err
+	}
+	if len(deleted) == 0 {
+		return store.Clear(path)
+	}
+
+	out, err := runCmd(path, "git", "ls-tree", "-r", "-z", "--name-only", "HEAD")
+	if err != nil {
+		return fmt.Errorf("unable to count the tracked files. Out: %s, Err: %v", out, err)
+	}
+	tracked := strings.Count(out, "\x00")
+
+	if !g.exceeds(len(deleted), tracked) {
+		return store.Clear(path)
+	}
+
+	deletion := PendingDeletion{Files: deleted, Tracked: tracked, DetectedAt: g.now()}
+	allowed, err := store.Check(path, deletion)
+	if err != nil {
+		return err
+	}
+	if allowed {
+		log.Printf("Committing the confirmed deletion of %d files in %s", len(deleted), path)
+		return nil
+	}
+	return &NeedsConfirmationError{Pending: deletion}
+}
+
+// Waiting reports whether the deletion held back in the repo is neither confirmed nor rejected yet.
+func (g *DeletionGuard) Waiting(path string) bool {
+	store, err := g.confirmations()
+	if err != nil {
+		return false
+	}
+	pending, err := store.Load()
+	if err != nil {
+		return false
+	}
+	deletion, ok := pending[path]
+	return ok && !deletion.Confirmed
+}
+
+func init() {
+	commands["confirm"] = Command{
+		Usage: "confirm <repo>",
+		Run:   runConfirm,
+	}
+	commands["reject"] = Command{
+		Usage: "reject <repo>",
+		Run:   runReject,
+	}
+	commands["undo"] = Command{
+		Usage: "undo <repo>",
+		Run:   runUndo,
+	}
+}
+
+// ConfirmDeletion lets the daemon commit the pending deletion of the repo on its next sync.
+func ConfirmDeletion(repo string) (PendingDeletion, error) {
+	store, err := DefaultConfirmationStore()
+	if err != nil {
+		return PendingDeletion{}, err
+	}
+	return store.Confirm(repo)
+}
+
+// RejectDeletion brings the files of the pending deletion back from the last commit.
+func RejectDeletion(repo string) (PendingDeletion, error) {
+	store, err := DefaultConfirmationStore()
+	if err != nil {
+		return PendingDeletion{}, err
+	}
+	pending, err := store.Load()
+	if err != nil {
+		return PendingDeletion{}, err
+	}
+	deletion, ok := pending[repo]
+	if !ok {
+		return PendingDeletion{}, fmt.Errorf("%s has no deletion waiting for a confirmation", repo)
+	}
+
+	out, err := runCmd(repo, "git", append([]string{"checkout", "HEAD", "--"}, deletion.Files...)...)
+	if err != nil {
+		return PendingDeletion{}, fmt.Errorf("unable to restore the deleted files. Out: %s, Err: %v", out, err)
+	}
+	return deletion, store.Clear(repo)
+}
+
+// UndoLastCommit reverts the last commit made by git-notes with a new commit, which the daemon then
+// pushes like any other change. The history is never rewritten because the commit may have been
+// pushed already.
+func UndoLastCommit(repo string) (string, error) {
+	out, err := runCmd(repo, "git", "log", "-1", "--format=%H%x00%an%x00%s%x00%P")
+	if err != nil {
+		return "", fmt.Errorf("unable to find the last commit. Out: %s, Err: %v", out, err)
+	}
+	fields := strings.Split(strings.TrimSpace(out), "\x00")
+	if len(fields) != 4 {
+		return "", fmt.Errorf("unable to parse the last commit: %s", out)
+	}
+	commit, author, subject, parents := fields[0], fields[1], fields[2], strings.Fields(fields[3])
+	if author != "Git notes" || !strings.HasPrefix(subject, "Commited at") {
+		return "", fmt.Errorf("the last commit (%s) wasn't made by git-notes", subject)
+	}
+	if len(parents) == 0 {
+		return "", fmt.Errorf("the last commit is the first commit of the repo")
+	}
+
+	dirty, err := (&GitCmd{}).IsDirty(repo)
+	if err != nil {
+		return "", err
+	}
+	if dirty {
+		return "", fmt.Errorf("%s has uncommitted changes. Wait for the next sync and try again", repo)
+	}
+
+	args := []string{"-c", "user.name='Git notes'", "-c", "user.email='git-notes@noemail.com'", "revert", "--no-edit"}
+	if len(parents) > 1 {
+		args = append(args, "-m", "1")
+	}
+	out, err = runCmd(repo, "git", append(args, commit)...)
+	if err != nil {
+		return "", fmt.Errorf("unable to revert %s. Out: %s, Err: %v", commit, out, err)
+	}
+	return commit, nil
+}
+
+func runConfirm(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: git-notes %s", commands["confirm"].Usage)
+	}
+	repo, err := resolveRepoArg(args[0])
+	if err != nil {
+		return err
+	}
+
+	deletion, err := ConfirmDeletion(repo)
+	if err != nil {
+		return err
+	}
+	log.Printf("Confirmed the deletion of %d files in %s. It's committed on the next sync", len(deletion.Files), repo)
+	return nil
+}
+
+func runReject(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: git-notes %s", commands["reject"].Usage)
+	}
+	repo, err := resolveRepoArg(args[0])
+	if err != nil {
+		return err
+	}
+	unlock, err := lockRepo(repo)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	deletion, err := RejectDeletion(repo)
+	if err != nil {
+		return err
+	}
+	log.Printf("Restored %d deleted files in %s", len(deletion.Files), repo)
+	return nil
+}
+
+func runUndo(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: git-notes %s", commands["undo"].Usage)
+	}
+	repo, err := resolveRepoArg(args[0])
+	if err != nil {
+		return err
+	}
+	unlock, err := lockRepo(repo)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	commit, err := UndoLastCommit(repo)
+	if err != nil {
+		return err
+	}
+	log.Printf("Reverted %s in %s. The revert is pushed on the next sync", shortCommits([]string{commit})[0], repo)
+	return nil
+}
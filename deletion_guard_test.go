@@ -0,0 +1,145 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/tanin47/git-notes/internal/test_helpers"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestDeletionGuard_Exceeds(t *testing.T) {
+	guard := NewDeletionGuard(DeletionGuardConfig{})
+	assert.False(t, guard.exceeds(1, 1))
+	assert.False(t, guard.exceeds(4, 4))
+	assert.True(t, guard.exceeds(5, 9))
+	assert.False(t, guard.exceeds(5, 10))
+	assert.False(t, guard.exceeds(20, 1000))
+	assert.True(t, guard.exceeds(21, 1000))
+
+	guard = NewDeletionGuard(DeletionGuardConfig{MaxFiles: 3, MaxPercent: 90})
+	assert.True(t, guard.exceeds(4, 1000))
+	assert.False(t, guard.exceeds(3, 3))
+}
+
+func writeNotes(t *testing.T, repo string, count int) {
+	for i := 0; i < count; i++ {
+		test_helpers.WriteFile(t, repo, fmt.Sprintf("note-%d.md", i), fmt.Sprintf("Note %d", i))
+	}
+}
+
+func TestGoGit_MassDeletionNeedsConfirmation(t *testing.T) {
+	repos := test_helpers.SetupRepos()
+	defer test_helpers.CleanupRepos(repos)
+
+	writeNotes(t, repos.Local, 6)
+	performSync(t, repos.Local)
+
+	for i := 0; i < 5; i++ {
+		assert.NoError(t, os.Remove(fmt.Sprintf("%s/note-%d.md", repos.Local, i)))
+	}
+	gogit := GitCmd{}
+	err := gogit.Sync(repos.Local)
+	var confirmationErr *NeedsConfirmationError
+	assert.True(t, errors.As(err, &confirmationErr))
+	assert.Equal(t, []string{"note-0.md", "note-1.md", "note-2.md", "note-3.md", "note-4.md"}, confirmationErr.Pending.Files)
+	assert.Equal(t, 6, confirmationErr.Pending.Tracked)
+	assert.Equal(t, NeedsConfirmation, metrics.states[repos.Local])
+
+	// Syncing again leaves the held back deletion alone until it's confirmed.
+	assert.NoError(t, gogit.Sync(repos.Local))
+	assert.Equal(t, NeedsConfirmation, metrics.states[repos.Local])
+	out, err := runCmd(repos.Remote, "git", "ls-tree", "--name-only", "master")
+	assert.NoError(t, err)
+	assert.Equal(t, 6, len(splitLines(out)))
+
+	deletion, err := ConfirmDeletion(repos.Local)
+	assert.NoError(t, err)
+	assert.True(t, deletion.Confirmed)
+	assert.NoError(t, gogit.Sync(repos.Local))
+	out, err = runCmd(repos.Remote, "git", "ls-tree", "--name-only", "master")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"note-5.md"}, splitLines(out))
+
+	_, err = ConfirmDeletion(repos.Local)
+	assert.EqualError(t, err, fmt.Sprintf("%s has no deletion waiting for a confirmation", repos.Local))
+
+	// The mass deletion is undone with a revert, which is pushed on the next sync.
+	commit, err := UndoLastCommit(repos.Local)
+	assert.NoError(t, err)
+	assert.NotEmpty(t, commit)
+	assert.NoError(t, gogit.Sync(repos.Local))
+	out, err = runCmd(repos.Remote, "git", "ls-tree", "--name-only", "master")
+	assert.NoError(t, err)
+	assert.Equal(t, 6, len(splitLines(out)))
+}
+
+func TestGoGit_MergesRemoteMassDeletion(t *testing.T) {
+	repos := test_helpers.SetupRepos()
+	defer test_helpers.CleanupRepos(repos)
+
+	writeNotes(t, repos.Local, 6)
+	performSync(t, repos.Local)
+
+	// The mass deletion was confirmed on another machine and pushed from there.
+	anotherLocal := test_helpers.SetupGitRepo("another_local", false)
+	defer os.RemoveAll(anotherLocal)
+	test_helpers.SetupRemote(anotherLocal, repos.Remote)
+	test_helpers.PerformCmd(t, anotherLocal, "git", "fetch")
+	test_helpers.PerformCmd(t, anotherLocal, "git", "checkout", "master")
+	for i := 0; i < 5; i++ {
+		test_helpers.PerformCmd(t, anotherLocal, "git", "rm", fmt.Sprintf("note-%d.md", i))
+	}
+	test_helpers.PerformCmd(t, anotherLocal, "git", "commit", "-m", "Confirmed deletion")
+	test_helpers.PerformCmd(t, anotherLocal, "git", "push")
+
+	test_helpers.WriteFile(t, repos.Local, "new.md", "New")
+	performSync(t, repos.Local)
+	assert.Equal(t, Sync, metrics.states[repos.Local])
+
+	out, err := runCmd(repos.Remote, "git", "ls-tree", "--name-only", "master")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"new.md", "note-5.md"}, splitLines(out))
+}
+
+func TestRejectDeletion(t *testing.T) {
+	repos := test_helpers.SetupRepos()
+	defer test_helpers.CleanupRepos(repos)
+
+	writeNotes(t, repos.Local, 5)
+	performSync(t, repos.Local)
+	for i := 0; i < 5; i++ {
+		assert.NoError(t, os.Remove(fmt.Sprintf("%s/note-%d.md", repos.Local, i)))
+	}
+	gogit := GitCmd{}
+	assert.Error(t, gogit.Sync(repos.Local))
+
+	deletion, err := RejectDeletion(repos.Local)
+	assert.NoError(t, err)
+	assert.Equal(t, 5, len(deletion.Files))
+	content, err := ioutil.ReadFile(repos.Local + "/note-3.md")
+	assert.NoError(t, err)
+	assert.Equal(t, "Note 3", string(content))
+	state, err := gogit.GetState(repos.Local)
+	assert.NoError(t, err)
+	assert.Equal(t, Sync, state)
+}
+
+func TestUndoLastCommit_NotByGitNotes(t *testing.T) {
+	repos := test_helpers.SetupRepos()
+	defer test_helpers.CleanupRepos(repos)
+
+	test_helpers.WriteFile(t, repos.Local, "test.md", "TestContent")
+	test_helpers.PerformCmd(t, repos.Local, "git", "add", "--all")
+	test_helpers.PerformCmd(t, repos.Local, "git", "commit", "-m", "Manual commit")
+
+	_, err := UndoLastCommit(repos.Local)
+	assert.EqualError(t, err, "the last commit (Manual commit) wasn't made by git-notes")
+}
+
+func splitLines(out string) []string {
+	return strings.Fields(out)
+}
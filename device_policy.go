@@ -0,0 +1,206 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const defaultLowBatteryPercent = 20
+
+// DeviceState is what the machine is running on. BatteryPercent is -1 when there is no battery.
+type DeviceState struct {
+	OnBattery      bool
+	BatteryPercent int
+	Metered        bool
+}
+
+type DeviceStateProvider interface {
+	DeviceState() (DeviceState, error)
+}
+
+// SystemDeviceState reads the power supplies from sysfs and asks NetworkManager through D-Bus whether
+// the connection is metered, e.g. tethered to a phone.
+type SystemDeviceState struct {
+	powerSupplyDir string
+}
+
+func readSysfs(dir string, name string) string {
+	data, err := ioutil.ReadFile(filepath.Join(dir, name))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
+
+func (s *SystemDeviceState) DeviceState() (DeviceState, error) {
+	state := DeviceState{BatteryPercent: -1}
+
+	powerSupplyDir := s.powerSupplyDir
+	if powerSupplyDir == "" {
+		powerSupplyDir = "/sys/class/power_supply"
+	}
+	supplies, _ := ioutil.ReadDir(powerSupplyDir)
+
+	onMains := false
+	discharging := false
+	for _, supply := range supplies {
+		dir := filepath.Join(powerSupplyDir, supply.Name())
+		switch readSysfs(dir, "type") {
+		case "Mains", "USB":
+			if readSysfs(dir, "online") == "1" {
+				onMains = true
+			}
+		case "Battery":
+			// The batteries of peripherals, e.g. a wireless mouse, have the scope Device.
+			if readSysfs(dir, "scope") == "Device" {
+				continue
+			}
+			if readSysfs(dir, "status") == "Discharging" {
+				discharging = true
+			}
+			if capacity, err := strconv.Atoi(readSysfs(dir, "capacity")); err == nil {
+				if state.BatteryPercent < 0 || capacity < state.BatteryPercent {
+					state.BatteryPercent = capacity
+				}
+			}
+		}
+	}
+	state.OnBattery = discharging && !onMains
+
+	out, err := runCmd("", "gdbus", "call", "--system",
+		"--dest", "org.freedesktop.NetworkManager",
+		"--object-path", "/org/freedesktop/NetworkManager",
+		"--method", "org.freedesktop.DBus.Properties.Get",
+		"org.freedesktop.NetworkManager", "Metered")
+	if err != nil {
+		return state, fmt.Errorf("unable to ask NetworkManager whether the connection is metered. Out: %s, Err: %v", out, err)
+	}
+	state.Metered, err = parseMetered(out)
+	return state, err
+}
+
+var meteredValue = regexp.MustCompile(`uint32 ([0-9]+)`)
+
+// parseMetered reads NetworkManager's NMMetered, e.g. `(<uint32 3>,)`. 1 (yes) and 3 (guessed yes)
+// are metered. 0 (unknown), 2 (no), and 4 (guessed no) are not.
+func parseMetered(out string) (bool, error) {
+	matches := meteredValue.FindStringSubmatch(out)
+	if matches == nil {
+		return false, fmt.Errorf("unable to parse the metered state: %s", strings.TrimSpace(out))
+	}
+	return matches[1] == "1" || matches[1] == "3", nil
+}
+
+// DeviceRule is applied while its condition holds. SlowDown multiplies the check and the scheduled
+// update intervals. DeferPush keeps committing locally but holds back pushing.
+type DeviceRule struct {
+	SlowDown  float64 `json:"slow_down" yaml:"slow_down" toml:"slow_down"`
+	DeferPush bool    `json:"defer_push" yaml:"defer_push" toml:"defer_push"`
+}
+
+type DevicePolicyConfig struct {
+	OnBattery         DeviceRule `json:"on_battery" yaml:"on_battery" toml:"on_battery"`
+	LowBattery        DeviceRule `json:"low_battery" yaml:"low_battery" toml:"low_battery"`
+	LowBatteryPercent int        `json:"low_battery_percent" yaml:"low_battery_percent" toml:"low_battery_percent"`
+	Metered           DeviceRule `json:"metered" yaml:"metered" toml:"metered"`
+}
+
+func (c DevicePolicyConfig) Enabled() bool {
+	return c.OnBattery != DeviceRule{} || c.LowBattery != DeviceRule{} || c.Metered != DeviceRule{}
+}
+
+// DeviceAdjustment is the combination of the rules that apply right now.
+type DeviceAdjustment struct {
+	SlowDown  float64
+	DeferPush bool
+	Reason    string
+}
+
+// DevicePolicy adjusts syncing to the device state. The state is cached for cacheFor because the
+// watchers ask on every check.
+type DevicePolicy struct {
+	provider DeviceStateProvider
+	config   DevicePolicyConfig
+	cacheFor time.Duration
+	now      func() time.Time
+
+	mutex     sync.Mutex
+	state     DeviceState
+	checkedAt time.Time
+}
+
+func NewDevicePolicy(provider DeviceStateProvider, config DevicePolicyConfig) *DevicePolicy {
+	if config.LowBatteryPercent == 0 {
+		config.LowBatteryPercent = defaultLowBatteryPercent
+	}
+	return &DevicePolicy{
+		provider: provider,
+		config:   config,
+		cacheFor: time.Minute,
+		now:      time.Now,
+	}
+}
+
+var devicePolicy = NewDevicePolicy(nil, DevicePolicyConfig{})
+
+func (p *DevicePolicy) deviceState() DeviceState {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if !p.checkedAt.IsZero() && p.now().Sub(p.checkedAt) < p.cacheFor {
+		return p.state
+	}
+
+	state, err := p.provider.DeviceState()
+	if err != nil {
+		log.Printf("Unable to get the full device state. Err: %v", err)
+	}
+	p.state = state
+	p.checkedAt = p.now()
+	return state
+}
+
+func (p *DevicePolicy) Adjustment() DeviceAdjustment {
+	adjustment := DeviceAdjustment{SlowDown: 1}
+	if p.provider == nil || !p.config.Enabled() {
+		return adjustment
+	}
+
+	state := p.deviceState()
+	var reasons []string
+	apply := func(rule DeviceRule, reason string) {
+		if rule.SlowDown > adjustment.SlowDown {
+			adjustment.SlowDown = rule.SlowDown
+		}
+		if rule.DeferPush {
+			adjustment.DeferPush = true
+		}
+		if rule != (DeviceRule{}) {
+			reasons = append(reasons, reason)
+		}
+	}
+
+	if state.OnBattery {
+		apply(p.config.OnBattery, "on battery")
+		if state.BatteryPercent >= 0 && state.BatteryPercent < p.config.LowBatteryPercent {
+			apply(p.config.LowBattery, fmt.Sprintf("battery at %d%%", state.BatteryPercent))
+		}
+	}
+	if state.Metered {
+		apply(p.config.Metered, "metered connection")
+	}
+	adjustment.Reason = strings.Join(reasons, ", ")
+	return adjustment
+}
+
+// Stretch slows down an interval according to the current device state.
+func (p *DevicePolicy) Stretch(interval time.Duration) time.Duration {
+	return time.Duration(float64(interval) * p.Adjustment().SlowDown)
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/tanin47/git-notes/internal/test_helpers"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type FakeDeviceState struct {
+	state DeviceState
+	err   error
+	calls int
+}
+
+func (f *FakeDeviceState) DeviceState() (DeviceState, error) {
+	f.calls++
+	return f.state, f.err
+}
+
+func writeSysfs(t *testing.T, dir string, supply string, files map[string]string) {
+	assert.NoError(t, os.MkdirAll(filepath.Join(dir, supply), 0755))
+	for name, content := range files {
+		assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, supply, name), []byte(content+"\n"), 0644))
+	}
+}
+
+func TestSystemDeviceState_PowerSupply(t *testing.T) {
+	dir, err := ioutil.TempDir("", "power_supply")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	writeSysfs(t, dir, "AC", map[string]string{"type": "Mains", "online": "0"})
+	writeSysfs(t, dir, "BAT0", map[string]string{"type": "Battery", "status": "Discharging", "capacity": "42"})
+	writeSysfs(t, dir, "hid-mouse-battery", map[string]string{"type": "Battery", "scope": "Device", "status": "Discharging", "capacity": "5"})
+
+	provider := &SystemDeviceState{powerSupplyDir: dir}
+	// NetworkManager may not be reachable here, so only the power supplies are checked.
+	state, _ := provider.DeviceState()
+	assert.True(t, state.OnBattery)
+	assert.Equal(t, 42, state.BatteryPercent)
+
+	writeSysfs(t, dir, "AC", map[string]string{"online": "1"})
+	state, _ = provider.DeviceState()
+	assert.False(t, state.OnBattery)
+}
+
+func TestParseMetered(t *testing.T) {
+	for out, expected := range map[string]bool{
+		"(<uint32 0>,)\n": false,
+		"(<uint32 1>,)\n": true,
+		"(<uint32 2>,)\n": false,
+		"(<uint32 3>,)\n": true,
+		"(<uint32 4>,)\n": false,
+	} {
+		metered, err := parseMetered(out)
+		assert.NoError(t, err)
+		assert.Equal(t, expected, metered, out)
+	}
+
+	_, err := parseMetered("Error: no such service")
+	assert.EqualError(t, err, "unable to parse the metered state: Error: no such service")
+}
+
+func TestDevicePolicy_Adjustment(t *testing.T) {
+	provider := &FakeDeviceState{state: DeviceState{OnBattery: true, BatteryPercent: 50}}
+	policy := NewDevicePolicy(provider, DevicePolicyConfig{
+		OnBattery:  DeviceRule{SlowDown: 3},
+		LowBattery: DeviceRule{SlowDown: 10, DeferPush: true},
+		Metered:    DeviceRule{SlowDown: 2, DeferPush: true},
+	})
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	policy.now = func() time.Time { return now }
+
+	assert.Equal(t, DeviceAdjustment{SlowDown: 3, Reason: "on battery"}, policy.Adjustment())
+	assert.Equal(t, 30*time.Second, policy.Stretch(10*time.Second))
+	assert.Equal(t, 1, provider.calls)
+
+	// The state is cached.
+	provider.state = DeviceState{OnBattery: true, BatteryPercent: 10, Metered: true}
+	assert.Equal(t, DeviceAdjustment{SlowDown: 3, Reason: "on battery"}, policy.Adjustment())
+
+	now = now.Add(time.Minute)
+	assert.Equal(t, DeviceAdjustment{SlowDown: 10, DeferPush: true, Reason: "on battery, battery at 10%, metered connection"}, policy.Adjustment())
+
+	now = now.Add(time.Minute)
+	provider.state = DeviceState{BatteryPercent: -1, Metered: true}
+	provider.err = errors.New("gdbus is missing")
+	assert.Equal(t, DeviceAdjustment{SlowDown: 2, DeferPush: true, Reason: "metered connection"}, policy.Adjustment())
+}
+
+func TestDevicePolicy_Disabled(t *testing.T) {
+	provider := &FakeDeviceState{state: DeviceState{OnBattery: true, Metered: true}}
+	policy := NewDevicePolicy(provider, DevicePolicyConfig{})
+
+	assert.Equal(t, DeviceAdjustment{SlowDown: 1}, policy.Adjustment())
+	assert.Equal(t, 10*time.Second, policy.Stretch(10*time.Second))
+	assert.Equal(t, 0, provider.calls)
+}
+
+func TestGoGit_DeferPushOnMeteredConnection(t *testing.T) {
+	repos := test_helpers.SetupRepos()
+	defer test_helpers.CleanupRepos(repos)
+
+	provider := &FakeDeviceState{state: DeviceState{BatteryPercent: -1, Metered: true}}
+	oldDevicePolicy := devicePolicy
+	devicePolicy = NewDevicePolicy(provider, DevicePolicyConfig{Metered: DeviceRule{DeferPush: true}})
+	devicePolicy.cacheFor = 0
+	defer func() { devicePolicy = oldDevicePolicy }()
+
+	gogit := GitCmd{}
+	test_helpers.WriteFile(t, repos.Local, "note.md", "Hello")
+	assert.NoError(t, gogit.Sync(repos.Local))
+	state, err := gogit.GetState(repos.Local)
+	assert.NoError(t, err)
+	assert.Equal(t, Ahead, state)
+
+	provider.state.Metered = false
+	assert.NoError(t, gogit.Sync(repos.Local))
+	state, err = gogit.GetState(repos.Local)
+	assert.NoError(t, err)
+	assert.Equal(t, Sync, state)
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// RepoDiscoverer finds notes repos under the configured roots and starts or stops monitoring them
+// as they appear or disappear.
+type RepoDiscoverer struct {
+	roots    []string
+	pattern  string
+	marker   string
+	interval time.Duration
+
+	monitor PathMonitor
+	watcher Watcher
+	git     Git
+
+	mutex      sync.Mutex
+	static     map[string]bool
+	discovered map[string]bool
+	running    bool
+}
+
+func NewRepoDiscoverer(config DiscoverConfig, staticRepos []string, monitor PathMonitor, watcher Watcher, git Git) (*RepoDiscoverer, error) {
+	interval := 5 * time.Minute
+	if config.Interval != "" {
+		var err error
+		interval, err = time.ParseDuration(config.Interval)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	pattern := config.Pattern
+	if pattern == "" {
+		pattern = "*"
+	}
+
+	var roots []string
+	for _, root := range config.Roots {
+		expanded, err := expandPath(root)
+		if err != nil {
+			return nil, err
+		}
+		roots = append(roots, expanded)
+	}
+
+	static := map[string]bool{}
+	for _, repo := range staticRepos {
+		static[repo] = true
+	}
+
+	return &RepoDiscoverer{
+		roots:      roots,
+		pattern:    pattern,
+		marker:     config.Marker,
+		interval:   interval,
+		monitor:    monitor,
+		watcher:    watcher,
+		git:        git,
+		static:     static,
+		discovered: map[string]bool{},
+	}, nil
+}
+
+// Scan returns the repos under the roots that match the pattern, contain the marker file if one is
+// configured, and are the root of a git work tree with the remote its branch syncs with.
+func (d *RepoDiscoverer) Scan() []string {
+	found := map[string]bool{}
+	for _, root := range d.roots {
+		matches, err := filepath.Glob(filepath.Join(root, d.pattern))
+		if err != nil {
+			log.Printf("Unable to scan %s. Err: %v", root, err)
+			continue
+		}
+
+		for _, match := range matches {
+			path, err := filepath.EvalSymlinks(match)
+			if err != nil {
+				continue
+			}
+			if info, err := os.Stat(path); err != nil || !info.IsDir() {
+				continue
+			}
+			if d.marker != "" {
+				if _, err := os.Stat(filepath.Join(path, d.marker)); err != nil {
+					continue
+				}
+			}
+			if err := checkGitWorkTree(path); err != nil {
+				log.Printf("Skipped %s because it %v", path, err)
+				continue
+			}
+			found[path] = true
+		}
+	}
+
+	var repos []string
+	for path := range found {
+		repos = append(repos, path)
+	}
+	sort.Strings(repos)
+	return repos
+}
+
+// Refresh scans the roots once and starts or stops monitoring accordingly.
+func (d *RepoDiscoverer) Refresh() {
+	found := d.Scan()
+
+	d.mutex.Lock()
+	var added []string
+	current := map[string]bool{}
+	for _, path := range found {
+		if d.static[path] {
+			continue
+		}
+		current[path] = true
+		if !d.discovered[path] {
+			added = append(added, path)
+		}
+	}
+
+	var removed []string
+	for path := range d.discovered {
+		if !current[path] {
+			removed = append(removed, path)
+		}
+	}
+	sort.Strings(removed)
+	d.discovered = current
+	d.mutex.Unlock()
+
+	for _, path := range removed {
+		log.Printf("%s has disappeared", path)
+		d.monitor.StopMonitoring(path)
+	}
+	for _, path := range added {
+		log.Printf("Discovered %s", path)
+		d.monitor.StartMonitoring(path, d.watcher, d.git)
+	}
+}
+
+func (d *RepoDiscoverer) Start() {
+	d.running = true
+	d.Refresh()
+	d.scheduleRefresh()
+}
+
+func (d *RepoDiscoverer) Stop() {
+	d.running = false
+}
+
+func (d *RepoDiscoverer) scheduleRefresh() {
+	time.AfterFunc(d.interval, func() {
+		if !d.running {
+			return
+		}
+		d.Refresh()
+		d.scheduleRefresh()
+	})
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/tanin47/git-notes/internal/test_helpers"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func setupNotesRoot(t *testing.T, remote string, names ...string) string {
+	root, err := ioutil.TempDir("", "git-notes-root")
+	assert.NoError(t, err)
+	root, err = filepath.EvalSymlinks(root)
+	assert.NoError(t, err)
+
+	for _, name := range names {
+		path := filepath.Join(root, name)
+		assert.NoError(t, os.Mkdir(path, 0755))
+		test_helpers.PerformCmd(t, path, "git", "init")
+		test_helpers.SetupRemote(path, remote)
+	}
+	return root
+}
+
+func TestRepoDiscoverer_Scan(t *testing.T) {
+	remote := test_helpers.SetupGitRepo("Remote", true)
+	defer os.RemoveAll(remote)
+	root := setupNotesRoot(t, remote, "work", "personal", "archive")
+	defer os.RemoveAll(root)
+
+	assert.NoError(t, os.Mkdir(filepath.Join(root, "not-a-repo"), 0755))
+	test_helpers.WriteFile(t, root, "file.md", "Not a directory")
+	test_helpers.WriteFile(t, root, "work/.git-notes", "")
+	test_helpers.WriteFile(t, root, "personal/.git-notes", "")
+
+	discoverer, err := NewRepoDiscoverer(DiscoverConfig{Roots: []string{root}}, nil, nil, nil, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{filepath.Join(root, "archive"), filepath.Join(root, "personal"), filepath.Join(root, "work")}, discoverer.Scan())
+
+	discoverer, err = NewRepoDiscoverer(DiscoverConfig{Roots: []string{root}, Marker: ".git-notes"}, nil, nil, nil, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{filepath.Join(root, "personal"), filepath.Join(root, "work")}, discoverer.Scan())
+
+	discoverer, err = NewRepoDiscoverer(DiscoverConfig{Roots: []string{root}, Pattern: "w*"}, nil, nil, nil, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{filepath.Join(root, "work")}, discoverer.Scan())
+}
+
+func TestRepoDiscoverer_Refresh(t *testing.T) {
+	remote := test_helpers.SetupGitRepo("Remote", true)
+	defer os.RemoveAll(remote)
+	root := setupNotesRoot(t, remote, "work", "personal")
+	defer os.RemoveAll(root)
+
+	var monitor = MockMonitor{}
+	discoverer, err := NewRepoDiscoverer(DiscoverConfig{Roots: []string{root}}, []string{filepath.Join(root, "personal")}, &monitor, &MockWatcher{}, &MockGit{})
+	assert.NoError(t, err)
+
+	discoverer.Refresh()
+	assert.Equal(t, []string{filepath.Join(root, "work")}, monitor.startMonitorPaths)
+
+	discoverer.Refresh()
+	assert.Equal(t, []string{filepath.Join(root, "work")}, monitor.startMonitorPaths)
+
+	journal := filepath.Join(root, "journal")
+	assert.NoError(t, os.Mkdir(journal, 0755))
+	test_helpers.PerformCmd(t, journal, "git", "init")
+	test_helpers.SetupRemote(journal, remote)
+	assert.NoError(t, os.RemoveAll(filepath.Join(root, "work")))
+
+	discoverer.Refresh()
+	assert.Equal(t, []string{filepath.Join(root, "work"), filepath.Join(root, "journal")}, monitor.startMonitorPaths)
+	assert.Equal(t, []string{filepath.Join(root, "work")}, monitor.stopMonitorPaths)
+}
@@ -0,0 +1,353 @@
+package main
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// The encrypted files are stored as the magic header, a nonce, and the AES-GCM ciphertext. The nonce
+// is derived from the content so that encrypting the same content always gives the same blob.
+// Otherwise, git would see every encrypted file as modified. The trade-off is that identical
+// contents are visible as identical blobs.
+const encryptionMagic = "GITNOTES-ENC1\x00"
+
+const encryptionFilter = "git-notes-encrypt"
+
+type EncryptionOptions struct {
+	Patterns []string `json:"patterns" yaml:"patterns" toml:"patterns"`
+	KeyFile  string   `json:"key_file" yaml:"key_file" toml:"key_file"`
+}
+
+func (o EncryptionOptions) Enabled() bool {
+	return len(o.Patterns) > 0
+}
+
+func init() {
+	commands["keygen"] = Command{
+		Usage: "keygen <key-file>",
+		Run:   runKeygen,
+	}
+	commands["encrypt-clean"] = Command{Hidden: true, Run: runEncryptClean}
+	commands["encrypt-smudge"] = Command{Hidden: true, Run: runEncryptSmudge}
+	commands["encrypt-merge"] = Command{Hidden: true, Run: runEncryptMerge}
+}
+
+func GenerateKey(keyFile string) error {
+	if _, err := os.Stat(keyFile); err == nil {
+		return fmt.Errorf("%s already exists", keyFile)
+	}
+
+	key := make([]byte, 32)
+	_, err := rand.Read(key)
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(filepath.Dir(keyFile), 0700)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(keyFile, []byte(hex.EncodeToString(key)+"\n"), 0600)
+}
+
+func ReadKey(keyFile string) ([]byte, error) {
+	data, err := ioutil.ReadFile(keyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := hex.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil || len(key) != 32 {
+		return nil, fmt.Errorf("%s doesn't contain a key generated by `git-notes keygen`", keyFile)
+	}
+	return key, nil
+}
+
+func deriveKey(key []byte, purpose string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(purpose))
+	return mac.Sum(nil)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(deriveKey(key, "encryption"))
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func IsEncrypted(data []byte) bool {
+	return bytes.HasPrefix(data, []byte(encryptionMagic))
+}
+
+// Encrypt is deterministic: the same key and plaintext always give the same ciphertext. Content that
+// is already encrypted with the key is returned as it is.
+func Encrypt(key []byte, plaintext []byte) ([]byte, error) {
+	if IsEncrypted(plaintext) {
+		if _, err := Decrypt(key, plaintext); err == nil {
+			return plaintext, nil
+		}
+	}
+
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	mac := hmac.New(sha256.New, deriveKey(key, "nonce"))
+	mac.Write(plaintext)
+	nonce := mac.Sum(nil)[:gcm.NonceSize()]
+
+	out := append([]byte(encryptionMagic), nonce...)
+	return gcm.Seal(out, nonce, plaintext, nil), nil
+}
+
+// Decrypt returns the data as it is if it isn't encrypted, e.g. it was committed before the
+// encryption was enabled.
+func Decrypt(key []byte, data []byte) ([]byte, error) {
+	if !IsEncrypted(data) {
+		return data, nil
+	}
+
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	data = data[len(encryptionMagic):]
+	if len(data) < gcm.NonceSize() {
+		return nil, errors.New("the encrypted content is truncated")
+	}
+
+	plaintext, err := gcm.Open(nil, data[:gcm.NonceSize()], data[gcm.NonceSize():], nil)
+	if err != nil {
+		return nil, errors.New("unable to decrypt. The key might be wrong")
+	}
+	return plaintext, nil
+}
+
+func runKeygen(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: git-notes %s", commands["keygen"].Usage)
+	}
+	keyFile, err := expandPath(args[0])
+	if err != nil {
+		return err
+	}
+	return GenerateKey(keyFile)
+}
+
+// runFilter transforms stdin to stdout with the key in args[0]. Git runs it as the clean and smudge filters.
+func runFilter(args []string, transform func(key []byte, data []byte) ([]byte, error)) error {
+	if len(args) != 1 {
+		return errors.New("the key file is required")
+	}
+	key, err := ReadKey(args[0])
+	if err != nil {
+		return err
+	}
+
+	data, err := ioutil.ReadAll(os.Stdin)
+	if err != nil {
+		return err
+	}
+	out, err := transform(key, data)
+	if err != nil {
+		return err
+	}
+
+	_, err = os.Stdout.Write(out)
+	return err
+}
+
+func runEncryptClean(args []string) error {
+	return runFilter(args, Encrypt)
+}
+
+func runEncryptSmudge(args []string) error {
+	return runFilter(args, Decrypt)
+}
+
+// runEncryptMerge is the merge driver of the encrypted files. Git passes the encrypted base, ours,
+// and theirs. We merge the decrypted contents, so the conflict markers surround readable text, and
+// write the encrypted result back to ours.
+func runEncryptMerge(args []string) error {
+	if len(args) != 6 {
+		return errors.New("usage: encrypt-merge <key-file> <base> <ours> <theirs> <marker-size> <path>")
+	}
+	key, err := ReadKey(args[0])
+	if err != nil {
+		return err
+	}
+	base, ours, theirs, markerSize, path := args[1], args[2], args[3], args[4], args[5]
+
+	dir, err := ioutil.TempDir("", "git-notes-merge")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	var plainFiles []string
+	for i, file := range []string{ours, base, theirs} {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		plaintext, err := Decrypt(key, data)
+		if err != nil {
+			return fmt.Errorf("unable to decrypt %s. Err: %v", path, err)
+		}
+
+		plainFile := filepath.Join(dir, fmt.Sprint(i))
+		err = ioutil.WriteFile(plainFile, plaintext, 0600)
+		if err != nil {
+			return err
+		}
+		plainFiles = append(plainFiles, plainFile)
+	}
+
+	_, mergeErr := runCmd(dir, "git", "merge-file", "--marker-size="+markerSize,
+		"-L", path, "-L", path, "-L", path, plainFiles[0], plainFiles[1], plainFiles[2])
+
+	merged, err := ioutil.ReadFile(plainFiles[0])
+	if err != nil {
+		return err
+	}
+	encrypted, err := Encrypt(key, merged)
+	if err != nil {
+		return err
+	}
+	err = ioutil.WriteFile(ours, encrypted, 0644)
+	if err != nil {
+		return err
+	}
+
+	if mergeErr != nil {
+		return fmt.Errorf("%s has conflicts", path)
+	}
+	return nil
+}
+
+// decryptWorktree checks out again the files that are still encrypted in the worktree, e.g. the repo
+// was cloned before the filter was configured.
+func decryptWorktree(path string, patterns []string) error {
+	out, err := runCmd(path, "git", append([]string{"ls-files", "-z", "--"}, patterns...)...)
+	if err != nil {
+		return fmt.Errorf("unable to list the encrypted files. Out: %s, Err: %v", out, err)
+	}
+
+	var encrypted []string
+	for _, file := range strings.Split(out, "\x00") {
+		if file == "" {
+			continue
+		}
+		header := make([]byte, len(encryptionMagic))
+		f, err := os.Open(filepath.Join(path, file))
+		if err != nil {
+			continue
+		}
+		n, _ := f.Read(header)
+		f.Close()
+		if IsEncrypted(header[:n]) {
+			encrypted = append(encrypted, file)
+		}
+	}
+	if len(encrypted) == 0 {
+		return nil
+	}
+
+	// Git skips the files that look unchanged, so they are removed first.
+	for _, file := range encrypted {
+		err = os.Remove(filepath.Join(path, file))
+		if err != nil {
+			return err
+		}
+	}
+	out, err = runCmd(path, "git", append([]string{"checkout", "--"}, encrypted...)...)
+	if err != nil {
+		return fmt.Errorf("unable to decrypt the files. Out: %s, Err: %v", out, err)
+	}
+	return nil
+}
+
+func shellQuote(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", `'\''`) + "'"
+}
+
+// PrepareEncryption registers git-notes as the filter, diff, and merge driver of the encrypted files
+// and adds the patterns to .gitattributes. The files that are already committed are re-encrypted when
+// the patterns or the filter change.
+func PrepareEncryption(path string, options RepoOptions) error {
+	executable, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	keyFile, err := expandPath(options.Encryption.KeyFile)
+	if err != nil {
+		return err
+	}
+	_, err = ReadKey(keyFile)
+	if err != nil {
+		return err
+	}
+
+	command := shellQuote(executable)
+	key := shellQuote(keyFile)
+	settings := [][2]string{
+		{"filter." + encryptionFilter + ".clean", command + " encrypt-clean " + key},
+		{"filter." + encryptionFilter + ".smudge", command + " encrypt-smudge " + key},
+		{"filter." + encryptionFilter + ".required", "true"},
+		{"diff." + encryptionFilter + ".textconv", command + " encrypt-smudge " + key + " <"},
+		{"merge." + encryptionFilter + ".name", "git-notes encrypted merge"},
+		{"merge." + encryptionFilter + ".driver", command + " encrypt-merge " + key + " %O %A %B %L %P"},
+	}
+	changed := false
+	for _, setting := range settings {
+		if out, err := runCmd(path, "git", "config", "--local", "--get", setting[0]); err == nil && strings.TrimSuffix(out, "\n") == setting[1] {
+			continue
+		}
+		out, err := runCmd(path, "git", "config", "--local", setting[0], setting[1])
+		if err != nil {
+			return fmt.Errorf("unable to set %s. Out: %s, Err: %v", setting[0], out, err)
+		}
+		changed = true
+	}
+
+	err = decryptWorktree(path, options.Encryption.Patterns)
+	if err != nil {
+		return err
+	}
+
+	var lines []string
+	for _, pattern := range options.Encryption.Patterns {
+		lines = append(lines, fmt.Sprintf("%s filter=%s diff=%s merge=%s", pattern, encryptionFilter, encryptionFilter, encryptionFilter))
+	}
+	added, err := addAttributes(path, lines, false)
+	if err != nil || !(added || changed) {
+		return err
+	}
+
+	// The committed files that match the new patterns are still in plaintext. Renormalizing stages
+	// them encrypted.
+	args := append([]string{"add", "--renormalize", "--"}, SparsePathspecs(options.SparseCheckout)...)
+	if len(options.SparseCheckout) == 0 {
+		args = append(args, ".")
+	}
+	out, err := runCmd(path, "git", args...)
+	if err != nil {
+		return fmt.Errorf("unable to encrypt the committed files. Out: %s, Err: %v", out, err)
+	}
+	return nil
+}
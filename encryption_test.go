@@ -0,0 +1,150 @@
+package main
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/tanin47/git-notes/internal/test_helpers"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+func setupKey(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "git-notes-key")
+	assert.NoError(t, err)
+	keyFile := dir + "/notes.key"
+	assert.NoError(t, GenerateKey(keyFile))
+	return keyFile, func() { os.RemoveAll(dir) }
+}
+
+func TestEncryptDecrypt(t *testing.T) {
+	keyFile, cleanup := setupKey(t)
+	defer cleanup()
+
+	key, err := ReadKey(keyFile)
+	assert.NoError(t, err)
+
+	encrypted, err := Encrypt(key, []byte("My secret"))
+	assert.NoError(t, err)
+	assert.True(t, IsEncrypted(encrypted))
+	assert.NotContains(t, string(encrypted), "My secret")
+
+	again, err := Encrypt(key, []byte("My secret"))
+	assert.NoError(t, err)
+	assert.Equal(t, encrypted, again)
+
+	twice, err := Encrypt(key, encrypted)
+	assert.NoError(t, err)
+	assert.Equal(t, encrypted, twice)
+
+	decrypted, err := Decrypt(key, encrypted)
+	assert.NoError(t, err)
+	assert.Equal(t, "My secret", string(decrypted))
+
+	// Plaintext that only looks encrypted is still encrypted.
+	lookalike := []byte(encryptionMagic + "My secret")
+	encrypted2, err := Encrypt(key, lookalike)
+	assert.NoError(t, err)
+	assert.NotEqual(t, lookalike, encrypted2)
+	decrypted, err = Decrypt(key, encrypted2)
+	assert.NoError(t, err)
+	assert.Equal(t, lookalike, decrypted)
+
+	plain, err := Decrypt(key, []byte("Not encrypted"))
+	assert.NoError(t, err)
+	assert.Equal(t, "Not encrypted", string(plain))
+
+	otherKeyFile, otherCleanup := setupKey(t)
+	defer otherCleanup()
+	otherKey, err := ReadKey(otherKeyFile)
+	assert.NoError(t, err)
+	_, err = Decrypt(otherKey, encrypted)
+	assert.EqualError(t, err, "unable to decrypt. The key might be wrong")
+}
+
+func TestGenerateKey_Exists(t *testing.T) {
+	keyFile, cleanup := setupKey(t)
+	defer cleanup()
+
+	assert.EqualError(t, GenerateKey(keyFile), fmt.Sprintf("%s already exists", keyFile))
+
+	info, err := os.Stat(keyFile)
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+}
+
+func assertRemoteFile(t *testing.T, remote string, file string, encrypted bool, content string) {
+	out, err := runCmd(remote, "git", "show", "master:"+file)
+	assert.NoError(t, err)
+	assert.Equal(t, encrypted, IsEncrypted([]byte(out)), file)
+	if !encrypted {
+		assert.Equal(t, content, out)
+	}
+	assert.Equal(t, encrypted, !strings.Contains(out, content), file)
+}
+
+func TestGoGit_Encryption(t *testing.T) {
+	repos := test_helpers.SetupRepos()
+	defer test_helpers.CleanupRepos(repos)
+	keyFile, cleanup := setupKey(t)
+	defer cleanup()
+
+	test_helpers.WriteFile(t, repos.Local, "diary.secret.md", "Committed before encryption")
+	test_helpers.WriteFile(t, repos.Local, "public.md", "Public")
+	performSync(t, repos.Local)
+	assertRemoteFile(t, repos.Remote, "diary.secret.md", false, "Committed before encryption")
+
+	options := RepoOptions{Encryption: EncryptionOptions{Patterns: []string{"*.secret.md"}, KeyFile: keyFile}}
+	gogit := GitCmd{options: map[string]RepoOptions{repos.Local: options}}
+	assert.NoError(t, gogit.Sync(repos.Local))
+	assertRemoteFile(t, repos.Remote, "diary.secret.md", true, "Committed before encryption")
+
+	test_helpers.WriteFile(t, repos.Local, "passwords.secret.md", "hunter2")
+	assert.NoError(t, gogit.Sync(repos.Local))
+	assertRemoteFile(t, repos.Remote, "passwords.secret.md", true, "hunter2")
+	assertRemoteFile(t, repos.Remote, "public.md", false, "Public")
+
+	content, err := ioutil.ReadFile(repos.Local + "/passwords.secret.md")
+	assert.NoError(t, err)
+	assert.Equal(t, "hunter2", string(content))
+
+	state, err := gogit.GetState(repos.Local)
+	assert.NoError(t, err)
+	assert.Equal(t, Sync, state)
+}
+
+func TestGoGit_EncryptionConflict(t *testing.T) {
+	repos := test_helpers.SetupRepos()
+	defer test_helpers.CleanupRepos(repos)
+	keyFile, cleanup := setupKey(t)
+	defer cleanup()
+
+	options := RepoOptions{Encryption: EncryptionOptions{Patterns: []string{"*.md"}, KeyFile: keyFile}}
+	test_helpers.WriteFile(t, repos.Local, "test.md", "line 1\nline 2\nline 3\n")
+	gogit := GitCmd{options: map[string]RepoOptions{repos.Local: options}}
+	assert.NoError(t, gogit.Sync(repos.Local))
+
+	// The clone doesn't have the filter yet. Syncing configures it and decrypts the worktree.
+	dir, err := ioutil.TempDir("", "git_test_another")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	another := dir + "/another"
+	test_helpers.PerformCmd(t, dir, "git", "clone", repos.Remote, another)
+	gogit.options[another] = options
+	assert.NoError(t, gogit.Sync(another))
+	content, err := ioutil.ReadFile(another + "/test.md")
+	assert.NoError(t, err)
+	assert.Equal(t, "line 1\nline 2\nline 3\n", string(content))
+
+	test_helpers.WriteFile(t, another, "test.md", "line 1\nline 2 from another\nline 3\n")
+	assert.NoError(t, gogit.Sync(another))
+
+	test_helpers.WriteFile(t, repos.Local, "test.md", "line 1\nline 2 from local\nline 3\n")
+	assert.NoError(t, gogit.Sync(repos.Local))
+
+	content, err = ioutil.ReadFile(repos.Local + "/test.md")
+	assert.NoError(t, err)
+	assert.Equal(t, "line 1\n<<<<<<< test.md\nline 2 from local\n=======\nline 2 from another\n>>>>>>> test.md\nline 3\n", string(content))
+	assertRemoteFile(t, repos.Remote, "test.md", true, "line 2 from local")
+}
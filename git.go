@@ -1,11 +1,15 @@
 package main
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
+	"io"
 	"log"
-	"os"
 	"os/exec"
 	"regexp"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -16,6 +20,10 @@ const (
 	Ahead     State = "ahead"
 	OutOfSync State = "out-of-sync"
 	Sync      State = "sync"
+	Blocked   State = "blocked"
+	Paused    State = "paused"
+	NeedsConfirmation State = "needs-confirmation"
+	Busy              State = "busy"
 )
 
 type State string
@@ -23,43 +31,463 @@ type State string
 type Git interface {
 	IsDirty(path string) (bool, error)
 	GetState(path string) (State, error)
+	Status(path string) (RepoStatus, error)
 	Sync(path string) error
 	Update(path string) error
 }
 
 type GitCmd struct {
+	options       map[string]RepoOptions
+	secretScanner *SecretScanner
+	deletionGuard *DeletionGuard
+	pauses        *PauseStore
+	store         *StateStore
+	conflicts     *ConflictStore
+	operations    *OperationStore
+	activity      *ActivityMonitor
+	fetches       *SharedFetcher
+	held          *HeldChanges
+	now           func() time.Time
+	// output is where the syncs log and where git prints, instead of the standard logger, e.g. nowhere
+	// while the dashboard is on the screen. The repo log gets the lines either way.
+	output io.Writer
+}
+
+func (g *GitCmd) Configure(config *Config) {
+	g.options = config.RepoOptions
+
+	scanner, err := NewSecretScanner(config.SecretScan)
+	if err != nil {
+		log.Printf("Invalid secret_scan config, using the defaults. Err: %v", err)
+		scanner = defaultSecretScanner
+	}
+	g.secretScanner = scanner
+	g.deletionGuard = NewDeletionGuard(config.DeletionGuard)
+	g.activity = NewActivityMonitor(config.ManualActivity)
+	// The new checks may let the held back changes through.
+	g.held = NewHeldChanges()
+}
+
+func (g *GitCmd) guard() *DeletionGuard {
+	if g.deletionGuard == nil {
+		return defaultDeletionGuard
+	}
+	return g.deletionGuard
+}
+
+func (g *GitCmd) scanner() *SecretScanner {
+	if g.secretScanner == nil {
+		return defaultSecretScanner
+	}
+	return g.secretScanner
+}
+
+// logger returns the logger of the syncs of the repo. Its writer is also where git prints.
+func (g *GitCmd) logger(path string) *log.Logger {
+	output := g.output
+	if output == nil {
+		output = log.Writer()
+	}
+	if repoLog, err := DefaultRepoLog(path); err == nil {
+		output = io.MultiWriter(output, repoLog)
+	}
+	return log.New(output, log.Prefix(), log.Flags())
+}
+
+// stdLogger is the standard logger, for the git commands that run outside a sync.
+func stdLogger() *log.Logger {
+	return log.New(log.Writer(), log.Prefix(), log.Flags())
+}
+
+func (g *GitCmd) optionsFor(path string) RepoOptions {
+	return g.options[path]
+}
+
+// policyFor combines the pause, the schedule of the repo, and the device policy. A pause store
+// that can't be read doesn't stop syncing.
+func (g *GitCmd) policyFor(path string) SyncPolicy {
+	now := time.Now()
+	if g.now != nil {
+		now = g.now()
+	}
+
+	pauses := g.pauses
+	if pauses == nil {
+		var err error
+		pauses, err = DefaultPauseStore()
+		if err != nil {
+			g.logger(path).Printf("Unable to check whether %s is paused. Err: %v", path, err)
+		}
+	}
+	if pauses != nil {
+		pause, paused, err := pauses.Paused(path, now)
+		if err != nil {
+			g.logger(path).Printf("Unable to check whether %s is paused. Err: %v", path, err)
+		} else if paused {
+			return SyncPolicy{Sync: false, Push: false, Reason: pause.String()}
+		}
+	}
+
+	policy := g.optionsFor(path).Schedule.Policy(now)
+	if policy.Push {
+		if adjustment := devicePolicy.Adjustment(); adjustment.DeferPush {
+			policy.Push = false
+			policy.Reason = fmt.Sprintf("pushes are deferred (%s)", adjustment.Reason)
+		}
+	}
+	return policy
+}
+
+func (g *GitCmd) stateStore() *StateStore {
+	if g.store != nil {
+		return g.store
+	}
+	store, err := DefaultStateStore()
+	if err != nil {
+		log.Printf("Unable to find the state store. Err: %v", err)
+	}
+	return store
+}
+
+func (g *GitCmd) conflictStore() *ConflictStore {
+	if g.conflicts != nil {
+		return g.conflicts
+	}
+	store, err := DefaultConflictStore()
+	if err != nil {
+		log.Printf("Unable to find the conflict store. Err: %v", err)
+	}
+	return store
+}
+
+func (g *GitCmd) operationStore() *OperationStore {
+	if g.operations != nil {
+		return g.operations
+	}
+	store, err := DefaultOperationStore()
+	if err != nil {
+		log.Printf("Unable to find the operation store. Err: %v", err)
+	}
+	return store
+}
+
+// busy reports whether a human has left an operation in progress, e.g. a rebase or a merge. The merge that
+// git-notes started itself isn't busy because the sync finishes it.
+func (g *GitCmd) busy(path string, status RepoStatus) bool {
+	if StartedByGitNotes(g.operationStore(), path, status) {
+		return false
+	}
+	return len(status.Operations) > 0
+}
+
+// forgetOperation forgets the operation that git-notes started once it's finished or aborted.
+func (g *GitCmd) forgetOperation(path string) {
+	if store := g.operationStore(); store != nil {
+		if err := store.Finish(path); err != nil {
+			g.logger(path).Printf("Unable to forget the operation of %s. Err: %v", path, err)
+		}
+	}
+}
+
+// recoverMerge deals with the merge that git-notes started in an earlier sync and didn't finish. By
+// default, the sync goes on and commits it. Otherwise, it's aborted and merged again.
+func (g *GitCmd) recoverMerge(path string, options RepoOptions) error {
+	status, err := GetStatus(path, options)
+	if err != nil {
+		return err
+	}
+	if len(status.Operations) == 0 || g.busy(path, status) {
+		return nil
+	}
+
+	if options.InterruptedMerge != InterruptedMergeAbort {
+		g.logger(path).Printf("Continuing the interrupted merge of %s", path)
+		return nil
+	}
+	g.logger(path).Printf("Aborting the interrupted merge of %s", path)
+	err = AbortMerge(path)
+	if err != nil {
+		return err
+	}
+	g.forgetOperation(path)
+	return nil
+}
+
+// startMerge remembers the merge before starting it, so that a merge left behind by a crash isn't
+// mistaken for a human's.
+func (g *GitCmd) startMerge(path string) error {
+	if store := g.operationStore(); store != nil {
+		tracking, err := CurrentTracking(path)
+		var head string
+		if err == nil {
+			head, err = runCmd(path, "git", "rev-parse", "--verify", "--quiet", tracking.Upstream())
+		}
+		if err == nil {
+			err = store.Start(path, StartedOperation{Operation: OperationMerge, Head: strings.TrimSpace(head), StartedAt: time.Now()})
+		}
+		if err != nil {
+			g.logger(path).Printf("Unable to remember the merge of %s. Err: %v", path, err)
+		}
+	}
+	return Merge(path, g.optionsFor(path), g.logger(path))
+}
+
+// record saves the attempt in the state store. A failure to save doesn't fail the sync.
+func (g *GitCmd) record(path string, attempt *SyncAttempt, err error) {
+	attempt.Duration = time.Since(attempt.Started)
+
+	var secretsErr *SecretsFoundError
+	var confirmationErr *NeedsConfirmationError
+	switch {
+	case errors.As(err, &secretsErr):
+		attempt.Outcome = OutcomeBlocked
+		attempt.States = append(attempt.States, Blocked)
+	case errors.As(err, &confirmationErr):
+		attempt.Outcome = OutcomeNeedsConfirmation
+		attempt.States = append(attempt.States, NeedsConfirmation)
+	case err != nil:
+		attempt.Outcome = OutcomeFailed
+	case attempt.Outcome != "":
+	case hasConflicts(attempt.Merges):
+		attempt.Outcome = OutcomeConflict
+	default:
+		attempt.Outcome = OutcomeSynced
+	}
+	if err != nil {
+		attempt.Error = err.Error()
+	}
+
+	store := g.stateStore()
+	if store == nil {
+		return
+	}
+	if err := store.Record(path, *attempt); err != nil {
+		g.logger(path).Printf("Unable to record the sync of %s. Err: %v", path, err)
+	}
 }
 
 func (g *GitCmd) Sync(path string) error {
+	attempt := &SyncAttempt{Started: time.Now()}
+	err := g.sync(path, attempt)
+	if errors.Is(err, ErrPaused) {
+		metrics.SetState(path, Paused)
+		return nil
+	}
+	if errors.Is(err, ErrBusy) {
+		metrics.SetState(path, Busy)
+		return nil
+	}
+	var heldErr *HeldError
+	if errors.As(err, &heldErr) {
+		metrics.SetState(path, heldErr.State())
+		return nil
+	}
+	g.record(path, attempt, err)
+	metrics.RecordSync(path, err)
+	if len(attempt.States) > 0 {
+		metrics.SetState(path, attempt.States[len(attempt.States)-1])
+	}
+	var secretsErr *SecretsFoundError
+	var confirmationErr *NeedsConfirmationError
+	if errors.As(err, &secretsErr) {
+		metrics.SetState(path, Blocked)
+		notifier.Blocked(path, secretsErr.Findings)
+	} else if errors.As(err, &confirmationErr) {
+		metrics.SetState(path, NeedsConfirmation)
+		notifier.NeedsConfirmation(path, confirmationErr.Pending)
+	} else if err != nil {
+		notifier.SyncFailed(path, err)
+	} else {
+		notifier.SyncSucceeded(path)
+		g.forgetResolvedConflicts(path)
+	}
+	return err
+}
+
+// forgetResolvedConflicts lets the user know once the conflicts of an earlier merge are resolved.
+func (g *GitCmd) forgetResolvedConflicts(path string) {
+	store := g.conflictStore()
+	if store == nil {
+		return
+	}
+	resolved, err := ForgetResolvedConflicts(store, path)
+	if err != nil {
+		g.logger(path).Printf("Unable to check the conflicts of %s. Err: %v", path, err)
+		return
+	}
+	if len(resolved) > 0 {
+		notifier.ConflictsResolved(path, resolved)
+	}
+}
+
+func (g *GitCmd) sync(path string, attempt *SyncAttempt) error {
+	logger := g.logger(path)
+	policy := g.policyFor(path)
+	if !policy.Sync {
+		logger.Printf("Skipped syncing %s: %s", path, policy.Reason)
+		return ErrPaused
+	}
+	if reason, active := g.checkActivity(path, time.Now()); active {
+		logger.Printf("Backing off from %s: %s", path, reason)
+		return ErrBusy
+	}
+	unlock, err := lockSync(path)
+	if errors.Is(err, ErrSyncing) {
+		logger.Printf("Leaving %s alone: %v", path, err)
+		return ErrBusy
+	}
+	if err != nil {
+		logger.Printf("Unable to lock %s. Err: %v", path, err)
+	} else {
+		defer unlock()
+	}
+
+	before := headCommit(path)
+	defer func() {
+		attempt.Commits = commitsSince(path, before)
+		if len(attempt.Commits) > 0 {
+			attempt.Files = filesChangedSince(path, before)
+		}
+	}()
+
+	options := g.optionsFor(path)
+	err = ApplySparseCheckout(path, options)
+	if err != nil {
+		return fmt.Errorf("performing ApplySparseCheckout() failed. Err: %w", err)
+	}
+	if options.Encryption.Enabled() {
+		err = PrepareEncryption(path, options)
+		if err != nil {
+			return fmt.Errorf("performing PrepareEncryption() failed. Err: %w", err)
+		}
+	}
+	if options.Merge.Enabled() {
+		err = PrepareMerge(path, options.Merge)
+		if err != nil {
+			return fmt.Errorf("performing PrepareMerge() failed. Err: %w", err)
+		}
+	}
+	err = g.recoverMerge(path, options)
+	if err != nil {
+		return fmt.Errorf("performing recoverMerge() failed. Err: %w", err)
+	}
+	err = SkipNestedRepos(path)
+	if err != nil {
+		return fmt.Errorf("performing SkipNestedRepos() failed. Err: %w", err)
+	}
+	if options.Submodules {
+		err = g.syncSubmodules(path)
+		if err != nil {
+			return fmt.Errorf("performing syncSubmodules() failed. Err: %w", err)
+		}
+	}
+
 	state, err := g.GetState(path)
-	log.Printf("Starting state: %s", state)
+	logger.Printf("Starting state: %s", state)
+	attempt.States = append(attempt.States, state)
 	if err != nil {
-		return fmt.Errorf("performing GetState() failed. Err: %v", err)
+		return fmt.Errorf("performing GetState() failed. Err: %w", err)
 	}
 
 	for {
 		if state == Sync {
 			return nil
 		}
+		if state == Busy {
+			logger.Printf("Leaving %s alone while a git operation is in progress", path)
+			return ErrBusy
+		}
+		if state == Ahead && !policy.Push {
+			logger.Printf("Holding back the push of %s: %s", path, policy.Reason)
+			attempt.Outcome = OutcomeHeld
+			return nil
+		}
 
 		err = g.Update(path)
 		if err != nil {
-			return fmt.Errorf("performing Update() failed. Err: %v", err)
+			return fmt.Errorf("performing Update() failed. Err: %w", err)
+		}
+		if state == OutOfSync {
+			conflicts, err := ConflictedFiles(path)
+			if err == nil {
+				attempt.Merges = append(attempt.Merges, MergeOutcome{Conflicts: conflicts})
+			}
+			if store := g.conflictStore(); len(conflicts) > 0 && store != nil {
+				if err := RecordConflicts(store, path, conflicts, time.Now()); err != nil {
+					logger.Printf("Unable to record the conflicts of %s. Err: %v", path, err)
+				}
+			}
 		}
 		nextState, err := g.GetState(path)
+		attempt.States = append(attempt.States, nextState)
 		if err != nil {
-			return fmt.Errorf("performing GetState() failed. Err: %v", err)
+			return fmt.Errorf("performing GetState() failed. Err: %w", err)
 		}
-		log.Printf("Next state: %s", nextState)
+		logger.Printf("Next state: %s", nextState)
 
 		if state == nextState {
-			return fmt.Errorf("state doesn't change. Something is wrong")
+			return ErrNoProgress
 		}
 
 		state = nextState
 	}
 }
 
+// headCommit returns an empty string when the branch has no commits yet.
+func headCommit(path string) string {
+	out, err := runCmd(path, "git", "rev-parse", "--verify", "--quiet", "HEAD")
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(out)
+}
+
+// commitsSince lists the commits on the local branch after before, newest first. The commits that a
+// merge brings in are not included.
+func commitsSince(path string, before string) []string {
+	args := []string{"rev-list", "--first-parent", "HEAD"}
+	if before != "" {
+		args = append(args, "^"+before)
+	}
+	out, err := runCmd(path, "git", args...)
+	if err != nil {
+		return nil
+	}
+	return strings.Fields(out)
+}
+
+// filesChangedSince lists the files changed by the commits on the local branch after before.
+func filesChangedSince(path string, before string) []string {
+	args := []string{"-c", "core.quotePath=false", "log", "--first-parent", "-m", "--name-only", "--format=", "HEAD"}
+	if before != "" {
+		args = append(args, "^"+before)
+	}
+	out, err := runCmd(path, "git", args...)
+	if err != nil {
+		return nil
+	}
+
+	var files []string
+	seen := map[string]bool{}
+	for _, file := range strings.Split(out, "\n") {
+		if file != "" && !seen[file] {
+			seen[file] = true
+			files = append(files, file)
+		}
+	}
+	sort.Strings(files)
+	return files
+}
+
+func hasConflicts(merges []MergeOutcome) bool {
+	for _, merge := range merges {
+		if len(merge.Conflicts) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
 func runCmd(path string, command string, args... string) (string, error) {
 	cmd := exec.Command(command, args...)
 	cmd.Dir = path
@@ -68,10 +496,37 @@ func runCmd(path string, command string, args... string) (string, error) {
 	return string(out), err
 }
 
+// runCmdStdout returns the stdout alone, e.g. the content of a file, so that the warnings of the command
+// don't end up in it. The stderr is in the error, and it's logged if the command succeeds.
+func runCmdStdout(path string, command string, args ...string) ([]byte, error) {
+	var stderr bytes.Buffer
+	cmd := exec.Command(command, args...)
+	cmd.Dir = path
+	cmd.Stderr = &stderr
+
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("%v. Stderr: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	if stderr.Len() > 0 {
+		log.Printf("%s %s in %s: %s", command, strings.Join(args, " "), path, strings.TrimSpace(stderr.String()))
+	}
+	return out, nil
+}
+
 func (g *GitCmd) IsDirty(path string) (bool, error) {
-	out, err := runCmd(path, "git", "status", "--porcelain")
+	args := []string{"status", "--porcelain"}
+	if g.optionsFor(path).Submodules {
+		args = append(args, "--ignore-submodules=dirty")
+	}
+	args = append(append(args, "--"), SparsePathspecs(g.optionsFor(path).SparseCheckout)...)
+	var out string
+	err := timeGitOp(path, "status", func() (err error) {
+		out, err = runCmd(path, "git", args...)
+		return err
+	})
 	if err != nil {
-		return false, fmt.Errorf("unable to get status. Error: %v", err)
+		return false, fmt.Errorf("unable to get status. Error: %w", err)
 	}
 
 	dirty := strings.TrimSpace(string(out)) != ""
@@ -79,16 +534,35 @@ func (g *GitCmd) IsDirty(path string) (bool, error) {
 }
 
 func (g *GitCmd) GetState(path string) (State, error) {
-	log.Printf("Computing the state of %s", path)
+	g.logger(path).Printf("Computing the state of %s", path)
+
+	status, err := GetStatus(path, g.optionsFor(path))
+	if err != nil {
+		return Error, err
+	}
+	if len(status.Operations) == 0 {
+		g.forgetOperation(path)
+	}
+	if g.busy(path, status) {
+		return Busy, nil
+	}
+	if status.Detached() {
+		g.logger(path).Printf("Leaving %s alone: %v", path, ErrDetached)
+		return Busy, nil
+	}
+	// Our own merge is committed even when it changes nothing, e.g. both sides made the same change.
+	if len(status.Operations) > 0 {
+		return Dirty, nil
+	}
 
 	dirty, err := g.IsDirty(path)
 	if err != nil {
-		return Error, fmt.Errorf("unable to get status. Error: %v", err)
+		return Error, fmt.Errorf("unable to get status. Error: %w", err)
 	}
 	if dirty {
 		return Dirty, nil
 	} else {
-		state, err := GetStateAgainstRemote(path)
+		state, err := GetStateAgainstRemote(path, g.optionsFor(path), g.fetcher())
 		if err != nil {
 			return Error, err
 		}
@@ -96,65 +570,61 @@ func (g *GitCmd) GetState(path string) (State, error) {
 	}
 }
 
-func ParseStatusBranch(status string) (State, error) {
-	// 5 variants of status branch
-	// ## master
-	// ## master...origin/master
-	// ## master...origin/master [ahead 1]
-	// ## master...origin/master [behind 1]
-	// ## master...origin/master [ahead 1, behind 1]
-
-	reg := regexp.MustCompile("## master(\\.\\.\\.origin\\/master *(\\[(ahead|behind) *[0-9]+ *(, *behind *[0-9]+)? *])?)?")
-	matches := reg.FindAllStringSubmatch(status, -1)
-
-	if len(matches) == 0 {
-		return Error, fmt.Errorf("unable to parse status: %v", status)
-	}
+var statusBranch = regexp.MustCompile(`^## (.+?)(?:\.\.\.(\S+))?(?: \[(.*)\])?$`)
 
-	groups := matches[0]
-	if groups[0] == "" {
+// ParseStatusBranch parses the branch line of `git status --porcelain --branch`, e.g.
+// `## master...origin/master [ahead 1, behind 2]`, into the state of a repo without changes. The
+// status is reduced like the one of ParseStatusV2.
+func ParseStatusBranch(status string) (State, error) {
+	matches := statusBranch.FindStringSubmatch(strings.TrimSpace(status))
+	if matches == nil {
 		return Error, fmt.Errorf("unable to parse status: %v", status)
 	}
 
-	// ## master
-	if groups[1] == "" {
-		return Ahead, nil
-	}
-
-	// ## master...origin/master
-	if groups[2] == "" {
-		return Sync, nil
+	// The line doesn't have the commits, so they only tell whether there are any.
+	repoStatus := RepoStatus{Branch: matches[1], HeadSHA: "HEAD", Upstream: matches[2]}
+	if repoStatus.Branch == "HEAD (no branch)" {
+		repoStatus.Branch = ""
+	} else if branch := strings.TrimPrefix(repoStatus.Branch, "No commits yet on "); branch != repoStatus.Branch {
+		repoStatus.Branch, repoStatus.HeadSHA = branch, ""
 	}
-
-	// ## master...origin/master
-	if groups[3] == "behind" {
-		return OutOfSync, nil
+	if repoStatus.Upstream != "" {
+		repoStatus.UpstreamSHA = repoStatus.Upstream
 	}
-
-	// ## master...origin/master
-	if groups[3] == "ahead" {
-		if groups[4] == "" {
-			return Ahead, nil
-		} else {
-			return OutOfSync, nil
+	for _, count := range strings.Split(matches[3], ",") {
+		fields := strings.Fields(count)
+		switch {
+		case len(fields) == 1 && fields[0] == "gone":
+			repoStatus.UpstreamSHA = ""
+		case len(fields) == 2 && (fields[0] == "ahead" || fields[0] == "behind"):
+			n, err := strconv.Atoi(fields[1])
+			if err != nil {
+				return Error, fmt.Errorf("unable to parse status: %v", status)
+			}
+			if fields[0] == "ahead" {
+				repoStatus.Ahead = n
+			} else {
+				repoStatus.Behind = n
+			}
+		case len(fields) != 0:
+			return Error, fmt.Errorf("unable to parse status: %v", status)
 		}
 	}
-
-	return Error, fmt.Errorf("unable to parse status: %v", status)
+	return repoStatus.State(), nil
 }
 
-func GetStateAgainstRemote(path string) (State, error) {
-	_, err := runCmd(path, "git", "fetch")
+// GetStateAgainstRemote fetches through the fetcher, so that the worktrees of a repo share a fetch.
+func GetStateAgainstRemote(path string, options RepoOptions, fetcher *SharedFetcher) (State, error) {
+	err := fetcher.Fetch(path, options)
 	if err != nil {
-		return Error, fmt.Errorf("unable to fetch. Error: %v", err)
+		return Error, fmt.Errorf("unable to fetch. Error: %w", err)
 	}
 
-	status, err := runCmd(path, "git", "status", "--branch", "--porcelain")
+	status, err := GetStatus(path, options)
 	if err != nil {
-		return Error, fmt.Errorf("unable to fetch. Error: %v", err)
+		return Error, err
 	}
-
-	return ParseStatusBranch(status)
+	return status.State(), nil
 }
 
 func (g *GitCmd) Update(path string) error {
@@ -167,56 +637,191 @@ func (g *GitCmd) Update(path string) error {
 	switch state {
 	case Error:
 	case Dirty:
-		err = AddAndCommit(path)
+		err = AddAndCommit(path, g.optionsFor(path), g.logger(path), g.checkHeld, g.guard().Check, g.scanner().Check)
+		g.holdBack(path, err)
 	case Ahead:
-		err = Push(path)
+		// A merge may have added commits to the submodules, which must be pushed first.
+		if g.optionsFor(path).Submodules {
+			err = g.pushSubmodules(path)
+			if err != nil {
+				return err
+			}
+		}
+		err = Push(path, g.optionsFor(path), g.logger(path))
 	case OutOfSync:
-		err = Merge(path)
+		err = g.startMerge(path)
 	case Sync:
+	case Busy:
 	}
 
 	return err
 }
 
-func AddAndCommit(path string) error {
-	err := Add(path)
+// StagedCheck runs on the staged change before committing. An error holds back the commit, and the
+// change stays staged until the next sync.
+type StagedCheck func(path string) error
+
+func AddAndCommit(path string, options RepoOptions, logger *log.Logger, checks ...StagedCheck) error {
+	err := Add(path, options, logger)
 	if err != nil {
 		return err
 	}
-	return Commit(path)
+
+	for _, check := range checks {
+		err = check(path)
+		if err != nil {
+			logger.Printf("Holding back the commit of %s. Err: %v", path, err)
+			return err
+		}
+	}
+	return Commit(path, logger)
 }
 
-func Merge(path string) error {
-	cmd := exec.Command("git", "merge", "origin/master", "--allow-unrelated-histories", "--no-commit")
-	cmd.Dir = path
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	_ = cmd.Run() // Merge fails if there's conflict. So, we ignore the failure.
+// DiffStaged runs `git diff --cached` with the args. During a merge, the diff runs against each parent,
+// so that a change is only in every output when it comes from neither side. The changes of each side
+// were checked when they were committed there, e.g. a confirmed mass deletion on another machine.
+func DiffStaged(path string, args ...string) ([]string, error) {
+	diff := append([]string{"-c", "core.quotePath=false", "diff", "--cached"}, args...)
+	_, err := runCmd(path, "git", "rev-parse", "--verify", "--quiet", "MERGE_HEAD")
+	if err != nil {
+		out, err := runCmd(path, "git", diff...)
+		if err != nil {
+			return nil, fmt.Errorf("unable to diff the staged changes. Out: %s, Err: %w", out, err)
+		}
+		return []string{out}, nil
+	}
+
+	var outs []string
+	for _, parent := range []string{"HEAD", "MERGE_HEAD"} {
+		out, err := runCmd(path, "git", append(diff, parent)...)
+		if err != nil {
+			return nil, fmt.Errorf("unable to diff the staged changes against %s. Out: %s, Err: %w", parent, out, err)
+		}
+		outs = append(outs, out)
+	}
+	return outs, nil
+}
+
+func Merge(path string, options RepoOptions, logger *log.Logger) error {
+	tracking, err := CurrentTracking(path)
+	if err != nil {
+		return err
+	}
+	if options.LFS.Enabled() {
+		err := FetchLFS(path, tracking)
+		if err != nil {
+			return err
+		}
+	}
+
+	_ = timeGitOp(path, "merge", func() error {
+		cmd := exec.Command("git", "merge", tracking.Upstream(), "--allow-unrelated-histories", "--no-commit")
+		cmd.Dir = path
+		cmd.Stdout = logger.Writer()
+		cmd.Stderr = logger.Writer()
+		return cmd.Run()
+	}) // Merge fails if there's conflict. So, we ignore the failure.
+	metrics.IncMerges(path)
+
+	conflicted, err := ConflictedFiles(path)
+	if err != nil {
+		return err
+	}
+	if len(conflicted) > 0 {
+		logger.Printf("Merge left conflicts in %v", conflicted)
+		metrics.IncConflicts(path)
+		notifier.Conflicted(path, conflicted)
+	}
+	if options.Submodules {
+		// A conflicted pointer is left as it is. Committing it records the submodule's current commit.
+		if err := UpdateSubmodules(path); err != nil {
+			logger.Printf("Unable to update the submodules of %s. Err: %v", path, err)
+		}
+	}
 	return nil
 }
 
-func Push(path string) error {
-	cmd := exec.Command("git", "push", "origin", "master", "-u")
-	cmd.Dir = path
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	return cmd.Run()
+// ConflictedFiles lists the unmerged paths left behind by a merge.
+func ConflictedFiles(path string) ([]string, error) {
+	var out string
+	err := timeGitOp(path, "diff", func() (err error) {
+		out, err = runCmd(path, "git", "diff", "--name-only", "--diff-filter=U")
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var files []string
+	for _, line := range strings.Split(out, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			files = append(files, line)
+		}
+	}
+	return files, nil
 }
 
-func Add(path string) error {
-	cmd := exec.Command("git", "add", "--all")
-	cmd.Dir = path
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	return cmd.Run()
+// Push pushes only the checked-out branch, so the worktrees of a repo don't push each other's branches.
+func Push(path string, options RepoOptions, logger *log.Logger) error {
+	tracking, err := CurrentTracking(path)
+	if err != nil {
+		return err
+	}
+	if options.LFS.Enabled() {
+		err := PushLFS(path, tracking)
+		if err != nil {
+			return err
+		}
+	}
+
+	args := []string{"push", tracking.Remote, tracking.Branch + ":" + tracking.RemoteBranch, "-u"}
+	if options.Submodules {
+		// Refuses to push pointers to submodule commits that haven't been pushed.
+		args = append(args, "--recurse-submodules=check")
+	}
+	err = timeGitOp(path, "push", func() error {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = path
+		cmd.Stdout = logger.Writer()
+		cmd.Stderr = logger.Writer()
+		return cmd.Run()
+	})
+	if err == nil {
+		metrics.IncPushes(path)
+	}
+	return err
 }
 
-func Commit(path string) error {
-	cmd := exec.Command("git", "-c", "user.name='Git notes'", "-c", "user.email='git-notes@noemail.com'", "commit", "-m", fmt.Sprintf("Commited at %v", time.Now()))
-	cmd.Dir = path
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	return cmd.Run()
+func Add(path string, options RepoOptions, logger *log.Logger) error {
+	if options.LFS.Enabled() {
+		err := PrepareLFS(path, options.LFS)
+		if err != nil {
+			return err
+		}
+	}
+
+	args := append([]string{"add", "--all", "--"}, SparsePathspecs(options.SparseCheckout)...)
+	return timeGitOp(path, "add", func() error {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = path
+		cmd.Stdout = logger.Writer()
+		cmd.Stderr = logger.Writer()
+		return cmd.Run()
+	})
+}
+
+func Commit(path string, logger *log.Logger) error {
+	err := timeGitOp(path, "commit", func() error {
+		cmd := exec.Command("git", "-c", "user.name='Git notes'", "-c", "user.email='git-notes@noemail.com'", "commit", "-m", fmt.Sprintf("Commited at %v", time.Now()))
+		cmd.Dir = path
+		cmd.Stdout = logger.Writer()
+		cmd.Stderr = logger.Writer()
+		return cmd.Run()
+	})
+	if err == nil {
+		metrics.IncCommits(path)
+	}
+	return err
 }
 
 func NewGoGit() GitCmd {
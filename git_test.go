@@ -60,6 +60,19 @@ func TestParseStatusBranch_OutOfSync2(t *testing.T) {
 	assert.Equal(t, OutOfSync, state)
 }
 
+func TestParseStatusBranch_OtherBranches(t *testing.T) {
+	state, err := ParseStatusBranch("## main...upstream/main [gone]")
+	assert.NoError(t, err)
+	assert.Equal(t, Ahead, state)
+
+	state, err = ParseStatusBranch("## HEAD (no branch)")
+	assert.NoError(t, err)
+	assert.Equal(t, Busy, state)
+
+	_, err = ParseStatusBranch("On branch master")
+	assert.EqualError(t, err, "unable to parse status: On branch master")
+}
+
 func TestGoGit_Rename(t *testing.T) {
 	repos := test_helpers.SetupRepos()
 	defer test_helpers.CleanupRepos(repos)
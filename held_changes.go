@@ -0,0 +1,126 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// HeldError is returned when the staged change is the one that the last sync held back. The change
+// isn't checked again, so the repo isn't scanned and the user isn't notified on every sync.
+type HeldError struct {
+	Err error
+}
+
+func (e *HeldError) Error() string {
+	return fmt.Sprintf("the staged change is still held back. Err: %v", e.Err)
+}
+
+func (e *HeldError) Unwrap() error {
+	return e.Err
+}
+
+// State is Blocked or NeedsConfirmation, depending on the check that held back the change.
+func (e *HeldError) State() State {
+	var confirmationErr *NeedsConfirmationError
+	if errors.As(e.Err, &confirmationErr) {
+		return NeedsConfirmation
+	}
+	return Blocked
+}
+
+type heldChange struct {
+	tree string
+	err  error
+}
+
+// HeldChanges remembers the staged changes that were held back by the secret scanner or the deletion
+// guard, by the tree of the index.
+type HeldChanges struct {
+	mutex   sync.Mutex
+	changes map[string]heldChange
+}
+
+var defaultHeldChanges = NewHeldChanges()
+
+func NewHeldChanges() *HeldChanges {
+	return &HeldChanges{changes: map[string]heldChange{}}
+}
+
+func (h *HeldChanges) Hold(path string, tree string, err error) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.changes[path] = heldChange{tree: tree, err: err}
+}
+
+func (h *HeldChanges) Release(path string) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	delete(h.changes, path)
+}
+
+// Held returns the error that held back the staged tree, if the tree hasn't changed since.
+func (h *HeldChanges) Held(path string, tree string) (error, bool) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	change, ok := h.changes[path]
+	if !ok || change.tree != tree {
+		return nil, false
+	}
+	return change.err, true
+}
+
+func stagedTree(path string) (string, error) {
+	out, err := runCmd(path, "git", "write-tree")
+	if err != nil {
+		return "", fmt.Errorf("unable to read the staged tree. Out: %s, Err: %w", out, err)
+	}
+	return strings.TrimSpace(out), nil
+}
+
+func (g *GitCmd) holds() *HeldChanges {
+	if g.held == nil {
+		return defaultHeldChanges
+	}
+	return g.held
+}
+
+// checkHeld is the first check on the staged change. A held back deletion is checked again once it's
+// confirmed.
+func (g *GitCmd) checkHeld(path string) error {
+	tree, err := stagedTree(path)
+	if err != nil {
+		return err
+	}
+	heldErr, ok := g.holds().Held(path, tree)
+	if !ok {
+		return nil
+	}
+
+	var confirmationErr *NeedsConfirmationError
+	if errors.As(heldErr, &confirmationErr) && !g.guard().Waiting(path) {
+		g.holds().Release(path)
+		return nil
+	}
+	return &HeldError{Err: heldErr}
+}
+
+// holdBack remembers the staged change when a check held it back, and forgets it otherwise.
+func (g *GitCmd) holdBack(path string, err error) {
+	var heldErr *HeldError
+	var secretsErr *SecretsFoundError
+	var confirmationErr *NeedsConfirmationError
+	switch {
+	case errors.As(err, &heldErr):
+	case errors.As(err, &secretsErr), errors.As(err, &confirmationErr):
+		tree, treeErr := stagedTree(path)
+		if treeErr != nil {
+			g.holds().Release(path)
+			return
+		}
+		g.holds().Hold(path, tree, err)
+	default:
+		g.holds().Release(path)
+	}
+}
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestHeldChanges(t *testing.T) {
+	held := NewHeldChanges()
+	secretsErr := &SecretsFoundError{}
+	held.Hold("/notes", "tree", secretsErr)
+
+	err, ok := held.Held("/notes", "tree")
+	assert.True(t, ok)
+	assert.Equal(t, secretsErr, err)
+	_, ok = held.Held("/notes", "other-tree")
+	assert.False(t, ok)
+
+	held.Release("/notes")
+	_, ok = held.Held("/notes", "tree")
+	assert.False(t, ok)
+}
+
+func TestHeldError_State(t *testing.T) {
+	assert.Equal(t, Blocked, (&HeldError{Err: &SecretsFoundError{}}).State())
+	assert.Equal(t, NeedsConfirmation, (&HeldError{Err: &NeedsConfirmationError{}}).State())
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// stdout is where the commands print their results. The tests replace it.
+var stdout io.Writer = os.Stdout
+
+func init() {
+	commands["history"] = Command{
+		Usage: "history <repo> [--since <time>] [--until <time>] [--outcome <outcome,...>] [--limit <n>] [--json]",
+		Run:   runHistory,
+	}
+}
+
+// parseTime accepts a duration before now (24h), a date (2006-01-02) in the local time zone, or a
+// timestamp (2006-01-02T15:04:05Z).
+func parseTime(value string, now time.Time) (time.Time, error) {
+	if duration, err := time.ParseDuration(value); err == nil {
+		return now.Add(-duration), nil
+	}
+	if t, err := time.ParseInLocation("2006-01-02", value, now.Location()); err == nil {
+		return t, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("%s is not a duration, a date like 2006-01-02, or an RFC3339 timestamp", value)
+}
+
+type HistoryFilter struct {
+	Since    time.Time
+	Until    time.Time
+	Outcomes []string
+	Limit    int
+}
+
+// Apply returns the matching attempts, newest first.
+func (f HistoryFilter) Apply(history []SyncAttempt) []SyncAttempt {
+	var attempts []SyncAttempt
+	for i := len(history) - 1; i >= 0; i-- {
+		attempt := history[i]
+		if !f.Since.IsZero() && attempt.Started.Before(f.Since) {
+			continue
+		}
+		if !f.Until.IsZero() && !attempt.Started.Before(f.Until) {
+			continue
+		}
+		if len(f.Outcomes) > 0 && !containsString(f.Outcomes, attempt.Outcome) {
+			continue
+		}
+		attempts = append(attempts, attempt)
+		if f.Limit > 0 && len(attempts) == f.Limit {
+			break
+		}
+	}
+	return attempts
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func shortCommits(commits []string) []string {
+	var short []string
+	for _, commit := range commits {
+		if len(commit) > 7 {
+			commit = commit[:7]
+		}
+		short = append(short, commit)
+	}
+	return short
+}
+
+func formatAttempt(w io.Writer, attempt SyncAttempt) {
+	var states []string
+	for _, state := range attempt.States {
+		states = append(states, string(state))
+	}
+	fmt.Fprintf(w, "%s  %-8s  %6s  %s\n",
+		attempt.Started.Local().Format("2006-01-02 15:04:05"),
+		attempt.Outcome,
+		attempt.Duration.Round(100*time.Millisecond),
+		strings.Join(states, " -> "))
+
+	if len(attempt.Commits) > 0 {
+		fmt.Fprintf(w, "    commits: %s\n", strings.Join(shortCommits(attempt.Commits), ", "))
+	}
+	if len(attempt.Files) > 0 {
+		fmt.Fprintf(w, "    files: %s\n", strings.Join(attempt.Files, ", "))
+	}
+	for _, merge := range attempt.Merges {
+		fmt.Fprintf(w, "    merge: %s\n", merge)
+	}
+	if attempt.Error != "" {
+		fmt.Fprintf(w, "    error: %s\n", attempt.Error)
+	}
+}
+
+func runHistory(args []string) error {
+	flags := flag.NewFlagSet("history", flag.ContinueOnError)
+	since := flags.String("since", "", "Only the attempts started at or after this time")
+	until := flags.String("until", "", "Only the attempts started before this time")
+	outcomes := flags.String("outcome", "", "Only the attempts with these outcomes: "+strings.Join(AllOutcomes, ", "))
+	limit := flags.Int("limit", 20, "The maximum number of attempts. 0 lists all of them")
+	asJson := flags.Bool("json", false, "Print the attempts as JSON")
+
+	positional, err := parseInterspersed(flags, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 1 {
+		return fmt.Errorf("usage: git-notes %s", commands["history"].Usage)
+	}
+
+	now := time.Now()
+	filter := HistoryFilter{Limit: *limit}
+	if *since != "" {
+		if filter.Since, err = parseTime(*since, now); err != nil {
+			return err
+		}
+	}
+	if *until != "" {
+		if filter.Until, err = parseTime(*until, now); err != nil {
+			return err
+		}
+	}
+	if *outcomes != "" {
+		for _, outcome := range strings.Split(*outcomes, ",") {
+			outcome = strings.TrimSpace(outcome)
+			if !containsString(AllOutcomes, outcome) {
+				return fmt.Errorf("%s is not an outcome. The outcomes are %s", outcome, strings.Join(AllOutcomes, ", "))
+			}
+			filter.Outcomes = append(filter.Outcomes, outcome)
+		}
+	}
+
+	repo, err := resolveRepoArg(positional[0])
+	if err != nil {
+		return err
+	}
+	store, err := DefaultStateStore()
+	if err != nil {
+		return err
+	}
+	record, err := store.Load(repo)
+	if err != nil {
+		return err
+	}
+	attempts := filter.Apply(record.History)
+
+	if *asJson {
+		if attempts == nil {
+			attempts = []SyncAttempt{}
+		}
+		encoder := json.NewEncoder(stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(attempts)
+	}
+
+	if len(attempts) == 0 {
+		fmt.Fprintf(stdout, "No sync attempts of %s match.\n", repo)
+		return nil
+	}
+	for _, attempt := range attempts {
+		formatAttempt(stdout, attempt)
+	}
+	return nil
+}
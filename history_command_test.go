@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"github.com/tanin47/git-notes/internal/test_helpers"
+	"testing"
+	"time"
+)
+
+func TestParseTime(t *testing.T) {
+	now := time.Date(2020, 1, 6, 20, 0, 0, 0, time.UTC)
+
+	since, err := parseTime("24h", now)
+	assert.NoError(t, err)
+	assert.Equal(t, now.Add(-24*time.Hour), since)
+
+	since, err = parseTime("2020-01-02", now)
+	assert.NoError(t, err)
+	assert.Equal(t, time.Date(2020, 1, 2, 0, 0, 0, 0, time.UTC), since)
+
+	since, err = parseTime("2020-01-02T10:00:00Z", now)
+	assert.NoError(t, err)
+	assert.Equal(t, time.Date(2020, 1, 2, 10, 0, 0, 0, time.UTC), since)
+
+	_, err = parseTime("yesterday", now)
+	assert.EqualError(t, err, "yesterday is not a duration, a date like 2006-01-02, or an RFC3339 timestamp")
+}
+
+func TestHistoryFilter(t *testing.T) {
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	var history []SyncAttempt
+	for i, outcome := range []string{OutcomeSynced, OutcomeFailed, OutcomeSynced, OutcomeConflict, OutcomeSynced} {
+		history = append(history, SyncAttempt{Started: start.Add(time.Duration(i) * time.Hour), Outcome: outcome})
+	}
+
+	assert.Equal(t, []SyncAttempt{history[4], history[3], history[2], history[1], history[0]}, HistoryFilter{}.Apply(history))
+	assert.Equal(t, []SyncAttempt{history[4], history[3]}, HistoryFilter{Limit: 2}.Apply(history))
+	assert.Equal(t, []SyncAttempt{history[3], history[2]}, HistoryFilter{Since: start.Add(2 * time.Hour), Until: start.Add(4 * time.Hour)}.Apply(history))
+	assert.Equal(t, []SyncAttempt{history[3], history[1]}, HistoryFilter{Outcomes: []string{OutcomeFailed, OutcomeConflict}}.Apply(history))
+}
+
+func TestFormatAttempt(t *testing.T) {
+	var buffer bytes.Buffer
+	formatAttempt(&buffer, SyncAttempt{
+		Started:  time.Date(2020, 1, 1, 10, 0, 0, 0, time.Local),
+		Duration: 1234 * time.Millisecond,
+		States:   []State{OutOfSync, Dirty, Ahead, Sync},
+		Commits:  []string{"0123456789abcdef"},
+		Files:    []string{"a.md", "b.md"},
+		Merges:   []MergeOutcome{{Conflicts: []string{"a.md"}}},
+		Outcome:  OutcomeConflict,
+	})
+	formatAttempt(&buffer, SyncAttempt{
+		Started: time.Date(2020, 1, 1, 9, 0, 0, 0, time.Local),
+		States:  []State{Ahead},
+		Outcome: OutcomeFailed,
+		Error:   "git push failed",
+	})
+
+	assert.Equal(t, "2020-01-01 10:00:00  conflict    1.2s  out-of-sync -> dirty -> ahead -> sync\n"+
+		"    commits: 0123456\n"+
+		"    files: a.md, b.md\n"+
+		"    merge: conflict in a.md\n"+
+		"2020-01-01 09:00:00  failed        0s  ahead\n"+
+		"    error: git push failed\n", buffer.String())
+}
+
+func TestRunHistory(t *testing.T) {
+	repos := test_helpers.SetupRepos()
+	defer test_helpers.CleanupRepos(repos)
+
+	var buffer bytes.Buffer
+	oldStdout := stdout
+	stdout = &buffer
+	defer func() { stdout = oldStdout }()
+
+	test_helpers.WriteFile(t, repos.Local, "test.md", "TestContent")
+	performSync(t, repos.Local)
+
+	makeConflict(t, repos.Remote)
+	test_helpers.WriteFile(t, repos.Local, "test.md", "TestContent2")
+	performSync(t, repos.Local)
+
+	assert.NoError(t, runHistory([]string{repos.Local, "--json"}))
+	var attempts []SyncAttempt
+	assert.NoError(t, json.Unmarshal(buffer.Bytes(), &attempts))
+	assert.Equal(t, 2, len(attempts))
+	assert.Equal(t, OutcomeConflict, attempts[0].Outcome)
+	assert.Equal(t, []MergeOutcome{{Conflicts: []string{"test.md"}}}, attempts[0].Merges)
+	assert.Equal(t, []string{"test.md"}, attempts[0].Files)
+	assert.Equal(t, OutcomeSynced, attempts[1].Outcome)
+	assert.Equal(t, []State{Dirty, Ahead, Sync}, attempts[1].States)
+
+	buffer.Reset()
+	assert.NoError(t, runHistory([]string{"--outcome", "failed", repos.Local}))
+	assert.Contains(t, buffer.String(), "No sync attempts of")
+
+	assert.EqualError(t, runHistory([]string{repos.Local, "--outcome", "lost"}), "lost is not an outcome. The outcomes are synced, conflict, held, blocked, needs-confirmation, failed")
+}
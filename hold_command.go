@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+func init() {
+	commands["hold"] = Command{
+		Usage: "hold [<repo>]",
+		Run:   runHold,
+	}
+}
+
+// processAlive sends the signal 0, which only checks that the process exists.
+func processAlive(pid int) bool {
+	process, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	err = process.Signal(syscall.Signal(0))
+	return err == nil || err == syscall.EPERM
+}
+
+// runHold starts a shell in the repo and holds back the syncs until the shell exits. If git-notes hold
+// is killed, the hold ends with it.
+func runHold(args []string) error {
+	if len(args) > 1 {
+		return fmt.Errorf("usage: git-notes %s", commands["hold"].Usage)
+	}
+	arg := "."
+	if len(args) == 1 {
+		arg = args[0]
+	}
+	repo, err := resolveRepoArg(arg)
+	if err != nil {
+		return err
+	}
+
+	store, err := DefaultPauseStore()
+	if err != nil {
+		return err
+	}
+	pause, paused, err := store.Paused(repo, time.Now())
+	if err != nil {
+		return err
+	}
+	if paused {
+		log.Printf("%s is already %s", repo, pause)
+	} else {
+		err = store.Pause(repo, Pause{Since: time.Now(), PID: os.Getpid()})
+		if err != nil {
+			return err
+		}
+		defer func() {
+			if err := store.Release(repo, os.Getpid()); err != nil {
+				log.Printf("Unable to release the hold of %s. Err: %v", repo, err)
+			}
+		}()
+		log.Printf("%s is held. It syncs again when you exit this shell", repo)
+	}
+
+	shell := os.Getenv("SHELL")
+	if shell == "" {
+		shell = "sh"
+	}
+	// Ctrl-C in the shell mustn't kill the hold before the shell exits.
+	signal.Ignore(os.Interrupt)
+	defer signal.Reset(os.Interrupt)
+
+	cmd := exec.Command(shell)
+	cmd.Dir = repo
+	cmd.Env = append(os.Environ(), "GIT_NOTES_HOLD="+repo)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	err = cmd.Run()
+	if _, ok := err.(*exec.ExitError); ok {
+		// The exit code of the last command in the shell isn't a failure of the hold.
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/tanin47/git-notes/internal/test_helpers"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestPause_Hold(t *testing.T) {
+	now := time.Now()
+	assert.True(t, Pause{Since: now, PID: os.Getpid()}.ActiveAt(now))
+	assert.False(t, Pause{Since: now, PID: 1 << 30}.ActiveAt(now))
+	assert.Equal(t, "held by git-notes hold (pid 42)", Pause{PID: 42}.String())
+}
+
+func TestPauseStore_Release(t *testing.T) {
+	dir, err := ioutil.TempDir("", "git-notes-pauses")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	store := NewPauseStore(dir)
+	now := time.Now()
+
+	assert.NoError(t, store.Pause("/notes", Pause{Since: now}))
+	assert.NoError(t, store.Release("/notes", os.Getpid()))
+	_, paused, err := store.Paused("/notes", now)
+	assert.NoError(t, err)
+	assert.True(t, paused)
+
+	assert.NoError(t, store.Pause("/notes", Pause{Since: now, PID: os.Getpid()}))
+	assert.NoError(t, store.Release("/notes", os.Getpid()))
+	_, paused, err = store.Paused("/notes", now)
+	assert.NoError(t, err)
+	assert.False(t, paused)
+}
+
+func TestHold(t *testing.T) {
+	repos := test_helpers.SetupRepos()
+	defer test_helpers.CleanupRepos(repos)
+
+	dir, err := ioutil.TempDir("", "git-notes-hold")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	shell := filepath.Join(dir, "shell")
+	assert.NoError(t, ioutil.WriteFile(shell, []byte("#!/bin/sh\npwd > \"$HOLD_OUT\"\necho \"$GIT_NOTES_HOLD\" >> \"$HOLD_OUT\"\ncat \"$XDG_STATE_HOME/git-notes/pauses.json\" >> \"$HOLD_OUT\"\nexit 3\n"), 0700))
+
+	oldShell := os.Getenv("SHELL")
+	defer os.Setenv("SHELL", oldShell)
+	os.Setenv("SHELL", shell)
+	os.Setenv("HOLD_OUT", filepath.Join(dir, "out"))
+	defer os.Unsetenv("HOLD_OUT")
+
+	repo, err := filepath.EvalSymlinks(repos.Local)
+	assert.NoError(t, err)
+	assert.NoError(t, runHold([]string{repos.Local}))
+
+	out, err := ioutil.ReadFile(filepath.Join(dir, "out"))
+	assert.NoError(t, err)
+	assert.Contains(t, string(out), repo+"\n"+repo+"\n")
+	assert.Contains(t, string(out), `"pid": `)
+
+	store, err := DefaultPauseStore()
+	assert.NoError(t, err)
+	_, paused, err := store.Paused(repo, time.Now())
+	assert.NoError(t, err)
+	assert.False(t, paused)
+}
+
+func TestHold_Usage(t *testing.T) {
+	assert.EqualError(t, runHold([]string{"a", "b"}), "usage: git-notes hold [<repo>]")
+}
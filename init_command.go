@@ -0,0 +1,386 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+func init() {
+	commands["init"] = Command{
+		Usage: "init <path> --remote <url> --config <config-file> [--branch <branch>]",
+		Run:   runInit,
+	}
+}
+
+func runInit(args []string) error {
+	flags := flag.NewFlagSet("init", flag.ContinueOnError)
+	remote := flags.String("remote", "", "The URL of the remote repo")
+	configPath := flags.String("config", "", "The config file that the repo is added to")
+	branch := flags.String("branch", "", "The branch to check out. Defaults to the default branch of the remote, or init.defaultBranch if the remote is empty")
+
+	positional, err := parseInterspersed(flags, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 1 || *remote == "" || *configPath == "" {
+		return fmt.Errorf("usage: git-notes %s", commands["init"].Usage)
+	}
+
+	path, err := expandPath(positional[0])
+	if err != nil {
+		return err
+	}
+	path, err = filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+
+	err = InitRepo(path, *remote, *branch)
+	if err != nil {
+		return err
+	}
+
+	err = AddRepoToConfig(*configPath, path)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("%s is ready and has been added to %s", path, *configPath)
+	return nil
+}
+
+// InitRepo clones the remote into path if the remote has content. Otherwise, it creates the repo,
+// makes an initial commit, sets up the upstream, and pushes. Without a branch, the clone checks out
+// the default branch of the remote, and a new repo uses init.defaultBranch.
+func InitRepo(path string, remote string, branch string) error {
+	if entries, err := ioutil.ReadDir(path); err == nil && len(entries) > 0 {
+		return fmt.Errorf("%s already exists and is not empty", path)
+	}
+
+	out, err := runCmd("", "git", "ls-remote", "--heads", remote)
+	if err != nil {
+		return fmt.Errorf("unable to reach the remote %s. Out: %s, Err: %v", remote, out, err)
+	}
+
+	if strings.TrimSpace(out) != "" {
+		log.Printf("The remote has content. Cloning %s into %s", remote, path)
+		args := []string{"clone", "--origin", "origin"}
+		if branch != "" {
+			args = append(args, "--branch", branch)
+		}
+		out, err = runCmd("", "git", append(args, remote, path)...)
+		if err != nil {
+			return fmt.Errorf("unable to clone. Out: %s, Err: %v", out, err)
+		}
+		return nil
+	}
+
+	log.Printf("The remote is empty. Initializing %s", path)
+	err = os.MkdirAll(path, 0755)
+	if err != nil {
+		return err
+	}
+
+	steps := [][]string{{"init"}}
+	if branch != "" {
+		steps = append(steps, []string{"symbolic-ref", "HEAD", "refs/heads/" + branch})
+	}
+	steps = append(steps,
+		[]string{"-c", "user.name='Git notes'", "-c", "user.email='git-notes@noemail.com'", "commit", "--allow-empty", "-m", "Initialized by Git Notes"},
+		[]string{"remote", "add", "origin", remote},
+		[]string{"push", "origin", "HEAD", "-u"},
+	)
+	for _, step := range steps {
+		out, err = runCmd(path, "git", step...)
+		if err != nil {
+			return fmt.Errorf("git %s failed. Out: %s, Err: %v", strings.Join(step, " "), out, err)
+		}
+	}
+	return nil
+}
+
+// AddRepoToConfig appends the repo to the config file, creating the file if it doesn't exist. The
+// rest of the file is kept as it is, comments included.
+func AddRepoToConfig(configPath string, repo string) error {
+	data, err := ioutil.ReadFile(configPath)
+	if os.IsNotExist(err) {
+		data, err = nil, nil
+	}
+	if err != nil {
+		return err
+	}
+
+	switch strings.ToLower(filepath.Ext(configPath)) {
+	case ".yaml", ".yml":
+		data, err = addRepoToYaml(data, repo)
+	case ".toml":
+		data, err = addRepoToToml(data, repo)
+	default:
+		data, err = addRepoToJson(data, repo)
+	}
+	if err != nil {
+		return fmt.Errorf("unable to update %s. Err: %v", configPath, err)
+	}
+
+	return ioutil.WriteFile(configPath, data, 0644)
+}
+
+func containsRepo(repos []string, repo string) bool {
+	for _, existing := range repos {
+		expanded, err := expandPath(existing)
+		if err == nil && expanded == repo {
+			return true
+		}
+	}
+	return false
+}
+
+// addRepoToJson edits the repos list in place, so that the rest of the file keeps its order and
+// formatting. The key matches in any case, and the last one wins, as when the config is read.
+func addRepoToJson(data []byte, repo string) ([]byte, error) {
+	quoted, err := json.Marshal(repo)
+	if err != nil {
+		return nil, err
+	}
+	if len(bytes.TrimSpace(data)) == 0 {
+		return []byte("{\n  \"repos\": [\n    " + string(quoted) + "\n  ]\n}\n"), nil
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	token, err := decoder.Token()
+	if err != nil {
+		return nil, err
+	}
+	if token != json.Delim('{') {
+		return nil, fmt.Errorf("the config isn't a JSON object")
+	}
+
+	// A new key goes after the last value, and a new repo after the last repo, each with the indent of
+	// the one before it.
+	keyEnd, keyIndent, keys := int(decoder.InputOffset()), "", 0
+	repoEnd, repoIndent, found := 0, "", false
+	var repos []string
+	for decoder.More() {
+		indent := jsonIndent(data, int(decoder.InputOffset()))
+		token, err = decoder.Token()
+		if err != nil {
+			return nil, err
+		}
+
+		if key, _ := token.(string); strings.EqualFold(key, "repos") {
+			token, err = decoder.Token()
+			if err != nil {
+				return nil, err
+			}
+			if token != json.Delim('[') {
+				return nil, fmt.Errorf("%s isn't a list", key)
+			}
+
+			repoEnd, repoIndent, found, repos = int(decoder.InputOffset()), "", true, nil
+			for decoder.More() {
+				repoIndent = jsonIndent(data, int(decoder.InputOffset()))
+				var value interface{}
+				err = decoder.Decode(&value)
+				if err != nil {
+					return nil, err
+				}
+				repos = append(repos, fmt.Sprint(value))
+				repoEnd = int(decoder.InputOffset())
+			}
+			_, err = decoder.Token()
+		} else {
+			var value json.RawMessage
+			err = decoder.Decode(&value)
+		}
+		if err != nil {
+			return nil, err
+		}
+		keyEnd, keyIndent, keys = int(decoder.InputOffset()), indent, keys+1
+	}
+
+	var at int
+	var insert string
+	switch {
+	case found && containsRepo(repos, repo):
+		return data, nil
+	case found:
+		at, insert = repoEnd, string(quoted)
+		if len(repos) > 0 {
+			insert = "," + repoIndent + insert
+		}
+	default:
+		at, insert = keyEnd, `"repos": [`+string(quoted)+`]`
+		if keys > 0 {
+			insert = "," + keyIndent + insert
+		}
+	}
+
+	out := append([]byte{}, data[:at]...)
+	out = append(out, insert...)
+	return append(out, data[at:]...), nil
+}
+
+// jsonIndent returns the whitespace before the JSON value at or after offset, after its comma if any.
+// A value on the same line is separated by a space.
+func jsonIndent(data []byte, offset int) string {
+	for offset < len(data) && strings.ContainsRune(" \t\r\n,", rune(data[offset])) {
+		offset++
+	}
+	start := offset
+	for start > 0 && strings.ContainsRune(" \t\r\n", rune(data[start-1])) {
+		start--
+	}
+	if start == offset {
+		return " "
+	}
+	return string(data[start:offset])
+}
+
+func addRepoToYaml(data []byte, repo string) ([]byte, error) {
+	var document yaml.Node
+	err := yaml.Unmarshal(data, &document)
+	if err != nil {
+		return nil, err
+	}
+	if len(document.Content) == 0 {
+		document = yaml.Node{Kind: yaml.DocumentNode, Content: []*yaml.Node{{Kind: yaml.MappingNode}}}
+	}
+	root := document.Content[0]
+
+	var repos *yaml.Node
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		if root.Content[i].Value == "repos" {
+			repos = root.Content[i+1]
+		}
+	}
+	if repos == nil {
+		repos = &yaml.Node{Kind: yaml.SequenceNode}
+		root.Content = append(root.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: "repos"}, repos)
+	}
+
+	var existing []string
+	for _, node := range repos.Content {
+		existing = append(existing, node.Value)
+	}
+	if !containsRepo(existing, repo) {
+		repos.Content = append(repos.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: repo})
+	}
+
+	var buffer bytes.Buffer
+	encoder := yaml.NewEncoder(&buffer)
+	encoder.SetIndent(2)
+	err = encoder.Encode(&document)
+	if err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+// addRepoToToml edits the repos array in place like addRepoToJson. The array must come before the
+// first table, as every top-level key does.
+func addRepoToToml(data []byte, repo string) ([]byte, error) {
+	config := map[string]interface{}{}
+	_, err := toml.Decode(string(data), &config)
+	if err != nil {
+		return nil, err
+	}
+
+	var repos []string
+	for key, value := range config {
+		if raw, ok := value.([]interface{}); ok && strings.EqualFold(key, "repos") {
+			for _, r := range raw {
+				repos = append(repos, fmt.Sprint(r))
+			}
+		}
+	}
+	if containsRepo(repos, repo) {
+		return data, nil
+	}
+	quoted, err := json.Marshal(repo)
+	if err != nil {
+		return nil, err
+	}
+
+	top := len(data)
+	if loc := tomlTable.FindIndex(data); loc != nil {
+		top = loc[0]
+	}
+	loc := tomlRepos.FindIndex(data[:top])
+	if loc == nil {
+		line := "repos = [" + string(quoted) + "]\n"
+		if top > 0 && data[top-1] != '\n' {
+			line = "\n" + line
+		}
+		out := append([]byte{}, data[:top]...)
+		out = append(out, line...)
+		return append(out, data[top:]...), nil
+	}
+
+	start, at := tomlLastValue(data, loc[1])
+	insert := string(quoted)
+	if at != loc[1] {
+		insert = "," + jsonIndent(data, start) + insert
+		// A trailing comma stays at the end, after the comment of the last repo.
+		if m := tomlTrailingComma.FindIndex(data[at:]); m != nil {
+			at += m[1] - 1
+			insert = jsonIndent(data, start) + string(quoted) + ","
+		}
+	}
+	out := append([]byte{}, data[:at]...)
+	out = append(out, insert...)
+	return append(out, data[at:]...), nil
+}
+
+var tomlTable = regexp.MustCompile(`(?m)^[ \t]*\[`)
+var tomlTrailingComma = regexp.MustCompile(`^[ \t]*,[ \t]*(#[^\r\n]*)?[\r\n]`)
+var tomlRepos = regexp.MustCompile(`(?im)^[ \t]*(repos|"repos"|'repos')[ \t]*=[ \t]*\[`)
+
+// tomlLastValue returns the start and the end of the last value of the array that starts at offset,
+// or offset twice if the array is empty. The strings and comments in the array are skipped.
+func tomlLastValue(data []byte, offset int) (int, int) {
+	start, end := offset, offset
+	for i := offset; i < len(data); {
+		switch c := data[i]; {
+		case c == ']':
+			return start, end
+		case c == '#':
+			for i < len(data) && data[i] != '\n' {
+				i++
+			}
+		case c == '"' || c == '\'':
+			start = i
+			quote := string(data[i : i+1])
+			if strings.HasPrefix(string(data[i:]), strings.Repeat(quote, 3)) {
+				quote = strings.Repeat(quote, 3)
+			}
+			i += len(quote)
+			for i < len(data) && !strings.HasPrefix(string(data[i:]), quote) {
+				if data[i] == '\\' && quote[0] == '"' {
+					i++
+				}
+				i++
+			}
+			i += len(quote)
+			end = i
+		case strings.ContainsRune(" \t\r\n,", rune(c)):
+			i++
+		default:
+			start = i
+			for i < len(data) && !strings.ContainsRune(" \t\r\n,]#", rune(data[i])) {
+				i++
+			}
+			end = i
+		}
+	}
+	return start, end
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/tanin47/git-notes/internal/test_helpers"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestInitRepo_EmptyRemote(t *testing.T) {
+	remote := test_helpers.SetupGitRepo("Remote", true)
+	defer os.RemoveAll(remote)
+	dir, err := ioutil.TempDir("", "git-notes-init")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "notes")
+	assert.NoError(t, InitRepo(path, remote, ""))
+	assertState(t, path, Sync)
+
+	test_helpers.WriteFile(t, path, "test.md", "TestContent")
+	performSync(t, path)
+	assertState(t, path, Sync)
+}
+
+func TestInitRepo_RemoteWithContent(t *testing.T) {
+	repos := test_helpers.SetupRepos()
+	defer test_helpers.CleanupRepos(repos)
+
+	test_helpers.WriteFile(t, repos.Local, "test.md", "TestContent")
+	performSync(t, repos.Local)
+
+	dir, err := ioutil.TempDir("", "git-notes-init")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "notes")
+	assert.NoError(t, InitRepo(path, repos.Remote, ""))
+	assertState(t, path, Sync)
+
+	content, err := ioutil.ReadFile(filepath.Join(path, "test.md"))
+	assert.NoError(t, err)
+	assert.Equal(t, "TestContent", string(content))
+}
+
+func TestInitRepo_Branch(t *testing.T) {
+	remote := test_helpers.SetupGitRepo("Remote", true)
+	defer os.RemoveAll(remote)
+	dir, err := ioutil.TempDir("", "git-notes-init")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "notes")
+	assert.NoError(t, InitRepo(path, remote, "main"))
+	assertState(t, path, Sync)
+	assert.Equal(t, revParse(t, path, "HEAD"), revParse(t, remote, "main"))
+
+	// The clone checks out the default branch of the remote.
+	test_helpers.PerformCmd(t, remote, "git", "symbolic-ref", "HEAD", "refs/heads/main")
+	clone := filepath.Join(dir, "clone")
+	assert.NoError(t, InitRepo(clone, remote, ""))
+	assertState(t, clone, Sync)
+	tracking, err := CurrentTracking(clone)
+	assert.NoError(t, err)
+	assert.Equal(t, "main", tracking.Branch)
+}
+
+func TestInitRepo_NonEmptyPath(t *testing.T) {
+	remote := test_helpers.SetupGitRepo("Remote", true)
+	defer os.RemoveAll(remote)
+	dir, err := ioutil.TempDir("", "git-notes-init")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	test_helpers.WriteFile(t, dir, "test.md", "TestContent")
+
+	assert.EqualError(t, InitRepo(dir, remote, ""), dir+" already exists and is not empty")
+}
+
+func TestAddRepoToConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "git-notes-config-dir")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	test_helpers.WriteFile(t, dir, "git-notes.json", `{"repos": ["/notes"], "metrics_address": ":9100"}`)
+	test_helpers.WriteFile(t, dir, "git-notes.yaml", "# My notes\nrepos:\n  - /notes # the first one\n")
+	test_helpers.WriteFile(t, dir, "git-notes.toml", "# My notes\nRepos = [\n  \"/notes\", # the first one\n]\nmetrics_address = \":9100\"\n")
+
+	for _, name := range []string{"git-notes.json", "git-notes.yaml", "git-notes.toml", "new.json", "new.yaml", "new.toml"} {
+		path := filepath.Join(dir, name)
+		assert.NoError(t, AddRepoToConfig(path, "/more-notes"), name)
+		assert.NoError(t, AddRepoToConfig(path, "/more-notes"), name)
+	}
+
+	for _, name := range []string{"git-notes.json", "git-notes.yaml", "git-notes.toml"} {
+		config, err := (&FileConfigReader{}).readFormat(filepath.Join(dir, name))
+		assert.NoError(t, err, name)
+		assert.Equal(t, []string{"/notes", "/more-notes"}, config.Repos, name)
+	}
+	for _, name := range []string{"new.json", "new.yaml", "new.toml"} {
+		config, err := (&FileConfigReader{}).readFormat(filepath.Join(dir, name))
+		assert.NoError(t, err, name)
+		assert.Equal(t, []string{"/more-notes"}, config.Repos, name)
+	}
+
+	jsonContent, err := ioutil.ReadFile(filepath.Join(dir, "git-notes.json"))
+	assert.NoError(t, err)
+	assert.Equal(t, `{"repos": ["/notes", "/more-notes"], "metrics_address": ":9100"}`, string(jsonContent))
+
+	yamlContent, err := ioutil.ReadFile(filepath.Join(dir, "git-notes.yaml"))
+	assert.NoError(t, err)
+	assert.Equal(t, "# My notes\nrepos:\n  - /notes # the first one\n  - /more-notes\n", string(yamlContent))
+
+	tomlContent, err := ioutil.ReadFile(filepath.Join(dir, "git-notes.toml"))
+	assert.NoError(t, err)
+	assert.Equal(t, "# My notes\nRepos = [\n  \"/notes\", # the first one\n  \"/more-notes\",\n]\nmetrics_address = \":9100\"\n", string(tomlContent))
+}
+
+func TestAddRepoToToml(t *testing.T) {
+	data := []byte("repos = [\"/notes\"]\n")
+	out, err := addRepoToToml(data, "/notes")
+	assert.NoError(t, err)
+	assert.Equal(t, string(data), string(out))
+
+	out, err = addRepoToToml([]byte("repos = []\n"), "/notes")
+	assert.NoError(t, err)
+	assert.Equal(t, "repos = [\"/notes\"]\n", string(out))
+
+	out, err = addRepoToToml([]byte("repos = ['/notes', \"\"\"/more\nnotes\"\"\"]"), "/other-notes")
+	assert.NoError(t, err)
+	assert.Equal(t, "repos = ['/notes', \"\"\"/more\nnotes\"\"\", \"/other-notes\"]", string(out))
+
+	out, err = addRepoToToml([]byte("metrics_address = \":9100\"\n[notifications]\ndesktop = true\n"), "/notes")
+	assert.NoError(t, err)
+	assert.Equal(t, "metrics_address = \":9100\"\nrepos = [\"/notes\"]\n[notifications]\ndesktop = true\n", string(out))
+}
+
+func TestRunInit(t *testing.T) {
+	remote := test_helpers.SetupGitRepo("Remote", true)
+	defer os.RemoveAll(remote)
+	dir, err := ioutil.TempDir("", "git-notes-init")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "notes")
+	configPath := filepath.Join(dir, "git-notes.json")
+	assert.NoError(t, runInit([]string{path, "--remote", remote, "--config", configPath}))
+
+	config, err := (&FileConfigReader{}).Read(configPath)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{resolvedPath(t, path)}, config.Repos)
+
+	assert.Error(t, runInit([]string{path}))
+}
+
+func TestRunInit_ExistingJsonConfig(t *testing.T) {
+	remote := test_helpers.SetupGitRepo("Remote", true)
+	defer os.RemoveAll(remote)
+	dir, err := ioutil.TempDir("", "git-notes-init")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "notes")
+	configPath := filepath.Join(dir, "git-notes.json")
+	test_helpers.WriteFile(t, dir, "git-notes.json", "{\n  \"Repos\": [\n    \"/notes\"\n  ],\n  \"metrics_address\": \":9100\"\n}\n")
+	assert.NoError(t, runInit([]string{path, "--remote", remote, "--config", configPath}))
+
+	config, err := (&FileConfigReader{}).readFormat(configPath)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"/notes", path}, config.Repos)
+
+	content, err := ioutil.ReadFile(configPath)
+	assert.NoError(t, err)
+	assert.Equal(t, "{\n  \"Repos\": [\n    \"/notes\",\n    \""+path+"\"\n  ],\n  \"metrics_address\": \":9100\"\n}\n", string(content))
+
+	// A config without repos gets the key after the other settings.
+	test_helpers.WriteFile(t, dir, "other.json", "{\n  \"metrics_address\": \":9100\"\n}\n")
+	assert.NoError(t, AddRepoToConfig(filepath.Join(dir, "other.json"), "/notes"))
+	content, err = ioutil.ReadFile(filepath.Join(dir, "other.json"))
+	assert.NoError(t, err)
+	assert.Equal(t, "{\n  \"metrics_address\": \":9100\",\n  \"repos\": [\"/notes\"]\n}\n", string(content))
+}
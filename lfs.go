@@ -0,0 +1,158 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+type LFSOptions struct {
+	Patterns      []string `json:"patterns" yaml:"patterns" toml:"patterns"`
+	SizeThreshold string   `json:"size_threshold" yaml:"size_threshold" toml:"size_threshold"`
+}
+
+func (o LFSOptions) Enabled() bool {
+	return len(o.Patterns) > 0 || o.SizeThreshold != ""
+}
+
+var sizeUnits = map[string]int64{
+	"":   1,
+	"B":  1,
+	"KB": 1 << 10,
+	"MB": 1 << 20,
+	"GB": 1 << 30,
+}
+
+// ParseSize parses sizes like 500KB or 10MB. The units are powers of 1024.
+func ParseSize(size string) (int64, error) {
+	size = strings.ToUpper(strings.TrimSpace(size))
+	number := strings.TrimRight(size, "KMGB")
+	unit, ok := sizeUnits[strings.TrimSpace(size[len(number):])]
+	if !ok {
+		return 0, fmt.Errorf("unknown unit in %s", size)
+	}
+
+	value, err := strconv.ParseFloat(strings.TrimSpace(number), 64)
+	if err != nil || value < 0 {
+		return 0, fmt.Errorf("%s is not a size", size)
+	}
+	return int64(value * float64(unit)), nil
+}
+
+func runLFS(path string, args ...string) error {
+	return timeGitOp(path, "lfs-"+args[0], func() error {
+		out, err := runCmd(path, "git", append([]string{"lfs"}, args...)...)
+		if err != nil {
+			return fmt.Errorf("%v. Out: %s", err, out)
+		}
+		return nil
+	})
+}
+
+// PrepareLFS installs the LFS filters into the repo and tracks the configured patterns and the
+// changed files that are larger than the size threshold. The tracking goes into .gitattributes.
+func PrepareLFS(path string, options LFSOptions) error {
+	if out, err := runCmd(path, "git", "config", "--local", "filter.lfs.clean"); err != nil || strings.TrimSpace(out) == "" {
+		err = runLFS(path, "install", "--local")
+		if err != nil {
+			return err
+		}
+	}
+
+	attributes, _ := ioutil.ReadFile(filepath.Join(path, ".gitattributes"))
+	tracked := map[string]bool{}
+	for _, line := range strings.Split(string(attributes), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) > 1 && strings.Contains(line, "filter=lfs") {
+			tracked[fields[0]] = true
+		}
+	}
+
+	var patterns []string
+	for _, pattern := range options.Patterns {
+		if !tracked[pattern] {
+			patterns = append(patterns, pattern)
+		}
+	}
+	if len(patterns) > 0 {
+		err := runLFS(path, append([]string{"track", "--"}, patterns...)...)
+		if err != nil {
+			return err
+		}
+	}
+
+	if options.SizeThreshold == "" {
+		return nil
+	}
+	threshold, err := ParseSize(options.SizeThreshold)
+	if err != nil {
+		return err
+	}
+
+	large, err := largeUntrackedFiles(path, threshold)
+	if err != nil {
+		return err
+	}
+	if len(large) > 0 {
+		return runLFS(path, append([]string{"track", "--filename", "--"}, large...)...)
+	}
+	return nil
+}
+
+// largeUntrackedFiles returns the changed files that are larger than the threshold and are not
+// handled by LFS yet.
+func largeUntrackedFiles(path string, threshold int64) ([]string, error) {
+	out, err := runCmd(path, "git", "status", "--porcelain", "-z", "--untracked-files=all")
+	if err != nil {
+		return nil, fmt.Errorf("unable to get status. Error: %v", err)
+	}
+
+	var candidates []string
+	entries := strings.Split(out, "\x00")
+	for i := 0; i < len(entries); i++ {
+		entry := entries[i]
+		if len(entry) < 4 {
+			continue
+		}
+		if entry[0] == 'R' || entry[0] == 'C' {
+			i++ // The next entry is the original path.
+		}
+
+		file := entry[3:]
+		info, err := os.Stat(filepath.Join(path, file))
+		if err != nil || info.IsDir() || info.Size() <= threshold {
+			continue
+		}
+		candidates = append(candidates, file)
+	}
+	if len(candidates) == 0 {
+		return nil, nil
+	}
+
+	out, err = runCmd(path, "git", append([]string{"check-attr", "filter", "--"}, candidates...)...)
+	if err != nil {
+		return nil, fmt.Errorf("unable to check the attributes. Error: %v", err)
+	}
+
+	var large []string
+	for _, line := range strings.Split(strings.TrimSpace(out), "\n") {
+		if strings.HasSuffix(line, ": filter: lfs") {
+			continue
+		}
+		if index := strings.LastIndex(line, ": filter: "); index >= 0 {
+			large = append(large, line[:index])
+		}
+	}
+	return large, nil
+}
+
+func PushLFS(path string, tracking Tracking) error {
+	return runLFS(path, "push", tracking.Remote, tracking.Branch)
+}
+
+func FetchLFS(path string, tracking Tracking) error {
+	return runLFS(path, "fetch", tracking.Remote, tracking.Upstream())
+}
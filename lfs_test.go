@@ -0,0 +1,77 @@
+package main
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/tanin47/git-notes/internal/test_helpers"
+	"io/ioutil"
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+func skipWithoutLFS(t *testing.T) {
+	if err := exec.Command("git", "lfs", "version").Run(); err != nil {
+		t.Skip("git-lfs is not installed")
+	}
+}
+
+func TestParseSize(t *testing.T) {
+	for input, expected := range map[string]int64{"100": 100, "100B": 100, "1KB": 1024, "1.5 MB": 1572864, "2gb": 2147483648} {
+		size, err := ParseSize(input)
+		assert.NoError(t, err, input)
+		assert.Equal(t, expected, size, input)
+	}
+
+	for _, input := range []string{"", "MB", "-1KB", "1TB", "big"} {
+		_, err := ParseSize(input)
+		assert.Error(t, err, input)
+	}
+}
+
+func assertLFSPointer(t *testing.T, remote string, file string) {
+	out, err := runCmd(remote, "git", "show", "master:"+file)
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(out, "version https://git-lfs.github.com/spec/v1"), out)
+}
+
+func TestGoGit_LFS(t *testing.T) {
+	skipWithoutLFS(t)
+
+	repos := test_helpers.SetupRepos()
+	defer test_helpers.CleanupRepos(repos)
+
+	gogit := GitCmd{options: map[string]RepoOptions{
+		repos.Local: {LFS: LFSOptions{Patterns: []string{"*.pdf"}, SizeThreshold: "1KB"}},
+	}}
+
+	test_helpers.WriteFile(t, repos.Local, "paper.pdf", "PDF")
+	test_helpers.WriteFile(t, repos.Local, "photo.jpg", strings.Repeat("x", 2048))
+	test_helpers.WriteFile(t, repos.Local, "test.md", "TestContent")
+	assert.NoError(t, gogit.Sync(repos.Local))
+
+	assertLFSPointer(t, repos.Remote, "paper.pdf")
+	assertLFSPointer(t, repos.Remote, "photo.jpg")
+
+	out, err := runCmd(repos.Remote, "git", "show", "master:test.md")
+	assert.NoError(t, err)
+	assert.Equal(t, "TestContent", out)
+
+	out, err = runCmd(repos.Remote, "git", "show", "master:.gitattributes")
+	assert.NoError(t, err)
+	assert.Equal(t, "*.pdf filter=lfs diff=lfs merge=lfs -text\nphoto.jpg filter=lfs diff=lfs merge=lfs -text\n", out)
+
+	// Another machine pushes an attachment. Merging it brings the content, not the pointer.
+	another := test_helpers.SetupGitRepo("another_local", false)
+	test_helpers.SetupRemote(another, repos.Remote)
+	test_helpers.PerformCmd(t, another, "git", "fetch")
+	test_helpers.PerformCmd(t, another, "git", "lfs", "install", "--local")
+	test_helpers.PerformCmd(t, another, "git", "checkout", "master")
+	test_helpers.PerformCmd(t, another, "git", "lfs", "pull")
+	test_helpers.WriteFile(t, another, "another.pdf", "Another PDF")
+	assert.NoError(t, gogit.Sync(another))
+
+	assert.NoError(t, gogit.Sync(repos.Local))
+	content, err := ioutil.ReadFile(repos.Local + "/another.pdf")
+	assert.NoError(t, err)
+	assert.Equal(t, "Another PDF", string(content))
+}
@@ -9,7 +9,22 @@ import (
 
 var Running = true
 
+// Configurable is implemented by the components that need the config after it's been read.
+type Configurable interface {
+	Configure(config *Config)
+}
+
 func main() {
+	if len(os.Args) > 1 {
+		if command, ok := commands[os.Args[1]]; ok {
+			err := command.Run(os.Args[2:])
+			if err != nil {
+				log.Fatal(err)
+			}
+			return
+		}
+	}
+
 	log.Println("Git Notes is starting...")
 
 	var git = NewGoGit()
@@ -20,7 +35,7 @@ func main() {
 		delayBeforeFiringEvent: 2 * time.Second,
 		delayAfterFiringEvent: 5 * time.Second,
 	}
-	var configReader = JsonConfigReader{}
+	var configReader = FileConfigReader{}
 	var gitRepoMonitor = GitRepoMonitor{
 		scheduledUpdateInterval: 5 * time.Minute,
 	}
@@ -34,7 +49,7 @@ func main() {
 
 func Run(git Git, watcher Watcher, configReader ConfigReader, monitor PathMonitor) {
 	if len(os.Args) < 2 {
-		log.Fatal("Please pass the config file path as the first argument.")
+		log.Fatalf("Please pass the config file path as the first argument.\n%s", commandUsage())
 	}
 	configPath := os.Args[1]
 	config, err := configReader.Read(configPath)
@@ -44,8 +59,28 @@ func Run(git Git, watcher Watcher, configReader ConfigReader, monitor PathMonito
 	}
 
 	fmt.Println(config)
+	if configurable, ok := git.(Configurable); ok {
+		configurable.Configure(config)
+	}
+	if config.MetricsAddress != "" {
+		requester, _ := monitor.(SyncRequester)
+		ServeMetrics(config.MetricsAddress, requester)
+	}
+	devicePolicy = NewDevicePolicy(&SystemDeviceState{}, config.DevicePolicy)
+	notifier, err = NewNotifierFromConfig(config.Notifications)
+	if err != nil {
+		log.Fatalf("Invalid notification config. Err: %v", err)
+	}
 	for _, repoPath := range config.Repos {
 		monitor.StartMonitoring(repoPath, watcher, git)
 	}
+
+	if len(config.Discover.Roots) > 0 {
+		discoverer, err := NewRepoDiscoverer(config.Discover, config.Repos, monitor, watcher, git)
+		if err != nil {
+			log.Fatalf("Invalid discover config. Err: %v", err)
+		}
+		discoverer.Start()
+	}
 }
 
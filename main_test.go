@@ -10,6 +10,31 @@ import (
 	"time"
 )
 
+// TestMain lets git run the test binary as git-notes, e.g. as the filter of the encrypted files. The
+// state directory is temporary so that the tests don't touch the real one.
+func TestMain(m *testing.M) {
+	if len(os.Args) > 1 {
+		if command, ok := commands[os.Args[1]]; ok && command.Hidden {
+			err := command.Run(os.Args[2:])
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+			os.Exit(0)
+		}
+	}
+
+	stateHome, err := ioutil.TempDir("", "git-notes-state-home")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	os.Setenv("XDG_STATE_HOME", stateHome)
+	code := m.Run()
+	os.RemoveAll(stateHome)
+	os.Exit(code)
+}
+
 func TestMainFunc(t *testing.T) {
 	Running = true
 
@@ -88,11 +113,16 @@ func (m *MockConfigReader) Read(path string) (*Config, error) {
 
 type MockMonitor struct {
 	startMonitorPaths []string
+	stopMonitorPaths  []string
 }
 
 func (m *MockMonitor) StartMonitoring(repoPath string, watcher Watcher, git Git) {
 	m.startMonitorPaths = append(m.startMonitorPaths, repoPath)
 }
 
+func (m *MockMonitor) StopMonitoring(repoPath string) {
+	m.stopMonitorPaths = append(m.stopMonitorPaths, repoPath)
+}
+
 func (m *MockMonitor) scheduleUpdate(repoPath string, channel chan string) {
 }
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const defaultGracePeriod = 2 * time.Minute
+
+type ManualActivityConfig struct {
+	Disabled    bool   `json:"disabled" yaml:"disabled" toml:"disabled"`
+	GracePeriod string `json:"grace_period" yaml:"grace_period" toml:"grace_period"`
+}
+
+// ActivityMonitor holds back the syncs of a repo while someone uses git in it by hand, and for the
+// grace period after the last sign of it. Otherwise, a sync could commit the rest of a change halfway
+// through `git add -p`.
+type ActivityMonitor struct {
+	disabled    bool
+	gracePeriod time.Duration
+	procDir     string
+	mutex       sync.Mutex
+	lastSeen    map[string]time.Time
+}
+
+var defaultActivityMonitor = NewActivityMonitor(ManualActivityConfig{})
+
+func NewActivityMonitor(config ManualActivityConfig) *ActivityMonitor {
+	gracePeriod := defaultGracePeriod
+	if config.GracePeriod != "" {
+		if parsed, err := time.ParseDuration(config.GracePeriod); err == nil {
+			gracePeriod = parsed
+		}
+	}
+	return &ActivityMonitor{
+		disabled:    config.Disabled,
+		gracePeriod: gracePeriod,
+		procDir:     "/proc",
+		lastSeen:    map[string]time.Time{},
+	}
+}
+
+// Check returns why the sync should wait. heldBack is whether git-notes itself left the change staged,
+// e.g. because of possible secrets, in which case a partly staged change is the fix, not someone's commit.
+func (m *ActivityMonitor) Check(path string, status RepoStatus, heldBack bool, now time.Time) (string, bool) {
+	if m.disabled {
+		return "", false
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if signs := m.signs(path, status, heldBack, now); len(signs) > 0 {
+		m.lastSeen[path] = now
+		return "manual git activity: " + strings.Join(signs, ", "), true
+	}
+	if lastSeen, ok := m.lastSeen[path]; ok {
+		if now.Sub(lastSeen) < m.gracePeriod {
+			return fmt.Sprintf("manual git activity %s ago", now.Sub(lastSeen).Round(time.Second)), true
+		}
+		delete(m.lastSeen, path)
+	}
+	return "", false
+}
+
+func (m *ActivityMonitor) signs(path string, status RepoStatus, heldBack bool, now time.Time) []string {
+	var signs []string
+
+	if m.indexLocked(status.GitDir, now) {
+		signs = append(signs, "the index is locked")
+	}
+	if pids := gitProcessesIn(m.procDir, path); len(pids) > 0 {
+		signs = append(signs, fmt.Sprintf("git is running (pid %d)", pids[0]))
+	}
+	if !heldBack && len(status.Operations) == 0 && len(status.Staged) > 0 && (len(status.Unstaged) > 0 || len(status.Untracked) > 0) {
+		signs = append(signs, "a change is partly staged")
+	}
+	return signs
+}
+
+// indexLocked reports whether a git other than the ones that git-notes runs, e.g. for the refreshes of
+// the dashboard, has locked the index. A lock older than the grace period was left behind by a git that
+// crashed.
+func (m *ActivityMonitor) indexLocked(gitDir string, now time.Time) bool {
+	if resolved, err := filepath.EvalSymlinks(gitDir); err == nil {
+		gitDir = resolved
+	}
+	lock := filepath.Join(gitDir, "index.lock")
+	info, err := os.Stat(lock)
+	if err != nil || now.Sub(info.ModTime()) >= m.gracePeriod {
+		return false
+	}
+
+	holders, ok := processesWithOpen(m.procDir, lock)
+	if !ok {
+		return true
+	}
+	for _, dir := range holders {
+		if !runByGitNotes(m.procDir, dir) {
+			return true
+		}
+	}
+	if len(holders) > 0 {
+		return false
+	}
+	// Nobody has the lock open. Either the git that held it is done by now, or it crashed.
+	_, err = os.Stat(lock)
+	return err == nil
+}
+
+// processesWithOpen lists the process directories in procfs that have the file open. It returns false
+// where there's no procfs.
+func processesWithOpen(procDir string, file string) ([]string, bool) {
+	entries, err := ioutil.ReadDir(procDir)
+	if err != nil {
+		return nil, false
+	}
+
+	var dirs []string
+	for _, entry := range entries {
+		if _, err := strconv.Atoi(entry.Name()); err != nil {
+			continue
+		}
+		dir := filepath.Join(procDir, entry.Name())
+		fds, err := ioutil.ReadDir(filepath.Join(dir, "fd"))
+		if err != nil {
+			continue
+		}
+		for _, fd := range fds {
+			if target, err := os.Readlink(filepath.Join(dir, "fd", fd.Name())); err == nil && target == file {
+				dirs = append(dirs, dir)
+				break
+			}
+		}
+	}
+	return dirs, true
+}
+
+// runByGitNotes reports whether the parent of the process is this process or another git-notes, e.g.
+// the dashboard while the daemon runs.
+func runByGitNotes(procDir string, dir string) bool {
+	ppid := parentPid(dir)
+	if ppid == os.Getpid() {
+		return true
+	}
+	self, err := os.Executable()
+	if err != nil {
+		return false
+	}
+	exe, err := os.Readlink(filepath.Join(procDir, strconv.Itoa(ppid), "exe"))
+	return err == nil && exe == self
+}
+
+// gitProcessesIn lists the git processes working in the repo, other than the ones that git-notes runs.
+// It reads procfs, so it finds nothing where there's no procfs, e.g. on macOS.
+func gitProcessesIn(procDir string, path string) []int {
+	if resolved, err := filepath.EvalSymlinks(path); err == nil {
+		path = resolved
+	}
+	entries, err := ioutil.ReadDir(procDir)
+	if err != nil {
+		return nil
+	}
+
+	var pids []int
+	for _, entry := range entries {
+		pid, err := strconv.Atoi(entry.Name())
+		if err != nil {
+			continue
+		}
+		dir := filepath.Join(procDir, entry.Name())
+		if readSysfs(dir, "comm") != "git" || runByGitNotes(procDir, dir) {
+			continue
+		}
+		cwd, err := os.Readlink(filepath.Join(dir, "cwd"))
+		if err != nil {
+			continue
+		}
+		if cwd == path || strings.HasPrefix(cwd, path+string(filepath.Separator)) {
+			pids = append(pids, pid)
+		}
+	}
+	return pids
+}
+
+// parentPid reads the 4th field of /proc/<pid>/stat. The 2nd field is the command in parentheses,
+// which may contain spaces.
+func parentPid(dir string) int {
+	stat := readSysfs(dir, "stat")
+	fields := strings.Fields(stat[strings.LastIndex(stat, ")")+1:])
+	if len(fields) < 2 {
+		return 0
+	}
+	ppid, _ := strconv.Atoi(fields[1])
+	return ppid
+}
+
+func (g *GitCmd) monitor() *ActivityMonitor {
+	if g.activity == nil {
+		return defaultActivityMonitor
+	}
+	return g.activity
+}
+
+// checkActivity returns why the sync of the repo should wait for someone using git by hand.
+func (g *GitCmd) checkActivity(path string, now time.Time) (string, bool) {
+	status, err := GetStatus(path, g.optionsFor(path))
+	if err != nil {
+		g.logger(path).Printf("Unable to check the git activity in %s. Err: %v", path, err)
+		return "", false
+	}
+
+	heldBack := false
+	if store := g.stateStore(); store != nil {
+		if record, err := store.Load(path); err == nil {
+			heldBack = record.State == Blocked || record.State == NeedsConfirmation
+		}
+	}
+	return g.monitor().Check(path, status, heldBack, now)
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/tanin47/git-notes/internal/test_helpers"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestActivityMonitor_GracePeriod(t *testing.T) {
+	monitor := NewActivityMonitor(ManualActivityConfig{GracePeriod: "1m"})
+	monitor.procDir = ""
+	now := time.Now()
+	partial := RepoStatus{Staged: []string{"a.md"}, Unstaged: []string{"b.md"}}
+
+	reason, active := monitor.Check("/notes", partial, false, now)
+	assert.True(t, active)
+	assert.Equal(t, "manual git activity: a change is partly staged", reason)
+
+	reason, active = monitor.Check("/notes", RepoStatus{}, false, now.Add(30*time.Second))
+	assert.True(t, active)
+	assert.Equal(t, "manual git activity 30s ago", reason)
+
+	_, active = monitor.Check("/notes", RepoStatus{}, false, now.Add(time.Minute))
+	assert.False(t, active)
+	_, active = monitor.Check("/notes", partial, true, now)
+	assert.False(t, active)
+	_, active = monitor.Check("/notes", RepoStatus{Staged: []string{"a.md"}}, false, now)
+	assert.False(t, active)
+
+	monitor = NewActivityMonitor(ManualActivityConfig{Disabled: true})
+	_, active = monitor.Check("/notes", partial, false, now)
+	assert.False(t, active)
+}
+
+func TestActivityMonitor_IndexLock(t *testing.T) {
+	gitDir, err := ioutil.TempDir("", "git-notes-git-dir")
+	assert.NoError(t, err)
+	defer os.RemoveAll(gitDir)
+	monitor := NewActivityMonitor(ManualActivityConfig{GracePeriod: "1m"})
+	monitor.procDir = ""
+	now := time.Now()
+
+	lock := filepath.Join(gitDir, "index.lock")
+	assert.NoError(t, ioutil.WriteFile(lock, nil, 0600))
+	reason, active := monitor.Check("/notes", RepoStatus{GitDir: gitDir}, false, now)
+	assert.True(t, active)
+	assert.Equal(t, "manual git activity: the index is locked", reason)
+
+	// A stale lock doesn't hold back the sync forever.
+	assert.NoError(t, os.Chtimes(lock, now.Add(-time.Hour), now.Add(-time.Hour)))
+	_, active = monitor.Check("/work", RepoStatus{GitDir: gitDir}, false, now)
+	assert.False(t, active)
+}
+
+func TestActivityMonitor_IndexLockOwner(t *testing.T) {
+	procDir, err := ioutil.TempDir("", "git-notes-proc")
+	assert.NoError(t, err)
+	defer os.RemoveAll(procDir)
+	gitDir, err := ioutil.TempDir("", "git-notes-git-dir")
+	assert.NoError(t, err)
+	defer os.RemoveAll(gitDir)
+	gitDir, err = filepath.EvalSymlinks(gitDir)
+	assert.NoError(t, err)
+	monitor := NewActivityMonitor(ManualActivityConfig{GracePeriod: "1m"})
+	monitor.procDir = procDir
+	lock := filepath.Join(gitDir, "index.lock")
+	assert.NoError(t, ioutil.WriteFile(lock, nil, 0600))
+
+	holder := func(pid int, ppid int) {
+		dir := filepath.Join(procDir, fmt.Sprint(pid))
+		assert.NoError(t, os.MkdirAll(filepath.Join(dir, "fd"), 0700))
+		assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "stat"), []byte(fmt.Sprintf("%d (git) S %d 1 1\n", pid, ppid)), 0600))
+		assert.NoError(t, os.Symlink(lock, filepath.Join(dir, "fd", "3")))
+	}
+
+	// A lock that nobody has open was left behind by a git that crashed a moment ago.
+	assert.True(t, monitor.indexLocked(gitDir, time.Now()))
+
+	holder(10, os.Getpid())
+	assert.False(t, monitor.indexLocked(gitDir, time.Now()))
+
+	holder(11, 1)
+	assert.True(t, monitor.indexLocked(gitDir, time.Now()))
+}
+
+func TestGitProcessesIn(t *testing.T) {
+	procDir, err := ioutil.TempDir("", "git-notes-proc")
+	assert.NoError(t, err)
+	defer os.RemoveAll(procDir)
+	repo, err := ioutil.TempDir("", "git-notes-repo")
+	assert.NoError(t, err)
+	defer os.RemoveAll(repo)
+	repo, err = filepath.EvalSymlinks(repo)
+	assert.NoError(t, err)
+	assert.NoError(t, os.Mkdir(filepath.Join(repo, "sub"), 0700))
+
+	process := func(pid int, comm string, ppid int, cwd string) {
+		dir := filepath.Join(procDir, fmt.Sprint(pid))
+		assert.NoError(t, os.Mkdir(dir, 0700))
+		assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "comm"), []byte(comm+"\n"), 0600))
+		assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "stat"), []byte(fmt.Sprintf("%d (%s) S %d 1 1\n", pid, comm, ppid)), 0600))
+		assert.NoError(t, os.Symlink(cwd, filepath.Join(dir, "cwd")))
+	}
+	process(10, "git", 1, filepath.Join(repo, "sub"))
+	process(11, "vim", 1, repo)
+	process(12, "git", os.Getpid(), repo)
+	process(13, "git", 1, repo+"-other")
+	process(14, "git", 1, repo)
+	// The dashboard, which is another git-notes, refreshes the status.
+	self, err := os.Executable()
+	assert.NoError(t, err)
+	process(15, "git-notes", 1, repo)
+	assert.NoError(t, os.Symlink(self, filepath.Join(procDir, "15", "exe")))
+	process(16, "git", 15, repo)
+
+	assert.Equal(t, []int{10, 14}, gitProcessesIn(procDir, repo))
+	assert.Empty(t, gitProcessesIn(filepath.Join(procDir, "missing"), repo))
+}
+
+func TestGoGit_BacksOffFromPartlyStagedChange(t *testing.T) {
+	repos := test_helpers.SetupRepos()
+	defer test_helpers.CleanupRepos(repos)
+	test_helpers.WriteFile(t, repos.Local, "a.md", "A")
+	test_helpers.WriteFile(t, repos.Local, "b.md", "B")
+	performSync(t, repos.Local)
+	before := headCommit(repos.Local)
+
+	gogit := GitCmd{activity: NewActivityMonitor(ManualActivityConfig{GracePeriod: "1h"})}
+	test_helpers.WriteFile(t, repos.Local, "a.md", "A2")
+	test_helpers.WriteFile(t, repos.Local, "b.md", "B2")
+	test_helpers.PerformCmd(t, repos.Local, "git", "add", "a.md")
+	assert.NoError(t, gogit.Sync(repos.Local))
+	assert.Equal(t, before, headCommit(repos.Local))
+	assert.Equal(t, Busy, metrics.states[repos.Local])
+
+	// The grace period goes on after the commit by hand.
+	test_helpers.PerformCmd(t, repos.Local, "git", "commit", "-m", "By hand")
+	assert.NoError(t, gogit.Sync(repos.Local))
+	assertState(t, repos.Local, Dirty)
+
+	gogit.activity = NewActivityMonitor(ManualActivityConfig{Disabled: true})
+	assert.NoError(t, gogit.Sync(repos.Local))
+	assertState(t, repos.Local, Sync)
+}
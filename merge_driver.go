@@ -0,0 +1,498 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const textMergeDriver = "git-notes-text"
+
+// Above this many compared lines, the merge falls back to git merge-file instead of computing the
+// diffs in memory.
+const maxTextMergeCells = 4000000
+
+type MergeOptions struct {
+	Text  []string `json:"text" yaml:"text" toml:"text"`
+	Union []string `json:"union" yaml:"union" toml:"union"`
+}
+
+func (o MergeOptions) Enabled() bool {
+	return len(o.Text) > 0 || len(o.Union) > 0
+}
+
+func init() {
+	commands["text-merge"] = Command{Hidden: true, Run: runTextMerge}
+}
+
+// addAttributes adds the lines that .gitattributes doesn't have yet, and returns whether it changed the
+// file. The later lines of .gitattributes win. Prepending puts the lines first, in the given order, so
+// that the other lines, e.g. the ones of the encrypted files, take precedence.
+func addAttributes(path string, lines []string, prepend bool) (bool, error) {
+	if len(lines) == 0 {
+		return false, nil
+	}
+	attributesPath := filepath.Join(path, ".gitattributes")
+	attributes, _ := ioutil.ReadFile(attributesPath)
+	if len(attributes) > 0 && !bytes.HasSuffix(attributes, []byte("\n")) {
+		attributes = append(attributes, '\n')
+	}
+
+	var updated []byte
+	if prepend {
+		wanted := map[string]bool{}
+		for _, line := range lines {
+			wanted[line] = true
+		}
+		var rest []string
+		for _, line := range strings.SplitAfter(string(attributes), "\n") {
+			if line != "" && !wanted[strings.TrimSpace(line)] {
+				rest = append(rest, line)
+			}
+		}
+		updated = []byte(strings.Join(lines, "\n") + "\n" + strings.Join(rest, ""))
+	} else {
+		existing := map[string]bool{}
+		for _, line := range strings.Split(string(attributes), "\n") {
+			existing[strings.TrimSpace(line)] = true
+		}
+		updated = attributes
+		for _, line := range lines {
+			if !existing[line] {
+				updated = append(updated, []byte(line+"\n")...)
+			}
+		}
+	}
+
+	if bytes.Equal(updated, attributes) {
+		return false, nil
+	}
+	return true, ioutil.WriteFile(attributesPath, updated, 0644)
+}
+
+// PrepareMerge registers git-notes as the merge driver of the text files and the append-only files,
+// and adds the patterns to .gitattributes. The append-only patterns come later, so they win over the
+// text patterns. Every machine needs the same options because git falls back to its own merge when
+// the driver isn't configured.
+func PrepareMerge(path string, options MergeOptions) error {
+	executable, err := os.Executable()
+	if err != nil {
+		return err
+	}
+
+	command := shellQuote(executable)
+	settings := [][2]string{
+		{"merge." + textMergeDriver + ".name", "git-notes text merge"},
+		{"merge." + textMergeDriver + ".driver", command + " text-merge %O %A %B %L %P"},
+		{"merge." + unionMergeDriver + ".name", "git-notes union merge"},
+		{"merge." + unionMergeDriver + ".driver", command + " union-merge %O %A %B %L %P"},
+	}
+	for _, setting := range settings {
+		out, err := runCmd(path, "git", "config", "--local", setting[0], setting[1])
+		if err != nil {
+			return fmt.Errorf("unable to set %s. Out: %s, Err: %v", setting[0], out, err)
+		}
+	}
+
+	var lines []string
+	for _, pattern := range options.Text {
+		lines = append(lines, fmt.Sprintf("%s merge=%s", pattern, textMergeDriver))
+	}
+	for _, pattern := range options.Union {
+		lines = append(lines, fmt.Sprintf("%s merge=%s", pattern, unionMergeDriver))
+	}
+	_, err = addAttributes(path, lines, true)
+	return err
+}
+
+// runTextMerge is the merge driver of the text files. Git passes the base, ours, and theirs, and
+// expects the result in ours. It fails when conflict markers are left.
+func runTextMerge(args []string) error {
+	if len(args) != 5 {
+		return errors.New("usage: text-merge <base> <ours> <theirs> <marker-size> <path>")
+	}
+	base, ours, theirs, path := args[0], args[1], args[2], args[4]
+	markerSize, err := strconv.Atoi(args[3])
+	if err != nil {
+		return fmt.Errorf("%s is not a marker size", args[3])
+	}
+
+	var contents [][]byte
+	for _, file := range []string{base, ours, theirs} {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		contents = append(contents, data)
+	}
+
+	if tooLargeToMerge(contents...) {
+		out, err := runCmd("", "git", "merge-file", "--marker-size="+args[3], "-L", path, "-L", path, "-L", path, ours, base, theirs)
+		if _, conflicted := err.(*exec.ExitError); err != nil && !conflicted {
+			return fmt.Errorf("unable to merge %s. Out: %s, Err: %v", path, out, err)
+		}
+		if err != nil {
+			return fmt.Errorf("%s has conflicts", path)
+		}
+		return nil
+	}
+
+	merged, conflicts := MergeText(contents[0], contents[1], contents[2], markerSize, path)
+	err = ioutil.WriteFile(ours, merged, 0644)
+	if err != nil {
+		return err
+	}
+	if conflicts {
+		return fmt.Errorf("%s has conflicts", path)
+	}
+	return nil
+}
+
+func tooLargeToMerge(contents ...[]byte) bool {
+	base := bytes.Count(contents[0], []byte("\n")) + 1
+	for _, side := range contents[1:] {
+		if base*(bytes.Count(side, []byte("\n"))+1) > maxTextMergeCells {
+			return true
+		}
+	}
+	return false
+}
+
+// splitLinesKeepEnds keeps the line endings, so joining the lines gives back the content.
+func splitLinesKeepEnds(content []byte) []string {
+	if len(content) == 0 {
+		return nil
+	}
+	lines := strings.SplitAfter(string(content), "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// hunk replaces the base lines [start, end) with lines. An insertion has start == end.
+type hunk struct {
+	start int
+	end   int
+	lines []string
+	ours  bool
+}
+
+// diffHunks compares the lines with the longest common subsequence.
+func diffHunks(base []string, other []string, ours bool) []hunk {
+	prefix := 0
+	for prefix < len(base) && prefix < len(other) && base[prefix] == other[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(base)-prefix && suffix < len(other)-prefix && base[len(base)-1-suffix] == other[len(other)-1-suffix] {
+		suffix++
+	}
+	a := base[prefix : len(base)-suffix]
+	b := other[prefix : len(other)-suffix]
+
+	// lcs[i][j] is the length of the longest common subsequence of a[i:] and b[j:].
+	lcs := make([][]int32, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int32, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	var hunks []hunk
+	var current *hunk
+	flush := func() {
+		if current != nil {
+			hunks = append(hunks, *current)
+			current = nil
+		}
+	}
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		if i < len(a) && j < len(b) && a[i] == b[j] {
+			flush()
+			i++
+			j++
+			continue
+		}
+		if current == nil {
+			current = &hunk{start: prefix + i, end: prefix + i, ours: ours}
+		}
+		if j < len(b) && (i == len(a) || lcs[i][j+1] >= lcs[i+1][j]) {
+			current.lines = append(current.lines, b[j])
+			j++
+		} else {
+			i++
+			current.end = prefix + i
+		}
+	}
+	flush()
+	return hunks
+}
+
+// hunksOverlap tells whether two hunks of different sides touch the same base lines. Two insertions at
+// the same place overlap because their order is unknown. An insertion right before or after a changed
+// range doesn't.
+func hunksOverlap(a hunk, b hunk) bool {
+	if a.start == a.end && b.start == b.end {
+		return a.start == b.start
+	}
+	if a.start == a.end {
+		return b.start < a.start && a.start < b.end
+	}
+	if b.start == b.end {
+		return a.start < b.start && b.start < a.end
+	}
+	return a.start < b.end && b.start < a.end
+}
+
+// applyHunks applies the hunks, sorted by start, to the base lines [start, end).
+func applyHunks(base []string, start int, end int, hunks []hunk) []string {
+	var result []string
+	pos := start
+	for _, h := range hunks {
+		result = append(result, base[pos:h.start]...)
+		result = append(result, h.lines...)
+		pos = h.end
+	}
+	return append(result, base[pos:end]...)
+}
+
+func sameLines(a []string, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// MergeText merges ours and theirs line by line. The changes that don't touch the same lines are
+// merged even when they are next to each other. The overlapping changes of list items are merged as
+// a union of the items. The other overlapping changes are left with conflict markers, labeled with
+// label. It returns whether conflict markers are left.
+func MergeText(base []byte, ours []byte, theirs []byte, markerSize int, label string) ([]byte, bool) {
+	baseLines := splitLinesKeepEnds(base)
+	hunks := append(diffHunks(baseLines, splitLinesKeepEnds(ours), true), diffHunks(baseLines, splitLinesKeepEnds(theirs), false)...)
+	sort.SliceStable(hunks, func(i, j int) bool {
+		if hunks[i].start != hunks[j].start {
+			return hunks[i].start < hunks[j].start
+		}
+		// An insertion goes before the change of the lines that follow it.
+		return hunks[i].start == hunks[i].end && hunks[j].start != hunks[j].end
+	})
+
+	// The hunks that overlap, directly or through other hunks, are merged together.
+	var groups [][]hunk
+	for _, h := range hunks {
+		if len(groups) > 0 {
+			last := groups[len(groups)-1]
+			overlapping := false
+			for _, other := range last {
+				if other.ours != h.ours && hunksOverlap(other, h) {
+					overlapping = true
+					break
+				}
+			}
+			if overlapping {
+				groups[len(groups)-1] = append(last, h)
+				continue
+			}
+		}
+		groups = append(groups, []hunk{h})
+	}
+
+	var result []string
+	conflicts := false
+	pos := 0
+	for _, group := range groups {
+		start, end := group[0].start, group[0].end
+		var oursHunks, theirsHunks []hunk
+		for _, h := range group {
+			if h.start < start {
+				start = h.start
+			}
+			if h.end > end {
+				end = h.end
+			}
+			if h.ours {
+				oursHunks = append(oursHunks, h)
+			} else {
+				theirsHunks = append(theirsHunks, h)
+			}
+		}
+		result = append(result, baseLines[pos:start]...)
+		pos = end
+
+		if len(oursHunks) == 0 || len(theirsHunks) == 0 {
+			result = append(result, applyHunks(baseLines, start, end, group)...)
+			continue
+		}
+
+		oursLines := applyHunks(baseLines, start, end, oursHunks)
+		theirsLines := applyHunks(baseLines, start, end, theirsHunks)
+		if sameLines(oursLines, theirsLines) {
+			result = append(result, oursLines...)
+			continue
+		}
+		if items, ok := mergeListItems(baseLines[start:end], oursLines, theirsLines); ok {
+			result = append(result, items...)
+			continue
+		}
+
+		conflicts = true
+		result = append(result, strings.Repeat("<", markerSize)+" "+label+"\n")
+		result = append(result, withFinalNewline(oursLines)...)
+		result = append(result, strings.Repeat("=", markerSize)+"\n")
+		result = append(result, withFinalNewline(theirsLines)...)
+		result = append(result, strings.Repeat(">", markerSize)+" "+label+"\n")
+	}
+	result = append(result, baseLines[pos:]...)
+	return []byte(strings.Join(result, "")), conflicts
+}
+
+func withFinalNewline(lines []string) []string {
+	if len(lines) == 0 || strings.HasSuffix(lines[len(lines)-1], "\n") {
+		return lines
+	}
+	fixed := append([]string{}, lines...)
+	fixed[len(fixed)-1] += "\n"
+	return fixed
+}
+
+var listItem = regexp.MustCompile(`^(\s*)(?:[-*+]|\d+[.)])\s+(?:\[[ xX]\]\s+)?(.*?)\s*$`)
+
+// listItemKey identifies a list item regardless of its marker, its number, and its checkbox, so that
+// checking a checkbox is a change of the item rather than a different item.
+func listItemKey(line string) (string, bool) {
+	matches := listItem.FindStringSubmatch(strings.TrimRight(line, "\r\n"))
+	if matches == nil {
+		return "", false
+	}
+	return matches[1] + "\x00" + matches[2], true
+}
+
+func listItemKeys(lines []string) (map[string]string, []string, bool) {
+	byKey := map[string]string{}
+	var keys []string
+	for _, line := range lines {
+		key, ok := listItemKey(line)
+		if !ok {
+			return nil, nil, false
+		}
+		if _, duplicate := byKey[key]; duplicate {
+			return nil, nil, false
+		}
+		byKey[key] = line
+		keys = append(keys, key)
+	}
+	return byKey, keys, true
+}
+
+// mergeListItems merges the overlapping changes of list items as a union. Both sides' new items are
+// kept, an item deleted by one side is deleted if the other side didn't change it, and an item changed
+// by one side, e.g. a checked checkbox, takes that change. It fails when the lines aren't all list
+// items or when both sides changed the same item differently.
+func mergeListItems(base []string, ours []string, theirs []string) ([]string, bool) {
+	baseByKey, _, ok := listItemKeys(base)
+	if !ok {
+		return nil, false
+	}
+	oursByKey, oursKeys, ok := listItemKeys(ours)
+	if !ok {
+		return nil, false
+	}
+	theirsByKey, theirsKeys, ok := listItemKeys(theirs)
+	if !ok {
+		return nil, false
+	}
+
+	var result []string
+	var resultKeys []string
+	for _, key := range oursKeys {
+		line := oursByKey[key]
+		baseLine, inBase := baseByKey[key]
+		theirsLine, inTheirs := theirsByKey[key]
+		switch {
+		case !inTheirs && inBase:
+			if line != baseLine {
+				return nil, false
+			}
+			continue
+		case inTheirs && line != theirsLine:
+			if inBase && line == baseLine {
+				line = theirsLine
+			} else if !inBase || theirsLine != baseLine {
+				return nil, false
+			}
+		}
+		result = append(result, line)
+		resultKeys = append(resultKeys, key)
+	}
+
+	// Their new items go after the item that precedes them on their side, and after our new items
+	// there.
+	previous := ""
+	for _, key := range theirsKeys {
+		if _, inOurs := oursByKey[key]; inOurs {
+			previous = key
+			continue
+		}
+		if baseLine, inBase := baseByKey[key]; inBase {
+			if theirsByKey[key] != baseLine {
+				return nil, false
+			}
+			continue
+		}
+
+		at := 0
+		for i, k := range resultKeys {
+			if k == previous {
+				at = i + 1
+			}
+		}
+		for at < len(resultKeys) && addedByOurs(resultKeys[at], baseByKey, theirsByKey) {
+			at++
+		}
+		result = append(result[:at], append([]string{theirsByKey[key]}, result[at:]...)...)
+		resultKeys = append(resultKeys[:at], append([]string{key}, resultKeys[at:]...)...)
+		previous = key
+	}
+
+	// The last line of the file might have no line ending, and it might not be last anymore.
+	for i := range result {
+		if !strings.HasSuffix(result[i], "\n") {
+			result[i] += "\n"
+		}
+	}
+	if len(ours) > 0 && len(result) > 0 && !strings.HasSuffix(ours[len(ours)-1], "\n") {
+		result[len(result)-1] = strings.TrimSuffix(result[len(result)-1], "\n")
+	}
+	return result, true
+}
+
+func addedByOurs(key string, baseByKey map[string]string, theirsByKey map[string]string) bool {
+	_, inBase := baseByKey[key]
+	_, inTheirs := theirsByKey[key]
+	return !inBase && !inTheirs
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/tanin47/git-notes/internal/test_helpers"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func mergeText(base string, ours string, theirs string) (string, bool) {
+	merged, conflicts := MergeText([]byte(base), []byte(ours), []byte(theirs), 7, "test.md")
+	return string(merged), conflicts
+}
+
+func TestMergeText_Clean(t *testing.T) {
+	merged, conflicts := mergeText("a\nb\nc\n", "a\nb from ours\nc\n", "a\nb\nc from theirs\n")
+	assert.False(t, conflicts)
+	assert.Equal(t, "a\nb from ours\nc from theirs\n", merged)
+}
+
+func TestMergeText_AdjacentLines(t *testing.T) {
+	// git merge-file gives a conflict because the changed lines are next to each other.
+	base := "# Title\n\nFirst sentence.\nSecond sentence.\nThird sentence.\n"
+	ours := "# Title\n\nFirst sentence, edited.\nSecond sentence.\nThird sentence.\n"
+	theirs := "# Title\n\nFirst sentence.\nSecond sentence, edited.\nThird sentence.\nFourth sentence.\n"
+
+	merged, conflicts := mergeText(base, ours, theirs)
+	assert.False(t, conflicts)
+	assert.Equal(t, "# Title\n\nFirst sentence, edited.\nSecond sentence, edited.\nThird sentence.\nFourth sentence.\n", merged)
+}
+
+func TestMergeText_SameChange(t *testing.T) {
+	merged, conflicts := mergeText("a\nb\n", "a\nc\n", "a\nc\n")
+	assert.False(t, conflicts)
+	assert.Equal(t, "a\nc\n", merged)
+}
+
+func TestMergeText_Conflict(t *testing.T) {
+	merged, conflicts := mergeText("a\nb\nc\n", "a\nb from ours\nc\n", "a\nb from theirs\nc\n")
+	assert.True(t, conflicts)
+	assert.Equal(t, "a\n<<<<<<< test.md\nb from ours\n=======\nb from theirs\n>>>>>>> test.md\nc\n", merged)
+}
+
+func TestMergeText_ConflictWithoutFinalNewline(t *testing.T) {
+	merged, conflicts := mergeText("a\nb", "a\nours", "a\ntheirs")
+	assert.True(t, conflicts)
+	assert.Equal(t, "a\n<<<<<<< test.md\nours\n=======\ntheirs\n>>>>>>> test.md\n", merged)
+}
+
+func TestMergeText_ListAppends(t *testing.T) {
+	merged, conflicts := mergeText("- a\n- b\n", "- a\n- b\n- from ours\n", "- a\n- b\n- from theirs\n")
+	assert.False(t, conflicts)
+	assert.Equal(t, "- a\n- b\n- from ours\n- from theirs\n", merged)
+}
+
+func TestMergeText_ListAppendsWithoutFinalNewline(t *testing.T) {
+	merged, conflicts := mergeText("- a", "- a\n- from ours", "- a\n- from theirs")
+	assert.False(t, conflicts)
+	assert.Equal(t, "- a\n- from ours\n- from theirs", merged)
+}
+
+func TestMergeText_Checkboxes(t *testing.T) {
+	base := "Todo:\n- [ ] milk\n- [ ] eggs\n- [ ] bread\n"
+	ours := "Todo:\n- [x] milk\n- [ ] eggs\n- [ ] bread\n- [ ] butter\n"
+	theirs := "Todo:\n- [ ] milk\n- [x] eggs\n- [ ] jam\n- [ ] bread\n"
+
+	merged, conflicts := mergeText(base, ours, theirs)
+	assert.False(t, conflicts)
+	assert.Equal(t, "Todo:\n- [x] milk\n- [x] eggs\n- [ ] jam\n- [ ] bread\n- [ ] butter\n", merged)
+}
+
+func TestMergeText_ListItemDeletedAndAdded(t *testing.T) {
+	merged, conflicts := mergeText("- a\n- b\n", "- b\n- c\n", "- a\n- b\n- d\n")
+	assert.False(t, conflicts)
+	assert.Equal(t, "- b\n- c\n- d\n", merged)
+}
+
+func TestMergeText_ListItemChangedOnBothSides(t *testing.T) {
+	merged, conflicts := mergeText("- [ ] a\n", "- [x] a\n- b\n", "- [ ] a, edited\n")
+	assert.True(t, conflicts)
+	assert.Equal(t, "<<<<<<< test.md\n- [x] a\n- b\n=======\n- [ ] a, edited\n>>>>>>> test.md\n", merged)
+}
+
+func TestMergeText_Empty(t *testing.T) {
+	merged, conflicts := mergeText("", "ours\n", "")
+	assert.False(t, conflicts)
+	assert.Equal(t, "ours\n", merged)
+}
+
+func TestAddAttributes(t *testing.T) {
+	dir, err := ioutil.TempDir("", "git-notes-attributes")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	test_helpers.WriteFile(t, dir, ".gitattributes", "*.secret.md merge=git-notes-encrypt")
+	added, err := addAttributes(dir, []string{"*.md merge=git-notes-text"}, true)
+	assert.NoError(t, err)
+	assert.True(t, added)
+	added, err = addAttributes(dir, []string{"*.md merge=git-notes-text"}, true)
+	assert.NoError(t, err)
+	assert.False(t, added)
+
+	content, err := ioutil.ReadFile(dir + "/.gitattributes")
+	assert.NoError(t, err)
+	assert.Equal(t, "*.md merge=git-notes-text\n*.secret.md merge=git-notes-encrypt\n", string(content))
+}
+
+func TestGoGit_TextMerge(t *testing.T) {
+	repos := test_helpers.SetupRepos()
+	defer test_helpers.CleanupRepos(repos)
+
+	options := RepoOptions{Merge: MergeOptions{Text: []string{"*.md"}}}
+	test_helpers.WriteFile(t, repos.Local, "test.md", "- [ ] milk\n- [ ] eggs\n\nFirst line.\nSecond line.\n")
+	gogit := GitCmd{options: map[string]RepoOptions{repos.Local: options}}
+	assert.NoError(t, gogit.Sync(repos.Local))
+
+	anotherLocal := test_helpers.SetupGitRepo("another_local", false)
+	defer os.RemoveAll(anotherLocal)
+	test_helpers.SetupRemote(anotherLocal, repos.Remote)
+	test_helpers.PerformCmd(t, anotherLocal, "git", "fetch")
+	test_helpers.PerformCmd(t, anotherLocal, "git", "checkout", "master")
+	gogit.options[anotherLocal] = options
+
+	test_helpers.WriteFile(t, anotherLocal, "test.md", "- [ ] milk\n- [x] eggs\n- [ ] jam\n\nFirst line.\nSecond line, from another.\n")
+	assert.NoError(t, gogit.Sync(anotherLocal))
+
+	test_helpers.WriteFile(t, repos.Local, "test.md", "- [x] milk\n- [ ] eggs\n- [ ] bread\n\nFirst line, from local.\nSecond line.\n")
+	assert.NoError(t, gogit.Sync(repos.Local))
+
+	content, err := ioutil.ReadFile(repos.Local + "/test.md")
+	assert.NoError(t, err)
+	assert.Equal(t, "- [x] milk\n- [x] eggs\n- [ ] jam\n- [ ] bread\n\nFirst line, from local.\nSecond line, from another.\n", string(content))
+	assertState(t, repos.Local, Sync)
+}
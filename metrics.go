@@ -0,0 +1,304 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+var AllStates = []State{Error, Dirty, Ahead, OutOfSync, Sync, Blocked, Paused, NeedsConfirmation, Busy}
+
+var gitDurationBuckets = []float64{0.01, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60}
+
+// GitError remembers which git operation failed so that the failure can be classified.
+type GitError struct {
+	Op  string
+	Err error
+}
+
+func (e *GitError) Error() string {
+	return fmt.Sprintf("git %s failed. Err: %v", e.Op, e.Err)
+}
+
+func (e *GitError) Unwrap() error {
+	return e.Err
+}
+
+var ErrNoProgress = errors.New("state doesn't change. Something is wrong")
+
+func ErrorClass(err error) string {
+	var gitErr *GitError
+	if errors.As(err, &gitErr) {
+		return gitErr.Op
+	}
+	if errors.Is(err, ErrNoProgress) {
+		return "no_progress"
+	}
+	var secretsErr *SecretsFoundError
+	if errors.As(err, &secretsErr) {
+		return "secrets"
+	}
+	var confirmationErr *NeedsConfirmationError
+	if errors.As(err, &confirmationErr) {
+		return "needs_confirmation"
+	}
+	return "other"
+}
+
+type histogram struct {
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+type Metrics struct {
+	mutex        sync.Mutex
+	now          func() time.Time
+	syncAttempts map[string]uint64
+	syncFailures map[[2]string]uint64
+	lastSuccess  map[string]time.Time
+	states       map[string]State
+	commits      map[string]uint64
+	pushes       map[string]uint64
+	merges       map[string]uint64
+	conflicts    map[string]uint64
+	gitDurations map[[2]string]*histogram
+}
+
+func NewMetrics() *Metrics {
+	return &Metrics{
+		now:          time.Now,
+		syncAttempts: map[string]uint64{},
+		syncFailures: map[[2]string]uint64{},
+		lastSuccess:  map[string]time.Time{},
+		states:       map[string]State{},
+		commits:      map[string]uint64{},
+		pushes:       map[string]uint64{},
+		merges:       map[string]uint64{},
+		conflicts:    map[string]uint64{},
+		gitDurations: map[[2]string]*histogram{},
+	}
+}
+
+var metrics = NewMetrics()
+
+func (m *Metrics) RecordSync(repo string, err error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.syncAttempts[repo]++
+	if err != nil {
+		m.syncFailures[[2]string{repo, ErrorClass(err)}]++
+	} else {
+		m.lastSuccess[repo] = m.now()
+	}
+}
+
+// RestoreLastSuccess sets the time of the last successful sync recorded before a restart.
+func (m *Metrics) RestoreLastSuccess(repo string, at time.Time) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if at.After(m.lastSuccess[repo]) {
+		m.lastSuccess[repo] = at
+	}
+}
+
+func (m *Metrics) SetState(repo string, state State) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.states[repo] = state
+}
+
+func (m *Metrics) State(repo string) State {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.states[repo]
+}
+
+func (m *Metrics) IncCommits(repo string) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.commits[repo]++
+}
+
+func (m *Metrics) IncPushes(repo string) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.pushes[repo]++
+}
+
+func (m *Metrics) IncMerges(repo string) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.merges[repo]++
+}
+
+func (m *Metrics) IncConflicts(repo string) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.conflicts[repo]++
+}
+
+func (m *Metrics) ObserveGitDuration(repo string, op string, duration time.Duration) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	key := [2]string{repo, op}
+	h, ok := m.gitDurations[key]
+	if !ok {
+		h = &histogram{counts: make([]uint64, len(gitDurationBuckets))}
+		m.gitDurations[key] = h
+	}
+
+	seconds := duration.Seconds()
+	for i, bound := range gitDurationBuckets {
+		if seconds <= bound {
+			h.counts[i]++
+		}
+	}
+	h.count++
+	h.sum += seconds
+}
+
+// timeGitOp runs one git operation, records its duration, and tags its error with the operation name.
+func timeGitOp(path string, op string, fn func() error) error {
+	start := time.Now()
+	err := fn()
+	metrics.ObserveGitDuration(path, op, time.Since(start))
+
+	if err != nil {
+		return &GitError{Op: op, Err: err}
+	}
+	return nil
+}
+
+func escapeLabel(value string) string {
+	value = strings.ReplaceAll(value, `\`, `\\`)
+	value = strings.ReplaceAll(value, "\n", `\n`)
+	return strings.ReplaceAll(value, `"`, `\"`)
+}
+
+func sortedKeys(m map[string]uint64) []string {
+	var keys []string
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func writeCounter(w io.Writer, name string, help string, values map[string]uint64) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", name, help, name)
+	for _, repo := range sortedKeys(values) {
+		fmt.Fprintf(w, "%s{repo=\"%s\"} %d\n", name, escapeLabel(repo), values[repo])
+	}
+}
+
+// Write writes all metrics in the Prometheus text exposition format.
+func (m *Metrics) Write(w io.Writer) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	writeCounter(w, "git_notes_sync_attempts_total", "Number of sync attempts.", m.syncAttempts)
+
+	fmt.Fprintf(w, "# HELP git_notes_sync_failures_total Number of failed syncs by error class.\n# TYPE git_notes_sync_failures_total counter\n")
+	var failureKeys [][2]string
+	for k := range m.syncFailures {
+		failureKeys = append(failureKeys, k)
+	}
+	sort.Slice(failureKeys, func(i, j int) bool {
+		if failureKeys[i][0] != failureKeys[j][0] {
+			return failureKeys[i][0] < failureKeys[j][0]
+		}
+		return failureKeys[i][1] < failureKeys[j][1]
+	})
+	for _, k := range failureKeys {
+		fmt.Fprintf(w, "git_notes_sync_failures_total{repo=\"%s\",class=\"%s\"} %d\n", escapeLabel(k[0]), escapeLabel(k[1]), m.syncFailures[k])
+	}
+
+	fmt.Fprintf(w, "# HELP git_notes_seconds_since_last_successful_sync Seconds since the last successful sync.\n# TYPE git_notes_seconds_since_last_successful_sync gauge\n")
+	var successRepos []string
+	for repo := range m.lastSuccess {
+		successRepos = append(successRepos, repo)
+	}
+	sort.Strings(successRepos)
+	for _, repo := range successRepos {
+		fmt.Fprintf(w, "git_notes_seconds_since_last_successful_sync{repo=\"%s\"} %g\n", escapeLabel(repo), m.now().Sub(m.lastSuccess[repo]).Seconds())
+	}
+
+	fmt.Fprintf(w, "# HELP git_notes_state Current state of the repo. 1 for the current state, 0 otherwise.\n# TYPE git_notes_state gauge\n")
+	var stateRepos []string
+	for repo := range m.states {
+		stateRepos = append(stateRepos, repo)
+	}
+	sort.Strings(stateRepos)
+	for _, repo := range stateRepos {
+		for _, state := range AllStates {
+			value := 0
+			if m.states[repo] == state {
+				value = 1
+			}
+			fmt.Fprintf(w, "git_notes_state{repo=\"%s\",state=\"%s\"} %d\n", escapeLabel(repo), state, value)
+		}
+	}
+
+	writeCounter(w, "git_notes_commits_total", "Number of commits created.", m.commits)
+	writeCounter(w, "git_notes_pushes_total", "Number of pushes.", m.pushes)
+	writeCounter(w, "git_notes_merges_total", "Number of merges.", m.merges)
+	writeCounter(w, "git_notes_conflicts_total", "Number of merges that left conflicts.", m.conflicts)
+
+	fmt.Fprintf(w, "# HELP git_notes_git_command_duration_seconds Duration of git subprocesses.\n# TYPE git_notes_git_command_duration_seconds histogram\n")
+	var durationKeys [][2]string
+	for k := range m.gitDurations {
+		durationKeys = append(durationKeys, k)
+	}
+	sort.Slice(durationKeys, func(i, j int) bool {
+		if durationKeys[i][0] != durationKeys[j][0] {
+			return durationKeys[i][0] < durationKeys[j][0]
+		}
+		return durationKeys[i][1] < durationKeys[j][1]
+	})
+	for _, k := range durationKeys {
+		h := m.gitDurations[k]
+		labels := fmt.Sprintf("repo=\"%s\",operation=\"%s\"", escapeLabel(k[0]), escapeLabel(k[1]))
+		for i, bound := range gitDurationBuckets {
+			fmt.Fprintf(w, "git_notes_git_command_duration_seconds_bucket{%s,le=\"%g\"} %d\n", labels, bound, h.counts[i])
+		}
+		fmt.Fprintf(w, "git_notes_git_command_duration_seconds_bucket{%s,le=\"+Inf\"} %d\n", labels, h.count)
+		fmt.Fprintf(w, "git_notes_git_command_duration_seconds_sum{%s} %g\n", labels, h.sum)
+		fmt.Fprintf(w, "git_notes_git_command_duration_seconds_count{%s} %d\n", labels, h.count)
+	}
+}
+
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	m.Write(w)
+}
+
+// metricsMux serves the metrics, and the sync requests of `git-notes tui` if the requester isn't nil.
+func metricsMux(requester SyncRequester) *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics)
+	if requester != nil {
+		mux.Handle("/sync", syncHandler(requester))
+	}
+	return mux
+}
+
+func ServeMetrics(address string, requester SyncRequester) {
+	mux := metricsMux(requester)
+
+	go func() {
+		log.Printf("Serving metrics on %s/metrics", address)
+		err := http.ListenAndServe(address, mux)
+		if err != nil {
+			log.Printf("Metrics endpoint stopped. Err: %v", err)
+		}
+	}()
+}
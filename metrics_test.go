@@ -0,0 +1,81 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/tanin47/git-notes/internal/test_helpers"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestErrorClass(t *testing.T) {
+	assert.Equal(t, "push", ErrorClass(fmt.Errorf("performing Update() failed. Err: %w", &GitError{Op: "push", Err: errors.New("boom")})))
+	assert.Equal(t, "no_progress", ErrorClass(ErrNoProgress))
+	assert.Equal(t, "secrets", ErrorClass(&SecretsFoundError{}))
+	assert.Equal(t, "other", ErrorClass(errors.New("boom")))
+}
+
+func TestMetrics_WriteTo(t *testing.T) {
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	m := NewMetrics()
+	m.now = func() time.Time { return now }
+
+	m.RecordSync("/notes", nil)
+	m.RecordSync("/notes", &GitError{Op: "fetch", Err: errors.New("offline")})
+	m.SetState("/notes", Ahead)
+	m.IncCommits("/notes")
+	m.IncPushes("/notes")
+	m.IncMerges("/notes")
+	m.IncConflicts("/notes")
+	m.ObserveGitDuration("/notes", "push", 300*time.Millisecond)
+	now = now.Add(90 * time.Second)
+
+	var buffer bytes.Buffer
+	m.Write(&buffer)
+	out := buffer.String()
+
+	assert.Contains(t, out, `git_notes_sync_attempts_total{repo="/notes"} 2`)
+	assert.Contains(t, out, `git_notes_sync_failures_total{repo="/notes",class="fetch"} 1`)
+	assert.Contains(t, out, `git_notes_seconds_since_last_successful_sync{repo="/notes"} 90`)
+	assert.Contains(t, out, `git_notes_state{repo="/notes",state="ahead"} 1`)
+	assert.Contains(t, out, `git_notes_state{repo="/notes",state="sync"} 0`)
+	assert.Contains(t, out, `git_notes_commits_total{repo="/notes"} 1`)
+	assert.Contains(t, out, `git_notes_pushes_total{repo="/notes"} 1`)
+	assert.Contains(t, out, `git_notes_merges_total{repo="/notes"} 1`)
+	assert.Contains(t, out, `git_notes_conflicts_total{repo="/notes"} 1`)
+	assert.Contains(t, out, `git_notes_git_command_duration_seconds_bucket{repo="/notes",operation="push",le="0.25"} 0`)
+	assert.Contains(t, out, `git_notes_git_command_duration_seconds_bucket{repo="/notes",operation="push",le="0.5"} 1`)
+	assert.Contains(t, out, `git_notes_git_command_duration_seconds_count{repo="/notes",operation="push"} 1`)
+}
+
+func TestMetrics_ServeHTTP(t *testing.T) {
+	m := NewMetrics()
+	m.RecordSync("some-path", nil)
+
+	recorder := httptest.NewRecorder()
+	m.ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+
+	assert.Equal(t, 200, recorder.Code)
+	assert.Contains(t, recorder.Body.String(), `git_notes_sync_attempts_total{repo="some-path"} 1`)
+}
+
+func TestMetrics_RecordedAroundSync(t *testing.T) {
+	repos := test_helpers.SetupRepos()
+	defer test_helpers.CleanupRepos(repos)
+
+	oldMetrics := metrics
+	metrics = NewMetrics()
+	defer func() { metrics = oldMetrics }()
+
+	test_helpers.WriteFile(t, repos.Local, "test.md", "TestContent")
+	performSync(t, repos.Local)
+
+	assert.Equal(t, uint64(1), metrics.syncAttempts[repos.Local])
+	assert.Equal(t, uint64(1), metrics.commits[repos.Local])
+	assert.Equal(t, uint64(1), metrics.pushes[repos.Local])
+	assert.Equal(t, Sync, metrics.states[repos.Local])
+	assert.Equal(t, uint64(1), metrics.gitDurations[[2]string{repos.Local, "push"}].count)
+}
@@ -0,0 +1,356 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	ConflictEvent          EventKind = "conflict"
+	FailureEvent           EventKind = "failure"
+	ResolvedEvent          EventKind = "resolved"
+	BlockedEvent           EventKind = "blocked"
+	NeedsConfirmationEvent EventKind = "needs-confirmation"
+)
+
+type EventKind string
+
+const (
+	// notificationQueueSize is how many events wait for the sinks before new ones are dropped.
+	notificationQueueSize = 64
+	defaultNotifyTimeout  = 30 * time.Second
+)
+
+type Event struct {
+	Kind    EventKind `json:"kind"`
+	Repo    string    `json:"repo"`
+	Message string    `json:"message"`
+	Files   []string  `json:"files,omitempty"`
+	Time    time.Time `json:"time"`
+}
+
+func (e Event) Summary() string {
+	switch e.Kind {
+	case ConflictEvent:
+		return fmt.Sprintf("Git Notes: conflict in %s", e.Repo)
+	case FailureEvent:
+		return fmt.Sprintf("Git Notes: %s is failing to sync", e.Repo)
+	case ResolvedEvent:
+		return fmt.Sprintf("Git Notes: %s is syncing again", e.Repo)
+	case BlockedEvent:
+		return fmt.Sprintf("Git Notes: possible secrets in %s", e.Repo)
+	case NeedsConfirmationEvent:
+		return fmt.Sprintf("Git Notes: confirm the deletions in %s", e.Repo)
+	}
+	return fmt.Sprintf("Git Notes: %s", e.Repo)
+}
+
+type Sink interface {
+	Notify(event Event) error
+}
+
+// DesktopSink shows a freedesktop notification through D-Bus.
+type DesktopSink struct{}
+
+func (d *DesktopSink) Notify(event Event) error {
+	out, err := runCmd("", "gdbus", "call", "--session",
+		"--dest", "org.freedesktop.Notifications",
+		"--object-path", "/org/freedesktop/Notifications",
+		"--method", "org.freedesktop.Notifications.Notify",
+		"git-notes", "0", "", event.Summary(), event.Message, "[]", "{}", "-1")
+	if err != nil {
+		return fmt.Errorf("unable to send the desktop notification. Out: %s, Err: %v", out, err)
+	}
+	return nil
+}
+
+// WebhookSink POSTs the event as JSON.
+type WebhookSink struct {
+	URL    string
+	client *http.Client
+}
+
+func (w *WebhookSink) Notify(event Event) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	client := w.client
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+
+	resp, err := client.Post(w.URL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("unable to call the webhook. Err: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("the webhook responded with %s", resp.Status)
+	}
+	return nil
+}
+
+// CommandSink runs a command with the event in its environment and as JSON on stdin.
+type CommandSink struct {
+	Command []string
+}
+
+func (c *CommandSink) Notify(event Event) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	cmd := exec.Command(c.Command[0], c.Command[1:]...)
+	cmd.Env = append(os.Environ(),
+		"GIT_NOTES_EVENT="+string(event.Kind),
+		"GIT_NOTES_REPO="+event.Repo,
+		"GIT_NOTES_MESSAGE="+event.Message,
+		"GIT_NOTES_FILES="+strings.Join(event.Files, "\n"),
+	)
+	cmd.Stdin = bytes.NewReader(body)
+
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("the notification command failed. Out: %s, Err: %v", out, err)
+	}
+	return nil
+}
+
+// Notifier turns what the sync engine observes into events and delivers them to the sinks.
+// The same kind of event for the same repo is sent at most once per rateLimit. The events are
+// delivered in the background, so that a hung webhook or D-Bus call doesn't stall the syncs.
+type Notifier struct {
+	sinks            []Sink
+	failureThreshold time.Duration
+	rateLimit        time.Duration
+	timeout          time.Duration
+	now              func() time.Time
+	queue            chan Event
+	pending          sync.WaitGroup
+	started          sync.Once
+
+	mutex        sync.Mutex
+	failingSince map[string]time.Time
+	alerted      map[string]bool
+	lastSent     map[string]time.Time
+}
+
+func NewNotifier(sinks []Sink, failureThreshold time.Duration, rateLimit time.Duration) *Notifier {
+	return &Notifier{
+		sinks:            sinks,
+		failureThreshold: failureThreshold,
+		rateLimit:        rateLimit,
+		timeout:          defaultNotifyTimeout,
+		now:              time.Now,
+		queue:            make(chan Event, notificationQueueSize),
+		failingSince:     map[string]time.Time{},
+		alerted:          map[string]bool{},
+		lastSent:         map[string]time.Time{},
+	}
+}
+
+var notifier = NewNotifier(nil, time.Hour, 15*time.Minute)
+
+func NewNotifierFromConfig(config NotificationConfig) (*Notifier, error) {
+	failureThreshold := time.Hour
+	rateLimit := 15 * time.Minute
+	var err error
+
+	if config.FailureThreshold != "" {
+		failureThreshold, err = time.ParseDuration(config.FailureThreshold)
+		if err != nil {
+			return nil, fmt.Errorf("invalid failure_threshold. Err: %v", err)
+		}
+	}
+	if config.RateLimit != "" {
+		rateLimit, err = time.ParseDuration(config.RateLimit)
+		if err != nil {
+			return nil, fmt.Errorf("invalid rate_limit. Err: %v", err)
+		}
+	}
+
+	var sinks []Sink
+	if config.Desktop {
+		sinks = append(sinks, &DesktopSink{})
+	}
+	if config.Webhook != "" {
+		sinks = append(sinks, &WebhookSink{URL: config.Webhook})
+	}
+	if len(config.Command) > 0 {
+		sinks = append(sinks, &CommandSink{Command: config.Command})
+	}
+
+	return NewNotifier(sinks, failureThreshold, rateLimit), nil
+}
+
+func (n *Notifier) Conflicted(repo string, files []string) {
+	n.send(Event{
+		Kind:    ConflictEvent,
+		Repo:    repo,
+		Message: fmt.Sprintf("The merge left conflict markers in: %s", strings.Join(files, ", ")),
+		Files:   files,
+	})
+}
+
+// Blocked is sent when the commit is held back because of possible secrets.
+func (n *Notifier) Blocked(repo string, findings []SecretFinding) {
+	var files []string
+	var descriptions []string
+	seen := map[string]bool{}
+	for _, finding := range findings {
+		descriptions = append(descriptions, finding.String())
+		if !seen[finding.File] {
+			seen[finding.File] = true
+			files = append(files, finding.File)
+		}
+	}
+
+	n.send(Event{
+		Kind:    BlockedEvent,
+		Repo:    repo,
+		Message: fmt.Sprintf("The commit is held back until these are removed or allowlisted: %s", strings.Join(descriptions, ", ")),
+		Files:   files,
+	})
+}
+
+// NeedsConfirmation is sent when the commit waits for the confirmation of a mass deletion.
+func (n *Notifier) NeedsConfirmation(repo string, deletion PendingDeletion) {
+	n.send(Event{
+		Kind:    NeedsConfirmationEvent,
+		Repo:    repo,
+		Message: fmt.Sprintf("The change deletes %d of %d files. Run `git-notes confirm %s` to commit it or `git-notes reject %s` to restore the files.", len(deletion.Files), deletion.Tracked, repo, repo),
+		Files:   deletion.Files,
+	})
+}
+
+func (n *Notifier) SyncFailed(repo string, err error) {
+	n.mutex.Lock()
+	since, ok := n.failingSince[repo]
+	if !ok {
+		since = n.now()
+		n.failingSince[repo] = since
+	}
+	persistent := n.now().Sub(since) >= n.failureThreshold
+	if persistent {
+		n.alerted[repo] = true
+	}
+	n.mutex.Unlock()
+
+	if persistent {
+		n.send(Event{
+			Kind:    FailureEvent,
+			Repo:    repo,
+			Message: fmt.Sprintf("Syncing has failed since %s. Last error: %v", since.Format(time.RFC3339), err),
+		})
+	}
+}
+
+// RestoreFailingSince continues a failure streak recorded before a restart.
+func (n *Notifier) RestoreFailingSince(repo string, since time.Time) {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	if existing, ok := n.failingSince[repo]; !ok || since.Before(existing) {
+		n.failingSince[repo] = since
+	}
+}
+
+func (n *Notifier) SyncSucceeded(repo string) {
+	n.mutex.Lock()
+	delete(n.failingSince, repo)
+	alerted := n.alerted[repo]
+	delete(n.alerted, repo)
+	delete(n.lastSent, string(FailureEvent)+":"+repo)
+	delete(n.lastSent, string(BlockedEvent)+":"+repo)
+	delete(n.lastSent, string(NeedsConfirmationEvent)+":"+repo)
+	n.mutex.Unlock()
+
+	if alerted {
+		n.send(Event{
+			Kind:    ResolvedEvent,
+			Repo:    repo,
+			Message: "Syncing has recovered.",
+		})
+	}
+}
+
+// ConflictsResolved is sent when the conflict markers that a merge left are gone.
+func (n *Notifier) ConflictsResolved(repo string, files []string) {
+	n.send(Event{
+		Kind:    ResolvedEvent,
+		Repo:    repo,
+		Message: fmt.Sprintf("The conflicts are resolved in: %s", strings.Join(files, ", ")),
+		Files:   files,
+	})
+}
+
+func (n *Notifier) send(event Event) {
+	if len(n.sinks) == 0 {
+		return
+	}
+
+	n.mutex.Lock()
+	event.Time = n.now()
+	key := string(event.Kind) + ":" + event.Repo
+	last, ok := n.lastSent[key]
+	if ok && event.Time.Sub(last) < n.rateLimit {
+		n.mutex.Unlock()
+		log.Printf("Skipped the %s notification for %s because of rate limiting", event.Kind, event.Repo)
+		return
+	}
+	n.lastSent[key] = event.Time
+	n.mutex.Unlock()
+
+	// The delivery starts with the first event, so that a notifier without events, e.g. the default one
+	// replaced by the config, doesn't leave a goroutine behind.
+	n.started.Do(func() { go n.deliver() })
+	n.pending.Add(1)
+	select {
+	case n.queue <- event:
+	default:
+		n.pending.Done()
+		log.Printf("Dropped the %s notification for %s because too many are waiting", event.Kind, event.Repo)
+	}
+}
+
+func (n *Notifier) deliver() {
+	for event := range n.queue {
+		for _, sink := range n.sinks {
+			n.notify(sink, event)
+		}
+		n.pending.Done()
+	}
+}
+
+// notify gives up waiting for the sink after the timeout. The sink may still finish later.
+func (n *Notifier) notify(sink Sink, event Event) {
+	done := make(chan error, 1)
+	go func() {
+		done <- sink.Notify(event)
+	}()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			log.Printf("Unable to send the %s notification. Err: %v", event.Kind, err)
+		}
+	case <-time.After(n.timeout):
+		log.Printf("Gave up sending the %s notification after %s", event.Kind, n.timeout)
+	}
+}
+
+// Flush waits until the queued events have been delivered, e.g. before exiting.
+func (n *Notifier) Flush() {
+	n.pending.Wait()
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/tanin47/git-notes/internal/test_helpers"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+)
+
+type recordingSink struct {
+	events []Event
+}
+
+func (r *recordingSink) Notify(event Event) error {
+	r.events = append(r.events, event)
+	return nil
+}
+
+func setupNotifier() (*Notifier, *recordingSink, *time.Time) {
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	sink := &recordingSink{}
+	n := NewNotifier([]Sink{sink}, time.Hour, 10*time.Minute)
+	n.now = func() time.Time { return now }
+	return n, sink, &now
+}
+
+func TestNotifier_PersistentFailureAndResolved(t *testing.T) {
+	n, sink, now := setupNotifier()
+
+	n.SyncFailed("some-path", errors.New("offline"))
+	n.Flush()
+	assert.Equal(t, 0, len(sink.events))
+
+	*now = now.Add(59 * time.Minute)
+	n.SyncFailed("some-path", errors.New("offline"))
+	n.Flush()
+	assert.Equal(t, 0, len(sink.events))
+
+	*now = now.Add(time.Minute)
+	n.SyncFailed("some-path", errors.New("offline"))
+	n.Flush()
+	assert.Equal(t, 1, len(sink.events))
+	assert.Equal(t, FailureEvent, sink.events[0].Kind)
+	assert.Equal(t, "some-path", sink.events[0].Repo)
+
+	n.SyncSucceeded("some-path")
+	n.Flush()
+	assert.Equal(t, 2, len(sink.events))
+	assert.Equal(t, ResolvedEvent, sink.events[1].Kind)
+
+	n.SyncSucceeded("some-path")
+	n.Flush()
+	assert.Equal(t, 2, len(sink.events))
+}
+
+func TestNotifier_RateLimit(t *testing.T) {
+	n, sink, now := setupNotifier()
+
+	n.Conflicted("some-path", []string{"test.md"})
+	n.Conflicted("some-path", []string{"test.md"})
+	n.Conflicted("another-path", []string{"test.md"})
+	n.Flush()
+	assert.Equal(t, 2, len(sink.events))
+
+	*now = now.Add(10 * time.Minute)
+	n.Conflicted("some-path", []string{"test.md"})
+	n.Flush()
+	assert.Equal(t, 3, len(sink.events))
+	assert.Equal(t, []string{"test.md"}, sink.events[2].Files)
+}
+
+func TestWebhookSink_Notify(t *testing.T) {
+	var received Event
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&received))
+	}))
+	defer server.Close()
+
+	sink := WebhookSink{URL: server.URL}
+	assert.NoError(t, sink.Notify(Event{Kind: ConflictEvent, Repo: "some-path", Files: []string{"test.md"}}))
+
+	assert.Equal(t, ConflictEvent, received.Kind)
+	assert.Equal(t, "some-path", received.Repo)
+	assert.Equal(t, []string{"test.md"}, received.Files)
+}
+
+func TestCommandSink_Notify(t *testing.T) {
+	dir, err := ioutil.TempDir("", "git-notes-command-sink")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	output := fmt.Sprintf("%s/out", dir)
+	sink := CommandSink{Command: []string{"sh", "-c", `echo "$GIT_NOTES_EVENT $GIT_NOTES_REPO" > "$0"`, output}}
+	assert.NoError(t, sink.Notify(Event{Kind: FailureEvent, Repo: "some-path"}))
+
+	content, err := ioutil.ReadFile(output)
+	assert.NoError(t, err)
+	assert.Equal(t, "failure some-path\n", string(content))
+}
+
+func TestNewNotifierFromConfig(t *testing.T) {
+	n, err := NewNotifierFromConfig(NotificationConfig{
+		Desktop:          true,
+		Webhook:          "http://localhost/hook",
+		Command:          []string{"true"},
+		FailureThreshold: "30m",
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 3, len(n.sinks))
+	assert.Equal(t, 30*time.Minute, n.failureThreshold)
+	assert.Equal(t, 15*time.Minute, n.rateLimit)
+
+	_, err = NewNotifierFromConfig(NotificationConfig{RateLimit: "soon"})
+	assert.Error(t, err)
+}
+
+func TestNotifier_ConflictFromSync(t *testing.T) {
+	repos := test_helpers.SetupRepos()
+	defer test_helpers.CleanupRepos(repos)
+
+	oldNotifier := notifier
+	sink := &recordingSink{}
+	notifier = NewNotifier([]Sink{sink}, time.Hour, time.Minute)
+	defer func() { notifier = oldNotifier }()
+
+	test_helpers.WriteFile(t, repos.Local, "test.md", "TestContent")
+	test_helpers.PerformCmd(t, repos.Local, "git", "add", "--all")
+	test_helpers.PerformCmd(t, repos.Local, "git", "commit", "-m", "Test local")
+	test_helpers.PerformCmd(t, repos.Local, "git", "push", "origin", "master", "-u")
+
+	makeConflict(t, repos.Remote)
+
+	test_helpers.WriteFile(t, repos.Local, "test.md", "TestContent2")
+	test_helpers.PerformCmd(t, repos.Local, "git", "add", "--all")
+	test_helpers.PerformCmd(t, repos.Local, "git", "commit", "-m", "Test cause conflict")
+
+	performSync(t, repos.Local)
+	notifier.Flush()
+
+	assert.Equal(t, 1, len(sink.events))
+	assert.Equal(t, ConflictEvent, sink.events[0].Kind)
+	assert.Equal(t, []string{"test.md"}, sink.events[0].Files)
+
+	// Removing the markers by hand resolves the conflict.
+	test_helpers.WriteFile(t, repos.Local, "test.md", "Resolved")
+	performSync(t, repos.Local)
+	notifier.Flush()
+	assert.Equal(t, 2, len(sink.events))
+	assert.Equal(t, ResolvedEvent, sink.events[1].Kind)
+	assert.Equal(t, []string{"test.md"}, sink.events[1].Files)
+}
+
+func TestNotifier_StartsDeliveryLazily(t *testing.T) {
+	n := NewNotifier(nil, time.Hour, time.Minute)
+	n.Conflicted("some-path", []string{"test.md"})
+	n.Flush()
+
+	started := true
+	n.started.Do(func() { started = false })
+	assert.False(t, started)
+}
+
+type hangingSink struct {
+	release chan struct{}
+}
+
+func (h *hangingSink) Notify(event Event) error {
+	<-h.release
+	return nil
+}
+
+func TestNotifier_HangingSink(t *testing.T) {
+	hanging := &hangingSink{release: make(chan struct{})}
+	defer close(hanging.release)
+	sink := &recordingSink{}
+	n := NewNotifier([]Sink{hanging, sink}, time.Hour, time.Minute)
+	n.timeout = 10 * time.Millisecond
+
+	// Sending doesn't wait for the sinks.
+	start := time.Now()
+	n.Conflicted("some-path", []string{"test.md"})
+	n.Conflicted("another-path", []string{"test.md"})
+	assert.Less(t, int64(time.Since(start)), int64(10*time.Millisecond))
+
+	n.Flush()
+	assert.Equal(t, 2, len(sink.events))
+}
+
+func TestNotifier_Blocked(t *testing.T) {
+	n, sink, _ := setupNotifier()
+
+	findings := []SecretFinding{
+		{File: "keys.md", Line: 1, Rule: "aws-access-key", Match: "AKIA****************"},
+		{File: "keys.md", Line: 2, Rule: "private-key", Match: "----*************************"},
+	}
+	n.Blocked("some-path", findings)
+	n.Blocked("some-path", findings)
+	n.Flush()
+	assert.Equal(t, 1, len(sink.events))
+	assert.Equal(t, BlockedEvent, sink.events[0].Kind)
+	assert.Equal(t, []string{"keys.md"}, sink.events[0].Files)
+	assert.Equal(t, "The commit is held back until these are removed or allowlisted: keys.md:1: aws-access-key (AKIA****************), keys.md:2: private-key (----*************************)", sink.events[0].Message)
+
+	n.SyncSucceeded("some-path")
+	n.Blocked("some-path", findings)
+	n.Flush()
+	assert.Equal(t, 2, len(sink.events))
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// The ways to deal with a merge that git-notes started but didn't finish, e.g. because it was killed.
+const (
+	InterruptedMergeContinue = "continue"
+	InterruptedMergeAbort    = "abort"
+)
+
+var ErrBusy = errors.New("a git operation is in progress")
+
+// StartedOperation is a git operation that git-notes started itself. Head is what the operation
+// brings in, e.g. the commit in MERGE_HEAD, so that a later operation of a human doesn't look like ours.
+type StartedOperation struct {
+	Operation string    `json:"operation"`
+	Head      string    `json:"head"`
+	StartedAt time.Time `json:"started_at"`
+}
+
+// OperationStore keeps the operations that git-notes started in a JSON file in the state directory.
+// An operation is remembered before it starts, so it's still recognized after a crash.
+type OperationStore struct {
+	file *JSONStore
+}
+
+func NewOperationStore(dir string) *OperationStore {
+	return &OperationStore{file: NewJSONStore(filepath.Join(dir, "operations.json"))}
+}
+
+func DefaultOperationStore() (*OperationStore, error) {
+	dir, err := StateDir()
+	if err != nil {
+		return nil, err
+	}
+	return NewOperationStore(dir), nil
+}
+
+func (s *OperationStore) Load() (map[string]StartedOperation, error) {
+	operations := map[string]StartedOperation{}
+	err := s.file.Load(&operations)
+	if err != nil {
+		return nil, err
+	}
+	return operations, nil
+}
+
+// update writes the file only if fn reports a change.
+func (s *OperationStore) update(fn func(operations map[string]StartedOperation) bool) error {
+	operations := map[string]StartedOperation{}
+	return s.file.Update(&operations, func() (bool, error) {
+		return fn(operations), nil
+	})
+}
+
+func (s *OperationStore) Start(repo string, operation StartedOperation) error {
+	return s.update(func(operations map[string]StartedOperation) bool {
+		operations[repo] = operation
+		return true
+	})
+}
+
+func (s *OperationStore) Finish(repo string) error {
+	return s.update(func(operations map[string]StartedOperation) bool {
+		if _, ok := operations[repo]; !ok {
+			return false
+		}
+		delete(operations, repo)
+		return true
+	})
+}
+
+// StartedByGitNotes reports whether the operations in progress are exactly the merge that git-notes
+// started. It only reads the store. The sync forgets the started operation once it's finished.
+func StartedByGitNotes(store *OperationStore, repo string, status RepoStatus) bool {
+	if store == nil || len(status.Operations) == 0 {
+		return false
+	}
+
+	operations, err := store.Load()
+	if err != nil {
+		log.Printf("Unable to read the operations of %s. Err: %v", repo, err)
+		return false
+	}
+	started, ok := operations[repo]
+	if !ok || started.Operation != OperationMerge || len(status.Operations) != 1 || status.Operations[0] != OperationMerge {
+		return false
+	}
+
+	head, err := ioutil.ReadFile(filepath.Join(status.GitDir, "MERGE_HEAD"))
+	if err != nil {
+		return false
+	}
+	return strings.TrimSpace(string(head)) == started.Head
+}
+
+// AbortMerge gives up the merge in progress and restores the work tree to before the merge.
+func AbortMerge(path string) error {
+	return timeGitOp(path, "merge", func() error {
+		out, err := runCmd(path, "git", "merge", "--abort")
+		if err != nil {
+			return fmt.Errorf("unable to abort the merge. Out: %s, Err: %w", out, err)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/tanin47/git-notes/internal/test_helpers"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+func TestOperationStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "git-notes-operations")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	store := NewOperationStore(dir)
+
+	assert.NoError(t, store.Start("/notes", StartedOperation{Operation: OperationMerge, Head: "abc"}))
+	operations, err := store.Load()
+	assert.NoError(t, err)
+	assert.Equal(t, "abc", operations["/notes"].Head)
+
+	assert.NoError(t, store.Finish("/notes"))
+	assert.NoError(t, store.Finish("/notes"))
+	operations, err = store.Load()
+	assert.NoError(t, err)
+	assert.Empty(t, operations)
+}
+
+// setupDiverged leaves the local repo with a commit that conflicts with the remote.
+func setupDiverged(t *testing.T, repos test_helpers.Repos) {
+	test_helpers.WriteFile(t, repos.Local, "test.md", "TestContent")
+	performSync(t, repos.Local)
+	makeConflict(t, repos.Remote)
+	test_helpers.WriteFile(t, repos.Local, "test.md", "Local")
+	test_helpers.PerformCmd(t, repos.Local, "git", "commit", "-am", "Local")
+	test_helpers.PerformCmd(t, repos.Local, "git", "fetch")
+}
+
+func TestSync_LeavesHumanMergeAlone(t *testing.T) {
+	repos := test_helpers.SetupRepos()
+	defer test_helpers.CleanupRepos(repos)
+	setupDiverged(t, repos)
+
+	cmd := exec.Command("git", "merge", "origin/master")
+	cmd.Dir = repos.Local
+	assert.Error(t, cmd.Run())
+	before := headCommit(repos.Local)
+
+	assertState(t, repos.Local, Busy)
+	performSync(t, repos.Local)
+	assert.Equal(t, before, headCommit(repos.Local))
+	status, err := GetStatus(repos.Local, RepoOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{OperationMerge}, status.Operations)
+
+	test_helpers.PerformCmd(t, repos.Local, "git", "merge", "--abort")
+	performSync(t, repos.Local)
+	assertState(t, repos.Local, Sync)
+}
+
+func TestSync_LeavesHumanRebaseAlone(t *testing.T) {
+	repos := test_helpers.SetupRepos()
+	defer test_helpers.CleanupRepos(repos)
+	setupDiverged(t, repos)
+
+	cmd := exec.Command("git", "rebase", "origin/master")
+	cmd.Dir = repos.Local
+	assert.Error(t, cmd.Run())
+
+	assertState(t, repos.Local, Busy)
+	performSync(t, repos.Local)
+	status, err := GetStatus(repos.Local, RepoOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{OperationRebase}, status.Operations)
+}
+
+func TestSync_ContinuesInterruptedMerge(t *testing.T) {
+	repos := test_helpers.SetupRepos()
+	defer test_helpers.CleanupRepos(repos)
+	setupDiverged(t, repos)
+
+	gogit := GitCmd{}
+	assert.NoError(t, gogit.startMerge(repos.Local))
+	assertState(t, repos.Local, Dirty)
+
+	assert.NoError(t, gogit.recoverMerge(repos.Local, RepoOptions{}))
+	status, err := GetStatus(repos.Local, RepoOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{OperationMerge}, status.Operations)
+
+	performSync(t, repos.Local)
+	assertState(t, repos.Local, Sync)
+	operations, err := gogit.operationStore().Load()
+	assert.NoError(t, err)
+	assert.NotContains(t, operations, repos.Local)
+}
+
+func TestSync_AbortsInterruptedMerge(t *testing.T) {
+	repos := test_helpers.SetupRepos()
+	defer test_helpers.CleanupRepos(repos)
+	setupDiverged(t, repos)
+
+	gogit := GitCmd{}
+	assert.NoError(t, gogit.startMerge(repos.Local))
+
+	options := RepoOptions{InterruptedMerge: InterruptedMergeAbort}
+	assert.NoError(t, gogit.recoverMerge(repos.Local, options))
+	status, err := GetStatus(repos.Local, options)
+	assert.NoError(t, err)
+	assert.Empty(t, status.Operations)
+	assert.False(t, status.Dirty())
+	content, err := ioutil.ReadFile(filepath.Join(repos.Local, "test.md"))
+	assert.NoError(t, err)
+	assert.Equal(t, "Local", string(content))
+
+	gogit.options = map[string]RepoOptions{repos.Local: options}
+	assert.NoError(t, gogit.Sync(repos.Local))
+	assertState(t, repos.Local, Sync)
+}
+
+func TestSync_CommitsMergeWithoutChanges(t *testing.T) {
+	repos := test_helpers.SetupRepos()
+	defer test_helpers.CleanupRepos(repos)
+	test_helpers.WriteFile(t, repos.Local, "test.md", "TestContent")
+	performSync(t, repos.Local)
+
+	// Both sides made the same change, so the merge leaves nothing to commit but MERGE_HEAD.
+	makeConflict(t, repos.Remote)
+	test_helpers.WriteFile(t, repos.Local, "test.md", "Cause conflict")
+	test_helpers.PerformCmd(t, repos.Local, "git", "commit", "-am", "Same change")
+	test_helpers.PerformCmd(t, repos.Local, "git", "fetch")
+	gogit := GitCmd{}
+	assert.NoError(t, gogit.startMerge(repos.Local))
+	status, err := GetStatus(repos.Local, RepoOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{OperationMerge}, status.Operations)
+	assert.False(t, status.Dirty())
+
+	performSync(t, repos.Local)
+	assertState(t, repos.Local, Sync)
+	assert.Equal(t, headCommit(repos.Local), revParse(t, repos.Remote, "master"))
+	assert.Equal(t, revParse(t, repos.Local, "HEAD^2"), revParse(t, repos.Remote, "master^2"))
+}
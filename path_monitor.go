@@ -2,27 +2,96 @@ package main
 
 import (
 	"log"
+	"sync"
 	"time"
 )
 
 type PathMonitor interface {
 	StartMonitoring(repoPath string, watcher Watcher, git Git)
+	StopMonitoring(repoPath string)
 	scheduleUpdate(repoPath string, channel chan string)
 }
 
 type GitRepoMonitor struct {
 	scheduledUpdateInterval time.Duration
+	store                   *StateStore
+
+	mutex     sync.Mutex
+	monitored map[string]*monitoredRepo
+}
+
+type monitoredRepo struct {
+	watcher Watcher
+	channel chan string
+	stop    chan struct{}
+}
+
+// stopChannel returns nil if the repo isn't monitored, so that receiving from it blocks forever.
+func (g *GitRepoMonitor) stopChannel(repoPath string) chan struct{} {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	if repo, ok := g.monitored[repoPath]; ok {
+		return repo.stop
+	}
+	return nil
 }
 
 func (g *GitRepoMonitor) scheduleUpdate(repoPath string, channel chan string) {
-	time.AfterFunc(g.scheduledUpdateInterval, func() {
-		channel <- repoPath
-		g.scheduleUpdate(repoPath, channel)
+	stop := g.stopChannel(repoPath)
+	time.AfterFunc(devicePolicy.Stretch(g.scheduledUpdateInterval), func() {
+		select {
+		case channel <- repoPath:
+			g.scheduleUpdate(repoPath, channel)
+		case <-stop:
+		}
 	})
 }
 
+// restore picks up where the previous run left off, so that the time since the last successful sync
+// and the failure notifications don't start over on every restart.
+func (g *GitRepoMonitor) restore(repoPath string) {
+	store := g.store
+	if store == nil {
+		var err error
+		store, err = DefaultStateStore()
+		if err != nil {
+			log.Printf("Unable to find the state store. Err: %v", err)
+			return
+		}
+	}
+
+	record, err := store.Load(repoPath)
+	if err != nil {
+		log.Printf("Unable to restore the state of %s. Err: %v", repoPath, err)
+		return
+	}
+	if record.LastAttempt.IsZero() {
+		return
+	}
+
+	log.Printf("%s was last %s at %s", repoPath, record.State, record.LastAttempt.Format(time.RFC3339))
+	if !record.LastSuccess.IsZero() {
+		metrics.RestoreLastSuccess(repoPath, record.LastSuccess)
+	}
+	if record.ConsecutiveFailures > 0 {
+		log.Printf("%s has failed %d times in a row since %s. Last error: %s", repoPath, record.ConsecutiveFailures, record.FailingSince.Format(time.RFC3339), record.LastError)
+		notifier.RestoreFailingSince(repoPath, record.FailingSince)
+	}
+}
+
 func (g *GitRepoMonitor) StartMonitoring(repoPath string, watcher Watcher, git Git) {
 	var channel = make(chan string)
+	var stop = make(chan struct{})
+
+	g.mutex.Lock()
+	if g.monitored == nil {
+		g.monitored = map[string]*monitoredRepo{}
+	}
+	g.monitored[repoPath] = &monitoredRepo{watcher: watcher, channel: channel, stop: stop}
+	g.mutex.Unlock()
+
+	g.restore(repoPath)
 	err := git.Sync(repoPath)
 	if err != nil {
 		log.Printf("Syncing failed. Err: %v", err)
@@ -33,13 +102,51 @@ func (g *GitRepoMonitor) StartMonitoring(repoPath string, watcher Watcher, git G
 
 	go func() {
 		for {
-			path := <-channel
-			err = git.Sync(path)
-			if err != nil {
-				log.Printf("Syncing failed. Err: %v", err)
+			select {
+			case path := <-channel:
+				err := git.Sync(path)
+				if err != nil {
+					log.Printf("Syncing failed. Err: %v", err)
+				}
+			case <-stop:
+				return
 			}
 		}
 	}()
 
 	log.Printf("Git notes is monitoring %s", repoPath)
 }
+
+func (g *GitRepoMonitor) StopMonitoring(repoPath string) {
+	g.mutex.Lock()
+	repo, ok := g.monitored[repoPath]
+	delete(g.monitored, repoPath)
+	g.mutex.Unlock()
+
+	if !ok {
+		return
+	}
+
+	repo.watcher.Unwatch(repoPath)
+	close(repo.stop)
+	log.Printf("Git notes stopped monitoring %s", repoPath)
+}
+
+// RequestSync syncs the repo as soon as its current sync, if any, is done. It returns false if the
+// repo isn't monitored.
+func (g *GitRepoMonitor) RequestSync(repoPath string) bool {
+	g.mutex.Lock()
+	repo, ok := g.monitored[repoPath]
+	g.mutex.Unlock()
+
+	if !ok {
+		return false
+	}
+	go func() {
+		select {
+		case repo.channel <- repoPath:
+		case <-repo.stop:
+		}
+	}()
+	return true
+}
@@ -38,6 +38,29 @@ func TestGitRepoMonitor_StartMonitoringAutomaticScheduleUpdate(t *testing.T) {
 	}, 1 * time.Second, 10 * time.Millisecond)
 }
 
+func TestGitRepoMonitor_StopMonitoring(t *testing.T) {
+	var gitRepoMonitor = GitRepoMonitor{
+		scheduledUpdateInterval: 100 * time.Millisecond,
+	}
+	var watcher = MockWatcher{}
+	var git = MockGit{}
+
+	gitRepoMonitor.StartMonitoring("some-path", &watcher, &git)
+	assert.Equal(t, 1, git.Count)
+
+	gitRepoMonitor.StopMonitoring("some-path")
+	assert.Equal(t, "some-path", watcher.unwatchedPath)
+
+	time.Sleep(300 * time.Millisecond)
+	assert.Equal(t, 1, git.Count)
+
+	select {
+	case watcher.channel <- "some-path":
+		assert.Fail(t, "The channel should not be read anymore")
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
 func TestGitRepoMonitor_ScheduleUpdate(t *testing.T) {
 	var gitRepoMonitor = GitRepoMonitor{
 		scheduledUpdateInterval: 100 * time.Millisecond,
@@ -58,8 +81,9 @@ func TestGitRepoMonitor_ScheduleUpdate(t *testing.T) {
 }
 
 type MockWatcher struct {
-	repoPath string
-	channel  chan string
+	repoPath      string
+	unwatchedPath string
+	channel       chan string
 }
 
 func (m *MockWatcher) Watch(path string, channel chan string) {
@@ -67,6 +91,10 @@ func (m *MockWatcher) Watch(path string, channel chan string) {
 	m.channel = channel
 }
 
+func (m *MockWatcher) Unwatch(path string) {
+	m.unwatchedPath = path
+}
+
 type MockGit struct {
 	Count int
 }
@@ -87,3 +115,28 @@ func (m *MockGit) Update(path string) error {
 func (m *MockGit) GetState(path string) (State, error) {
 	return Sync, nil
 }
+
+func (m *MockGit) Status(path string) (RepoStatus, error) {
+	return RepoStatus{Branch: "master", Upstream: "origin/master"}, nil
+}
+
+func TestGitRepoMonitor_RequestSync(t *testing.T) {
+	var gitRepoMonitor = GitRepoMonitor{
+		scheduledUpdateInterval: time.Minute,
+	}
+	var watcher = MockWatcher{}
+	var git = MockGit{}
+
+	assert.False(t, gitRepoMonitor.RequestSync("some-path"))
+
+	gitRepoMonitor.StartMonitoring("some-path", &watcher, &git)
+	assert.Equal(t, 1, git.Count)
+
+	assert.True(t, gitRepoMonitor.RequestSync("some-path"))
+	assert.Eventually(t, func() bool {
+		return git.Count == 2
+	}, 1 * time.Second, 10 * time.Millisecond)
+
+	gitRepoMonitor.StopMonitoring("some-path")
+	assert.False(t, gitRepoMonitor.RequestSync("some-path"))
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// maxRepoLogSize is the size at which the log of a repo is rotated. The previous log is kept as
+	// <log>.1.
+	maxRepoLogSize  = 1 << 20
+	repoLogTailSize = 64 << 10
+)
+
+// RepoLog is the log file of a repo in the state directory. Every sync of the repo writes to it, whether
+// the daemon or the dashboard runs it, so that the dashboard shows the log of the syncs of the daemon.
+// The file is opened for every write, so that the processes append to it side by side.
+type RepoLog struct {
+	path string
+}
+
+func NewRepoLog(dir string, repo string) *RepoLog {
+	return &RepoLog{path: filepath.Join(dir, "logs", repoFileName(repo)+".log")}
+}
+
+func DefaultRepoLog(repo string) (*RepoLog, error) {
+	dir, err := StateDir()
+	if err != nil {
+		return nil, err
+	}
+	return NewRepoLog(dir, repo), nil
+}
+
+// Write never fails, so that a log file that can't be written doesn't keep the line from the other
+// writers of the logger.
+func (l *RepoLog) Write(p []byte) (int, error) {
+	if info, err := os.Stat(l.path); err == nil && info.Size() > maxRepoLogSize {
+		_ = os.Rename(l.path, l.path+".1")
+	}
+	if err := os.MkdirAll(filepath.Dir(l.path), 0700); err != nil {
+		return len(p), nil
+	}
+	file, err := os.OpenFile(l.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return len(p), nil
+	}
+	_, _ = file.Write(p)
+	file.Close()
+	return len(p), nil
+}
+
+// Tail returns the last n lines of the log. Only the end of the file is read.
+func (l *RepoLog) Tail(n int) ([]string, error) {
+	file, err := os.Open(l.path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	// The byte before the tail tells whether its first line is cut off.
+	offset := info.Size() - repoLogTailSize - 1
+	if offset < 0 {
+		offset = 0
+	}
+	data := make([]byte, info.Size()-offset)
+	_, err = file.ReadAt(data, offset)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+
+	text := strings.TrimRight(string(data), "\n")
+	if offset > 0 {
+		text = text[strings.Index(text, "\n")+1:]
+	}
+	if text == "" {
+		return nil, nil
+	}
+	return lastLines(strings.Split(text, "\n"), n), nil
+}
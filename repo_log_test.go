@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestRepoLog_Tail(t *testing.T) {
+	dir, err := ioutil.TempDir("", "git-notes-repo-log")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	repoLog := NewRepoLog(dir, "/notes")
+	lines, err := repoLog.Tail(3)
+	assert.NoError(t, err)
+	assert.Empty(t, lines)
+
+	for i := 0; i < 5; i++ {
+		fmt.Fprintf(repoLog, "line %d\n", i)
+	}
+	lines, err = repoLog.Tail(3)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"line 2", "line 3", "line 4"}, lines)
+
+	other, err := NewRepoLog(dir, "/other-notes").Tail(3)
+	assert.NoError(t, err)
+	assert.Empty(t, other)
+}
+
+func TestRepoLog_Rotate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "git-notes-repo-log")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	repoLog := NewRepoLog(dir, "/notes")
+	long := strings.Repeat("x", 1023) + "\n"
+	for i := 0; i <= maxRepoLogSize/len(long); i++ {
+		_, err := repoLog.Write([]byte(long))
+		assert.NoError(t, err)
+	}
+	_, err = repoLog.Write([]byte("last\n"))
+	assert.NoError(t, err)
+
+	info, err := os.Stat(repoLog.path)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(len("last\n")), info.Size())
+	_, err = os.Stat(repoLog.path + ".1")
+	assert.NoError(t, err)
+
+	// Only the end of a long log is read, and the line cut off at the start is dropped.
+	assert.NoError(t, os.Rename(repoLog.path+".1", repoLog.path))
+	lines, err := repoLog.Tail(1000)
+	assert.NoError(t, err)
+	assert.Equal(t, repoLogTailSize/len(long), len(lines))
+	assert.Equal(t, strings.TrimSpace(long), lines[0])
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// The operations that git can leave unfinished in a repo.
+const (
+	OperationMerge      = "merge"
+	OperationRebase     = "rebase"
+	OperationCherryPick = "cherry-pick"
+	OperationRevert     = "revert"
+	OperationBisect     = "bisect"
+)
+
+// RepoStatus is the status of a repo as reported by `git status --porcelain=v2 --branch`. Branch is
+// empty when HEAD is detached. HeadSHA is empty before the first commit. Upstream and UpstreamSHA are
+// empty when the branch has no upstream. Renamed files are listed by their new paths.
+type RepoStatus struct {
+	GitDir      string
+	Branch      string
+	Upstream    string
+	HeadSHA     string
+	UpstreamSHA string
+	Ahead       int
+	Behind      int
+	Staged      []string
+	Unstaged    []string
+	Untracked   []string
+	Conflicted  []string
+	Operations  []string
+}
+
+func (s RepoStatus) Dirty() bool {
+	return len(s.Staged) > 0 || len(s.Unstaged) > 0 || len(s.Untracked) > 0 || len(s.Conflicted) > 0
+}
+
+// Detached reports whether HEAD is on a commit instead of a branch, e.g. while you look at an old
+// commit. There's no branch to sync.
+func (s RepoStatus) Detached() bool {
+	return s.Branch == "" && s.HeadSHA != ""
+}
+
+// State reduces the status to the state of the sync engine. A branch without an upstream has never
+// been pushed, so it's ahead. So is a branch whose upstream is gone from the remote. A detached HEAD
+// is left alone like a git operation in progress.
+func (s RepoStatus) State() State {
+	switch {
+	case s.Detached():
+		return Busy
+	case s.Dirty():
+		return Dirty
+	case s.HeadSHA == "" && s.UpstreamSHA == "":
+		return Sync
+	case s.HeadSHA == "":
+		return OutOfSync
+	case s.Upstream == "" || s.UpstreamSHA == "":
+		return Ahead
+	case s.Behind > 0:
+		return OutOfSync
+	case s.Ahead > 0:
+		return Ahead
+	}
+	return Sync
+}
+
+// ParseStatusV2 parses the output of `git status --porcelain=v2 --branch -z`.
+func ParseStatusV2(out string) (RepoStatus, error) {
+	var status RepoStatus
+	entries := strings.Split(out, "\x00")
+	for i := 0; i < len(entries); i++ {
+		entry := entries[i]
+		if entry == "" {
+			continue
+		}
+
+		if strings.HasPrefix(entry, "# ") {
+			fields := strings.Fields(entry)
+			if len(fields) < 3 {
+				continue
+			}
+			switch fields[1] {
+			case "branch.oid":
+				if fields[2] != "(initial)" {
+					status.HeadSHA = fields[2]
+				}
+			case "branch.head":
+				if fields[2] != "(detached)" {
+					status.Branch = fields[2]
+				}
+			case "branch.upstream":
+				status.Upstream = fields[2]
+			case "branch.ab":
+				if len(fields) != 4 {
+					return status, fmt.Errorf("unable to parse status: %s", entry)
+				}
+				ahead, err := strconv.Atoi(strings.TrimPrefix(fields[2], "+"))
+				if err != nil {
+					return status, fmt.Errorf("unable to parse status: %s", entry)
+				}
+				behind, err := strconv.Atoi(strings.TrimPrefix(fields[3], "-"))
+				if err != nil {
+					return status, fmt.Errorf("unable to parse status: %s", entry)
+				}
+				status.Ahead, status.Behind = ahead, behind
+			}
+			continue
+		}
+
+		// The number of fields before the path depends on the kind of entry.
+		var fields []string
+		switch entry[0] {
+		case '?':
+			status.Untracked = append(status.Untracked, entry[2:])
+			continue
+		case '!':
+			continue
+		case '1':
+			fields = strings.SplitN(entry, " ", 9)
+		case '2':
+			fields = strings.SplitN(entry, " ", 10)
+			// The original path of a rename is the next entry.
+			i++
+		case 'u':
+			fields = strings.SplitN(entry, " ", 11)
+		default:
+			return status, fmt.Errorf("unable to parse status: %s", entry)
+		}
+		if len(fields) < 9 || len(fields[1]) != 2 {
+			return status, fmt.Errorf("unable to parse status: %s", entry)
+		}
+		path := fields[len(fields)-1]
+		if entry[0] == 'u' {
+			status.Conflicted = append(status.Conflicted, path)
+			continue
+		}
+		if fields[1][0] != '.' {
+			status.Staged = append(status.Staged, path)
+		}
+		if fields[1][1] != '.' {
+			status.Unstaged = append(status.Unstaged, path)
+		}
+	}
+	return status, nil
+}
+
+// operationsInProgress looks for the files that git keeps while an operation is unfinished.
+func operationsInProgress(gitDir string) []string {
+	markers := []struct {
+		operation string
+		files     []string
+	}{
+		{OperationMerge, []string{"MERGE_HEAD"}},
+		{OperationRebase, []string{"rebase-merge", "rebase-apply"}},
+		{OperationCherryPick, []string{"CHERRY_PICK_HEAD"}},
+		{OperationRevert, []string{"REVERT_HEAD"}},
+		{OperationBisect, []string{"BISECT_LOG"}},
+	}
+
+	var operations []string
+	for _, marker := range markers {
+		for _, file := range marker.files {
+			if _, err := os.Stat(filepath.Join(gitDir, file)); err == nil {
+				operations = append(operations, marker.operation)
+				break
+			}
+		}
+	}
+	return operations
+}
+
+// GetStatus reads the status without fetching, so the upstream is as of the last fetch. The files
+// outside the sparse checkout are ignored.
+func GetStatus(path string, options RepoOptions) (RepoStatus, error) {
+	args := []string{"status", "--porcelain=v2", "--branch", "-z"}
+	if options.Submodules {
+		// The changes inside a submodule are committed by syncing the submodule.
+		args = append(args, "--ignore-submodules=dirty")
+	}
+	args = append(append(args, "--"), SparsePathspecs(options.SparseCheckout)...)
+	var out string
+	err := timeGitOp(path, "status", func() (err error) {
+		out, err = runCmd(path, "git", args...)
+		return err
+	})
+	if err != nil {
+		return RepoStatus{}, fmt.Errorf("unable to get status. Out: %s, Err: %w", out, err)
+	}
+
+	status, err := ParseStatusV2(out)
+	if err != nil {
+		return status, err
+	}
+
+	if status.Upstream != "" {
+		if out, err := runCmd(path, "git", "rev-parse", "--verify", "--quiet", "@{upstream}"); err == nil {
+			status.UpstreamSHA = strings.TrimSpace(out)
+		}
+	}
+
+	out, err = runCmd(path, "git", "rev-parse", "--absolute-git-dir")
+	if err != nil {
+		return status, fmt.Errorf("unable to find the git directory. Out: %s, Err: %w", out, err)
+	}
+	status.GitDir = strings.TrimSpace(out)
+	status.Operations = operationsInProgress(status.GitDir)
+	return status, nil
+}
+
+func (g *GitCmd) Status(path string) (RepoStatus, error) {
+	return GetStatus(path, g.optionsFor(path))
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/tanin47/git-notes/internal/test_helpers"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseStatusV2(t *testing.T) {
+	out := strings.Join([]string{
+		"# branch.oid 1111111111111111111111111111111111111111",
+		"# branch.head master",
+		"# branch.upstream origin/master",
+		"# branch.ab +2 -3",
+		"1 M. N... 100644 100644 100644 aaaa bbbb staged.md",
+		"1 .M N... 100644 100644 100644 aaaa bbbb unstaged.md",
+		"1 MM N... 100644 100644 100644 aaaa bbbb both.md",
+		"2 R. N... 100644 100644 100644 aaaa bbbb R100 new name.md",
+		"old name.md",
+		"u UU N... 100644 100644 100644 100644 aaaa bbbb cccc conflicted.md",
+		"? untracked file.md",
+		"! ignored.md",
+		"",
+	}, "\x00")
+
+	status, err := ParseStatusV2(out)
+	assert.NoError(t, err)
+	assert.Equal(t, RepoStatus{
+		Branch:     "master",
+		Upstream:   "origin/master",
+		HeadSHA:    "1111111111111111111111111111111111111111",
+		Ahead:      2,
+		Behind:     3,
+		Staged:     []string{"staged.md", "both.md", "new name.md"},
+		Unstaged:   []string{"unstaged.md", "both.md"},
+		Untracked:  []string{"untracked file.md"},
+		Conflicted: []string{"conflicted.md"},
+	}, status)
+}
+
+func TestParseStatusV2_Initial(t *testing.T) {
+	status, err := ParseStatusV2("# branch.oid (initial)\x00# branch.head (detached)\x00")
+	assert.NoError(t, err)
+	assert.Equal(t, RepoStatus{}, status)
+}
+
+func TestParseStatusV2_UpstreamGone(t *testing.T) {
+	status, err := ParseStatusV2("# branch.oid 1111111111111111111111111111111111111111\x00# branch.head master\x00# branch.upstream origin/master\x00")
+	assert.NoError(t, err)
+	assert.Equal(t, RepoStatus{Branch: "master", Upstream: "origin/master", HeadSHA: "1111111111111111111111111111111111111111"}, status)
+	assert.Equal(t, Ahead, status.State())
+}
+
+func TestParseStatusV2_Invalid(t *testing.T) {
+	_, err := ParseStatusV2("# branch.ab +x -1\x00")
+	assert.EqualError(t, err, "unable to parse status: # branch.ab +x -1")
+	_, err = ParseStatusV2("3 something\x00")
+	assert.EqualError(t, err, "unable to parse status: 3 something")
+}
+
+func TestRepoStatus_State(t *testing.T) {
+	cases := []struct {
+		status RepoStatus
+		state  State
+	}{
+		{RepoStatus{Untracked: []string{"a.md"}}, Dirty},
+		{RepoStatus{}, Sync},
+		{RepoStatus{UpstreamSHA: "2"}, OutOfSync},
+		{RepoStatus{HeadSHA: "1"}, Busy},
+		{RepoStatus{Branch: "master", HeadSHA: "1"}, Ahead},
+		{RepoStatus{Branch: "master", HeadSHA: "1", Upstream: "origin/master"}, Ahead},
+		{RepoStatus{Branch: "master", HeadSHA: "1", Upstream: "origin/master", UpstreamSHA: "1"}, Sync},
+		{RepoStatus{Branch: "master", HeadSHA: "1", Upstream: "origin/master", UpstreamSHA: "2", Ahead: 1}, Ahead},
+		{RepoStatus{Branch: "master", HeadSHA: "1", Upstream: "origin/master", UpstreamSHA: "2", Behind: 1}, OutOfSync},
+		{RepoStatus{Branch: "master", HeadSHA: "1", Upstream: "origin/master", UpstreamSHA: "2", Ahead: 1, Behind: 1}, OutOfSync},
+	}
+	for _, c := range cases {
+		assert.Equal(t, c.state, c.status.State(), "%+v", c.status)
+	}
+}
+
+func TestOperationsInProgress(t *testing.T) {
+	gitDir, err := ioutil.TempDir("", "git-notes-git-dir")
+	assert.NoError(t, err)
+	defer os.RemoveAll(gitDir)
+	assert.Empty(t, operationsInProgress(gitDir))
+
+	for _, file := range []string{"REVERT_HEAD", "BISECT_LOG"} {
+		assert.NoError(t, ioutil.WriteFile(filepath.Join(gitDir, file), nil, 0600))
+	}
+	assert.NoError(t, os.Mkdir(filepath.Join(gitDir, "rebase-apply"), 0700))
+	assert.Equal(t, []string{OperationRebase, OperationRevert, OperationBisect}, operationsInProgress(gitDir))
+}
+
+func TestGoGit_Status(t *testing.T) {
+	repos := test_helpers.SetupRepos()
+	defer test_helpers.CleanupRepos(repos)
+	gogit := GitCmd{}
+
+	test_helpers.WriteFile(t, repos.Local, "test.md", "TestContent")
+	test_helpers.WriteFile(t, repos.Local, "other.md", "Other")
+	performSync(t, repos.Local)
+
+	status, err := gogit.Status(repos.Local)
+	assert.NoError(t, err)
+	assert.Equal(t, "master", status.Branch)
+	assert.Equal(t, "origin/master", status.Upstream)
+	assert.Equal(t, headCommit(repos.Local), status.HeadSHA)
+	assert.Equal(t, status.HeadSHA, status.UpstreamSHA)
+	assert.Equal(t, Sync, status.State())
+
+	test_helpers.WriteFile(t, repos.Local, "other.md", "Staged")
+	test_helpers.PerformCmd(t, repos.Local, "git", "add", "other.md")
+	test_helpers.WriteFile(t, repos.Local, "new.md", "Untracked")
+	status, err = gogit.Status(repos.Local)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"other.md"}, status.Staged)
+	assert.Empty(t, status.Unstaged)
+	assert.Equal(t, []string{"new.md"}, status.Untracked)
+	assert.Equal(t, Dirty, status.State())
+	test_helpers.WriteFile(t, repos.Local, "test.md", "Local")
+	test_helpers.PerformCmd(t, repos.Local, "git", "add", "--all")
+	test_helpers.PerformCmd(t, repos.Local, "git", "commit", "-m", "Local")
+
+	makeConflict(t, repos.Remote)
+	test_helpers.PerformCmd(t, repos.Local, "git", "fetch")
+	status, err = gogit.Status(repos.Local)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, status.Ahead)
+	assert.Equal(t, 1, status.Behind)
+	assert.NotEqual(t, status.HeadSHA, status.UpstreamSHA)
+
+	// The merge fails because of the conflict and stays in progress.
+	cmd := exec.Command("git", "merge", "origin/master")
+	cmd.Dir = repos.Local
+	assert.Error(t, cmd.Run())
+	status, err = gogit.Status(repos.Local)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"test.md"}, status.Conflicted)
+	assert.Equal(t, []string{OperationMerge}, status.Operations)
+}
+
+func TestGoGit_UpstreamGone(t *testing.T) {
+	repos := test_helpers.SetupRepos()
+	defer test_helpers.CleanupRepos(repos)
+	test_helpers.WriteFile(t, repos.Local, "test.md", "TestContent")
+	performSync(t, repos.Local)
+
+	// The branch is deleted on the remote, e.g. by another machine.
+	test_helpers.PerformCmd(t, repos.Remote, "git", "branch", "-m", "master", "renamed")
+	test_helpers.PerformCmd(t, repos.Local, "git", "fetch", "--prune")
+	status, err := GetStatus(repos.Local, RepoOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, "origin/master", status.Upstream)
+	assert.Equal(t, Ahead, status.State())
+
+	performSync(t, repos.Local)
+	assert.Equal(t, headCommit(repos.Local), revParse(t, repos.Remote, "master"))
+}
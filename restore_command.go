@@ -0,0 +1,256 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func init() {
+	commands["versions"] = Command{
+		Usage: "versions <file>",
+		Run:   runVersions,
+	}
+	commands["restore"] = Command{
+		Usage: "restore <file> --at <time|commit> [--force]",
+		Run:   runRestore,
+	}
+}
+
+// NoteVersion is a commit that changed a note. Path is where the note was at that commit, which
+// differs from the current path if the note has been renamed since.
+type NoteVersion struct {
+	Commit  string
+	Time    time.Time
+	Path    string
+	Added   int
+	Deleted int
+	Binary  bool
+}
+
+func (v NoteVersion) String() string {
+	stat := fmt.Sprintf("+%d -%d", v.Added, v.Deleted)
+	if v.Binary {
+		stat = "binary"
+	}
+	line := fmt.Sprintf("%s  %s  %s", shortCommits([]string{v.Commit})[0], v.Time.Local().Format("2006-01-02 15:04:05"), stat)
+	if v.Path != "" {
+		line += "  " + v.Path
+	}
+	return line
+}
+
+// noteInRepo finds the repo of a note and its path relative to the repo. The note doesn't have to
+// exist, e.g. it was deleted.
+func noteInRepo(arg string) (string, string, error) {
+	file, err := expandPath(arg)
+	if err != nil {
+		return "", "", err
+	}
+	file, err = filepath.Abs(file)
+	if err != nil {
+		return "", "", err
+	}
+
+	repo, err := resolveRepoArg(filepath.Dir(file))
+	if err != nil {
+		return "", "", err
+	}
+	dir, err := filepath.EvalSymlinks(filepath.Dir(file))
+	if err != nil {
+		return "", "", err
+	}
+	rel, err := filepath.Rel(repo, filepath.Join(dir, filepath.Base(file)))
+	if err != nil {
+		return "", "", err
+	}
+	return repo, filepath.ToSlash(rel), nil
+}
+
+// NoteVersions lists the versions of a note, newest first, following renames.
+func NoteVersions(repo string, file string) ([]NoteVersion, error) {
+	out, err := runCmdStdout(repo, "git", "-c", "core.quotePath=false", "log", "--follow", "--format=%x00%H %ct", "--numstat", "--", file)
+	if err != nil {
+		return nil, fmt.Errorf("unable to list the versions of %s. Err: %v", file, err)
+	}
+
+	var versions []NoteVersion
+	for _, entry := range strings.Split(string(out), "\x00") {
+		lines := strings.Split(strings.TrimSpace(entry), "\n")
+		header := strings.Fields(lines[0])
+		if len(header) != 2 {
+			continue
+		}
+		seconds, err := strconv.ParseInt(header[1], 10, 64)
+		if err != nil {
+			continue
+		}
+		version := NoteVersion{Commit: header[0], Time: time.Unix(seconds, 0)}
+
+		for _, line := range lines[1:] {
+			fields := strings.SplitN(line, "\t", 3)
+			if len(fields) != 3 {
+				continue
+			}
+			version.Binary = fields[0] == "-"
+			version.Added, _ = strconv.Atoi(fields[0])
+			version.Deleted, _ = strconv.Atoi(fields[1])
+			version.Path = renamedTo(fields[2])
+		}
+		versions = append(versions, version)
+	}
+	return versions, nil
+}
+
+// renamedTo returns the new path of a numstat path, which looks like `old => new` or
+// `dir/{old => new}.md` for renames.
+func renamedTo(path string) string {
+	if open := strings.Index(path, "{"); open >= 0 {
+		if close := strings.Index(path[open:], "}"); close >= 0 {
+			inner := path[open+1 : open+close]
+			if arrow := strings.Index(inner, " => "); arrow >= 0 {
+				return strings.Replace(path[:open]+inner[arrow+4:]+path[open+close+1:], "//", "/", 1)
+			}
+		}
+	}
+	if arrow := strings.Index(path, " => "); arrow >= 0 {
+		return path[arrow+4:]
+	}
+	return path
+}
+
+// FindVersion picks the version of a note at a commit or at a time. A time picks the latest version
+// committed at or before it.
+func FindVersion(repo string, file string, at string, now time.Time) (NoteVersion, error) {
+	versions, err := NoteVersions(repo, file)
+	if err != nil {
+		return NoteVersion{}, err
+	}
+	if len(versions) == 0 {
+		return NoteVersion{}, fmt.Errorf("%s has no versions", file)
+	}
+
+	if t, err := parseTime(at, now); err == nil {
+		for _, version := range versions {
+			if !version.Time.After(t) {
+				return version, nil
+			}
+		}
+		return NoteVersion{}, fmt.Errorf("%s didn't exist at %s", file, t.Format(time.RFC3339))
+	}
+
+	out, err := runCmd(repo, "git", "rev-parse", "--verify", "--quiet", at+"^{commit}")
+	if err != nil {
+		return NoteVersion{}, fmt.Errorf("%s is neither a time nor a commit", at)
+	}
+	commit := strings.TrimSpace(out)
+
+	// The commit might not have changed the note. Then, the note is as of its latest version before
+	// the commit.
+	for _, version := range versions {
+		_, err := runCmd(repo, "git", "merge-base", "--is-ancestor", version.Commit, commit)
+		if err == nil {
+			return version, nil
+		}
+	}
+	return NoteVersion{}, fmt.Errorf("%s didn't exist at %s", file, at)
+}
+
+// RestoreVersion writes the version into the work tree at the current path of the note. The engine
+// then commits it like any other change. The filters run, so encrypted notes are restored decrypted.
+func RestoreVersion(repo string, file string, version NoteVersion) error {
+	path := version.Path
+	if path == "" {
+		path = file
+	}
+	out, err := runCmdStdout(repo, "git", "cat-file", "--filters", version.Commit+":"+path)
+	if err != nil {
+		return fmt.Errorf("%s doesn't exist at %s. It might have been deleted. Err: %v", path, shortCommits([]string{version.Commit})[0], err)
+	}
+
+	target := filepath.Join(repo, filepath.FromSlash(file))
+	err = os.MkdirAll(filepath.Dir(target), 0755)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(target, out, 0644)
+}
+
+func runVersions(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: git-notes %s", commands["versions"].Usage)
+	}
+
+	repo, file, err := noteInRepo(args[0])
+	if err != nil {
+		return err
+	}
+	versions, err := NoteVersions(repo, file)
+	if err != nil {
+		return err
+	}
+	if len(versions) == 0 {
+		fmt.Fprintf(stdout, "%s has no versions.\n", file)
+		return nil
+	}
+
+	for _, version := range versions {
+		if version.Path == file {
+			version.Path = ""
+		}
+		fmt.Fprintln(stdout, version)
+	}
+	return nil
+}
+
+func runRestore(args []string) error {
+	flags := flag.NewFlagSet("restore", flag.ContinueOnError)
+	at := flags.String("at", "", "The time (24h, 2006-01-02, or an RFC3339 timestamp) or the commit to restore")
+	force := flags.Bool("force", false, "Overwrite the uncommitted changes of the note")
+
+	positional, err := parseInterspersed(flags, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 1 || *at == "" {
+		return fmt.Errorf("usage: git-notes %s", commands["restore"].Usage)
+	}
+
+	repo, file, err := noteInRepo(positional[0])
+	if err != nil {
+		return err
+	}
+	unlock, err := lockRepo(repo)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if !*force {
+		out, err := runCmd(repo, "git", "status", "--porcelain", "--", file)
+		if err != nil {
+			return fmt.Errorf("unable to get the status of %s. Out: %s, Err: %v", file, out, err)
+		}
+		if strings.TrimSpace(out) != "" {
+			return fmt.Errorf("%s has uncommitted changes. Wait for the next sync or pass --force", file)
+		}
+	}
+
+	version, err := FindVersion(repo, file, *at, time.Now())
+	if err != nil {
+		return err
+	}
+	err = RestoreVersion(repo, file, version)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Restored %s as of %s (%s)", file, version.Time.Local().Format("2006-01-02 15:04:05"), shortCommits([]string{version.Commit})[0])
+	return nil
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/tanin47/git-notes/internal/test_helpers"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"testing"
+	"time"
+)
+
+func commitAt(t *testing.T, repo string, at time.Time, message string) string {
+	test_helpers.PerformCmd(t, repo, "git", "add", "--all")
+	cmd := exec.Command("git", "commit", "-m", message)
+	cmd.Dir = repo
+	date := fmt.Sprintf("%d +0000", at.Unix())
+	cmd.Env = append(os.Environ(), "GIT_AUTHOR_DATE="+date, "GIT_COMMITTER_DATE="+date)
+	out, err := cmd.CombinedOutput()
+	assert.NoError(t, err, string(out))
+	return headCommit(repo)
+}
+
+func TestRenamedTo(t *testing.T) {
+	assert.Equal(t, "note.md", renamedTo("note.md"))
+	assert.Equal(t, "new.md", renamedTo("old.md => new.md"))
+	assert.Equal(t, "notes/new.md", renamedTo("notes/{old.md => new.md}"))
+	assert.Equal(t, "notes/2020/a.md", renamedTo("notes/{ => 2020}/a.md"))
+}
+
+func TestVersionsAndRestore(t *testing.T) {
+	repos := test_helpers.SetupRepos()
+	defer test_helpers.CleanupRepos(repos)
+
+	start := time.Date(2020, 1, 1, 10, 0, 0, 0, time.UTC)
+	test_helpers.WriteFile(t, repos.Local, "old.md", "line 1\n")
+	first := commitAt(t, repos.Local, start, "First")
+	test_helpers.WriteFile(t, repos.Local, "old.md", "line 1\nline 2\nline 3\n")
+	second := commitAt(t, repos.Local, start.Add(time.Hour), "Second")
+	test_helpers.PerformCmd(t, repos.Local, "git", "mv", "old.md", "note.md")
+	renamed := commitAt(t, repos.Local, start.Add(90*time.Minute), "Renamed")
+	test_helpers.WriteFile(t, repos.Local, "note.md", "line 1\nline 2 changed\nline 3\n")
+	third := commitAt(t, repos.Local, start.Add(2*time.Hour), "Third")
+	test_helpers.WriteFile(t, repos.Local, "other.md", "Other")
+	fourth := commitAt(t, repos.Local, start.Add(3*time.Hour), "Fourth")
+
+	versions, err := NoteVersions(repos.Local, "note.md")
+	assert.NoError(t, err)
+	assert.Equal(t, []NoteVersion{
+		{Commit: third, Time: time.Unix(start.Add(2*time.Hour).Unix(), 0), Path: "note.md", Added: 1, Deleted: 1},
+		{Commit: renamed, Time: time.Unix(start.Add(90*time.Minute).Unix(), 0), Path: "note.md", Added: 0, Deleted: 0},
+		{Commit: second, Time: time.Unix(start.Add(time.Hour).Unix(), 0), Path: "old.md", Added: 2, Deleted: 0},
+		{Commit: first, Time: time.Unix(start.Unix(), 0), Path: "old.md", Added: 1, Deleted: 0},
+	}, versions)
+
+	version, err := FindVersion(repos.Local, "note.md", "2020-01-01T11:15:00Z", start.Add(24*time.Hour))
+	assert.NoError(t, err)
+	assert.Equal(t, second, version.Commit)
+
+	version, err = FindVersion(repos.Local, "note.md", fourth[:7], start)
+	assert.NoError(t, err)
+	assert.Equal(t, third, version.Commit)
+
+	_, err = FindVersion(repos.Local, "note.md", "2019-12-31", start)
+	assert.EqualError(t, err, "note.md didn't exist at 2019-12-31T00:00:00Z")
+	_, err = FindVersion(repos.Local, "note.md", "no-such-commit", start)
+	assert.EqualError(t, err, "no-such-commit is neither a time nor a commit")
+
+	var buffer bytes.Buffer
+	oldStdout := stdout
+	stdout = &buffer
+	defer func() { stdout = oldStdout }()
+	assert.NoError(t, runVersions([]string{repos.Local + "/note.md"}))
+	assert.Contains(t, buffer.String(), third[:7]+"  "+versions[0].Time.Local().Format("2006-01-02 15:04:05")+"  +1 -1\n")
+	assert.Contains(t, buffer.String(), second[:7]+"  "+versions[2].Time.Local().Format("2006-01-02 15:04:05")+"  +2 -0  old.md\n")
+	assert.Equal(t, 4, len(versions))
+
+	assert.NoError(t, runRestore([]string{repos.Local + "/note.md", "--at", second}))
+	content, err := ioutil.ReadFile(repos.Local + "/note.md")
+	assert.NoError(t, err)
+	assert.Equal(t, "line 1\nline 2\nline 3\n", string(content))
+
+	assert.EqualError(t, runRestore([]string{repos.Local + "/note.md", "--at", first}), "note.md has uncommitted changes. Wait for the next sync or pass --force")
+	assert.NoError(t, runRestore([]string{repos.Local + "/note.md", "--at", first, "--force"}))
+	content, err = ioutil.ReadFile(repos.Local + "/note.md")
+	assert.NoError(t, err)
+	assert.Equal(t, "line 1\n", string(content))
+
+	// The engine commits the restored version as a normal change.
+	performSync(t, repos.Local)
+	out, err := runCmd(repos.Remote, "git", "show", "master:note.md")
+	assert.NoError(t, err)
+	assert.Equal(t, "line 1\n", out)
+}
+
+func TestRestoreVersion_KeepsStderrOut(t *testing.T) {
+	repos := test_helpers.SetupRepos()
+	defer test_helpers.CleanupRepos(repos)
+
+	// The smudge filter warns on stderr, which must not end up in the restored note.
+	test_helpers.PerformCmd(t, repos.Local, "git", "config", "filter.noisy.smudge", "echo warning >&2; cat")
+	test_helpers.PerformCmd(t, repos.Local, "git", "config", "filter.noisy.clean", "cat")
+	test_helpers.WriteFile(t, repos.Local, ".gitattributes", "*.md filter=noisy\n")
+	test_helpers.WriteFile(t, repos.Local, "note.md", "line 1\n")
+	first := commitAt(t, repos.Local, time.Date(2020, 1, 1, 10, 0, 0, 0, time.UTC), "First")
+	test_helpers.WriteFile(t, repos.Local, "note.md", "line 2\n")
+
+	assert.NoError(t, RestoreVersion(repos.Local, "note.md", NoteVersion{Commit: first, Path: "note.md"}))
+	content, err := ioutil.ReadFile(repos.Local + "/note.md")
+	assert.NoError(t, err)
+	assert.Equal(t, "line 1\n", string(content))
+
+	err = RestoreVersion(repos.Local, "note.md", NoteVersion{Commit: first, Path: "other.md"})
+	assert.Contains(t, err.Error(), "other.md doesn't exist at "+first[:7]+". It might have been deleted. Err: exit status 128. Stderr: fatal:")
+}
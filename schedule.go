@@ -0,0 +1,322 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+var ErrPaused = errors.New("syncing is paused")
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+// ScheduleOptions restricts when a repo syncs. Times are HH:MM in the local time zone.
+type ScheduleOptions struct {
+	// QuietHours are ranges like "22:00-07:00" during which the repo doesn't sync at all.
+	QuietHours []string `json:"quiet_hours" yaml:"quiet_hours" toml:"quiet_hours"`
+	// PushDays are the weekdays, e.g. "mon", on which pushing is allowed. The changes are still
+	// committed locally on the other days.
+	PushDays []string `json:"push_days" yaml:"push_days" toml:"push_days"`
+	// PushAfter delays pushing until this time of the day.
+	PushAfter string `json:"push_after" yaml:"push_after" toml:"push_after"`
+}
+
+// SyncPolicy is what the schedule and the pause allow right now. Reason explains the restriction.
+type SyncPolicy struct {
+	Sync   bool
+	Push   bool
+	Reason string
+}
+
+// parseClock returns the minutes since midnight of HH:MM.
+func parseClock(value string) (int, error) {
+	t, err := time.Parse("15:04", value)
+	if err != nil {
+		return 0, fmt.Errorf("%s is not a time like 18:30", value)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+func parseClockRange(value string) (int, int, error) {
+	parts := strings.Split(value, "-")
+	if len(parts) != 2 {
+		return 0, 0, fmt.Errorf("%s is not a range like 22:00-07:00", value)
+	}
+	start, err := parseClock(strings.TrimSpace(parts[0]))
+	if err != nil {
+		return 0, 0, err
+	}
+	end, err := parseClock(strings.TrimSpace(parts[1]))
+	if err != nil {
+		return 0, 0, err
+	}
+	return start, end, nil
+}
+
+func parseWeekday(value string) (time.Weekday, error) {
+	lower := strings.ToLower(value)
+	if len(lower) >= 3 {
+		if day, ok := weekdays[lower[:3]]; ok && strings.HasPrefix(strings.ToLower(day.String()), lower) {
+			return day, nil
+		}
+	}
+	return 0, fmt.Errorf("%s is not a weekday", value)
+}
+
+func (o ScheduleOptions) Validate() []error {
+	var errs []error
+	for i, quietHours := range o.QuietHours {
+		if _, _, err := parseClockRange(quietHours); err != nil {
+			errs = append(errs, fmt.Errorf("quiet_hours[%d]: %v", i, err))
+		}
+	}
+	for i, day := range o.PushDays {
+		if _, err := parseWeekday(day); err != nil {
+			errs = append(errs, fmt.Errorf("push_days[%d]: %v", i, err))
+		}
+	}
+	if o.PushAfter != "" {
+		if _, err := parseClock(o.PushAfter); err != nil {
+			errs = append(errs, fmt.Errorf("push_after: %v", err))
+		}
+	}
+	return errs
+}
+
+// Policy assumes the options are valid.
+func (o ScheduleOptions) Policy(now time.Time) SyncPolicy {
+	minutes := now.Hour()*60 + now.Minute()
+	for _, quietHours := range o.QuietHours {
+		start, end, err := parseClockRange(quietHours)
+		if err != nil {
+			continue
+		}
+		inside := minutes >= start && minutes < end
+		if start > end {
+			inside = minutes >= start || minutes < end
+		}
+		if inside {
+			return SyncPolicy{Sync: false, Push: false, Reason: fmt.Sprintf("quiet hours %s", quietHours)}
+		}
+	}
+
+	if len(o.PushDays) > 0 {
+		allowed := false
+		for _, day := range o.PushDays {
+			if weekday, err := parseWeekday(day); err == nil && weekday == now.Weekday() {
+				allowed = true
+			}
+		}
+		if !allowed {
+			return SyncPolicy{Sync: true, Push: false, Reason: fmt.Sprintf("pushes only on %s", strings.Join(o.PushDays, ", "))}
+		}
+	}
+
+	if o.PushAfter != "" {
+		if pushAfter, err := parseClock(o.PushAfter); err == nil && minutes < pushAfter {
+			return SyncPolicy{Sync: true, Push: false, Reason: fmt.Sprintf("pushes are delayed until %s", o.PushAfter)}
+		}
+	}
+
+	return SyncPolicy{Sync: true, Push: true}
+}
+
+// Pause stops a repo from syncing until it's resumed or, if Until is set, until then. A pause with a
+// PID is a hold, which ends when the process exits.
+type Pause struct {
+	Since time.Time `json:"since"`
+	Until time.Time `json:"until,omitempty"`
+	PID   int       `json:"pid,omitempty"`
+}
+
+func (p Pause) ActiveAt(now time.Time) bool {
+	if p.PID != 0 && !processAlive(p.PID) {
+		return false
+	}
+	return p.Until.IsZero() || now.Before(p.Until)
+}
+
+func (p Pause) String() string {
+	if p.PID != 0 {
+		return fmt.Sprintf("held by git-notes hold (pid %d)", p.PID)
+	}
+	if p.Until.IsZero() {
+		return fmt.Sprintf("paused since %s", p.Since.Format(time.RFC3339))
+	}
+	return fmt.Sprintf("paused until %s", p.Until.Format(time.RFC3339))
+}
+
+// PauseStore keeps the paused repos in a JSON file in the state directory. The pause and resume
+// commands write it, and the daemon reads it before every sync.
+type PauseStore struct {
+	file *JSONStore
+}
+
+func NewPauseStore(dir string) *PauseStore {
+	return &PauseStore{file: NewJSONStore(filepath.Join(dir, "pauses.json"))}
+}
+
+func DefaultPauseStore() (*PauseStore, error) {
+	dir, err := StateDir()
+	if err != nil {
+		return nil, err
+	}
+	return NewPauseStore(dir), nil
+}
+
+func (s *PauseStore) Load() (map[string]Pause, error) {
+	pauses := map[string]Pause{}
+	err := s.file.Load(&pauses)
+	if err != nil {
+		return nil, err
+	}
+	return pauses, nil
+}
+
+func (s *PauseStore) update(fn func(pauses map[string]Pause)) error {
+	pauses := map[string]Pause{}
+	return s.file.Update(&pauses, func() (bool, error) {
+		fn(pauses)
+		return true, nil
+	})
+}
+
+func (s *PauseStore) Pause(repo string, pause Pause) error {
+	return s.update(func(pauses map[string]Pause) {
+		for path, existing := range pauses {
+			if !existing.ActiveAt(pause.Since) {
+				delete(pauses, path)
+			}
+		}
+		pauses[repo] = pause
+	})
+}
+
+func (s *PauseStore) Resume(repo string) error {
+	return s.update(func(pauses map[string]Pause) {
+		delete(pauses, repo)
+	})
+}
+
+// Release ends the hold of the process unless the repo has been paused or held again since.
+func (s *PauseStore) Release(repo string, pid int) error {
+	return s.update(func(pauses map[string]Pause) {
+		if pauses[repo].PID == pid {
+			delete(pauses, repo)
+		}
+	})
+}
+
+// Paused returns the pause of the repo if it's still active.
+func (s *PauseStore) Paused(repo string, now time.Time) (Pause, bool, error) {
+	pauses, err := s.Load()
+	if err != nil {
+		return Pause{}, false, err
+	}
+	pause, ok := pauses[repo]
+	if !ok || !pause.ActiveAt(now) {
+		return Pause{}, false, nil
+	}
+	return pause, true, nil
+}
+
+func init() {
+	commands["pause"] = Command{
+		Usage: "pause <repo> [--until <duration|HH:MM|RFC3339>]",
+		Run:   runPause,
+	}
+	commands["resume"] = Command{
+		Usage: "resume <repo>",
+		Run:   runResume,
+	}
+}
+
+// parseUntil accepts a duration from now (2h), the next occurrence of a time of the day (18:00), or a
+// timestamp (2020-01-02T15:04:05Z).
+func parseUntil(value string, now time.Time) (time.Time, error) {
+	if duration, err := time.ParseDuration(value); err == nil {
+		return now.Add(duration), nil
+	}
+	if minutes, err := parseClock(value); err == nil {
+		until := time.Date(now.Year(), now.Month(), now.Day(), minutes/60, minutes%60, 0, 0, now.Location())
+		if !until.After(now) {
+			until = until.AddDate(0, 0, 1)
+		}
+		return until, nil
+	}
+	if until, err := time.Parse(time.RFC3339, value); err == nil {
+		return until, nil
+	}
+	return time.Time{}, fmt.Errorf("%s is not a duration, a time like 18:00, or an RFC3339 timestamp", value)
+}
+
+func runPause(args []string) error {
+	flags := flag.NewFlagSet("pause", flag.ContinueOnError)
+	untilFlag := flags.String("until", "", "When to resume syncing automatically")
+
+	positional, err := parseInterspersed(flags, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 1 {
+		return fmt.Errorf("usage: git-notes %s", commands["pause"].Usage)
+	}
+
+	repo, err := resolveRepoArg(positional[0])
+	if err != nil {
+		return err
+	}
+	pause := Pause{Since: time.Now()}
+	if *untilFlag != "" {
+		pause.Until, err = parseUntil(*untilFlag, pause.Since)
+		if err != nil {
+			return err
+		}
+	}
+
+	store, err := DefaultPauseStore()
+	if err != nil {
+		return err
+	}
+	err = store.Pause(repo, pause)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("%s is %s", repo, pause)
+	return nil
+}
+
+func runResume(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: git-notes %s", commands["resume"].Usage)
+	}
+
+	repo, err := resolveRepoArg(args[0])
+	if err != nil {
+		return err
+	}
+	store, err := DefaultPauseStore()
+	if err != nil {
+		return err
+	}
+	err = store.Resume(repo)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("%s is resumed. It syncs on the next check", repo)
+	return nil
+}
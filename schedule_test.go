@@ -0,0 +1,141 @@
+package main
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/tanin47/git-notes/internal/test_helpers"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestScheduleOptions_Policy(t *testing.T) {
+	// 2020-01-06 is a Monday.
+	at := func(day int, hour int, minute int) time.Time {
+		return time.Date(2020, 1, day, hour, minute, 0, 0, time.Local)
+	}
+
+	options := ScheduleOptions{QuietHours: []string{"22:00-07:00", "12:00-13:00"}}
+	assert.Equal(t, SyncPolicy{Sync: false, Push: false, Reason: "quiet hours 22:00-07:00"}, options.Policy(at(6, 23, 0)))
+	assert.Equal(t, SyncPolicy{Sync: false, Push: false, Reason: "quiet hours 22:00-07:00"}, options.Policy(at(6, 6, 59)))
+	assert.Equal(t, SyncPolicy{Sync: false, Push: false, Reason: "quiet hours 12:00-13:00"}, options.Policy(at(6, 12, 30)))
+	assert.Equal(t, SyncPolicy{Sync: true, Push: true}, options.Policy(at(6, 7, 0)))
+
+	options = ScheduleOptions{PushDays: []string{"mon", "Friday"}, PushAfter: "18:00"}
+	assert.Equal(t, SyncPolicy{Sync: true, Push: false, Reason: "pushes only on mon, Friday"}, options.Policy(at(7, 19, 0)))
+	assert.Equal(t, SyncPolicy{Sync: true, Push: false, Reason: "pushes are delayed until 18:00"}, options.Policy(at(10, 17, 59)))
+	assert.Equal(t, SyncPolicy{Sync: true, Push: true}, options.Policy(at(10, 18, 0)))
+	assert.Equal(t, SyncPolicy{Sync: true, Push: true}, options.Policy(at(6, 23, 0)))
+}
+
+func TestScheduleOptions_Validate(t *testing.T) {
+	options := ScheduleOptions{QuietHours: []string{"22:00", "25:00-07:00"}, PushDays: []string{"mo", "funday"}, PushAfter: "6pm"}
+	var messages []string
+	for _, err := range options.Validate() {
+		messages = append(messages, err.Error())
+	}
+	assert.Equal(t, []string{
+		"quiet_hours[0]: 22:00 is not a range like 22:00-07:00",
+		"quiet_hours[1]: 25:00 is not a time like 18:30",
+		"push_days[0]: mo is not a weekday",
+		"push_days[1]: funday is not a weekday",
+		"push_after: 6pm is not a time like 18:30",
+	}, messages)
+}
+
+func TestParseUntil(t *testing.T) {
+	now := time.Date(2020, 1, 6, 20, 0, 0, 0, time.UTC)
+
+	until, err := parseUntil("2h", now)
+	assert.NoError(t, err)
+	assert.Equal(t, now.Add(2*time.Hour), until)
+
+	until, err = parseUntil("18:00", now)
+	assert.NoError(t, err)
+	assert.Equal(t, time.Date(2020, 1, 7, 18, 0, 0, 0, time.UTC), until)
+
+	until, err = parseUntil("21:30", now)
+	assert.NoError(t, err)
+	assert.Equal(t, time.Date(2020, 1, 6, 21, 30, 0, 0, time.UTC), until)
+
+	until, err = parseUntil("2020-02-01T00:00:00Z", now)
+	assert.NoError(t, err)
+	assert.Equal(t, time.Date(2020, 2, 1, 0, 0, 0, 0, time.UTC), until)
+
+	_, err = parseUntil("tomorrow", now)
+	assert.EqualError(t, err, "tomorrow is not a duration, a time like 18:00, or an RFC3339 timestamp")
+}
+
+func TestPauseStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "git-notes-state")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	now := time.Date(2020, 1, 6, 20, 0, 0, 0, time.UTC)
+	store := NewPauseStore(dir)
+
+	_, paused, err := store.Paused("/notes", now)
+	assert.NoError(t, err)
+	assert.False(t, paused)
+
+	assert.NoError(t, store.Pause("/notes", Pause{Since: now}))
+	assert.NoError(t, store.Pause("/work", Pause{Since: now, Until: now.Add(time.Hour)}))
+
+	// A new store reads what the previous one wrote, like the daemon after a restart.
+	store = NewPauseStore(dir)
+	pause, paused, err := store.Paused("/notes", now.Add(24*time.Hour))
+	assert.NoError(t, err)
+	assert.True(t, paused)
+	assert.Equal(t, "paused since 2020-01-06T20:00:00Z", pause.String())
+
+	_, paused, err = store.Paused("/work", now.Add(30*time.Minute))
+	assert.NoError(t, err)
+	assert.True(t, paused)
+	_, paused, err = store.Paused("/work", now.Add(time.Hour))
+	assert.NoError(t, err)
+	assert.False(t, paused)
+
+	assert.NoError(t, store.Resume("/notes"))
+	_, paused, err = store.Paused("/notes", now)
+	assert.NoError(t, err)
+	assert.False(t, paused)
+}
+
+func TestGoGit_PausedAndDelayedPush(t *testing.T) {
+	repos := test_helpers.SetupRepos()
+	defer test_helpers.CleanupRepos(repos)
+	dir, err := ioutil.TempDir("", "git-notes-state")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	test_helpers.WriteFile(t, repos.Local, "note.md", "Hello")
+	performSync(t, repos.Local)
+
+	now := time.Date(2020, 1, 6, 9, 0, 0, 0, time.Local)
+	gogit := GitCmd{
+		options: map[string]RepoOptions{repos.Local: {Schedule: ScheduleOptions{PushAfter: "18:00"}}},
+		pauses:  NewPauseStore(dir),
+		now:     func() time.Time { return now },
+	}
+
+	assert.NoError(t, gogit.pauses.Pause(repos.Local, Pause{Since: now}))
+	test_helpers.WriteFile(t, repos.Local, "note.md", "Hello again")
+	assert.NoError(t, gogit.Sync(repos.Local))
+	assert.Equal(t, Paused, metrics.states[repos.Local])
+	state, err := gogit.GetState(repos.Local)
+	assert.NoError(t, err)
+	assert.Equal(t, Dirty, state)
+
+	// Resumed but before push_after, so the change is only committed locally.
+	assert.NoError(t, gogit.pauses.Resume(repos.Local))
+	assert.NoError(t, gogit.Sync(repos.Local))
+	state, err = gogit.GetState(repos.Local)
+	assert.NoError(t, err)
+	assert.Equal(t, Ahead, state)
+
+	now = time.Date(2020, 1, 6, 18, 30, 0, 0, time.Local)
+	assert.NoError(t, gogit.Sync(repos.Local))
+	out, err := runCmd(repos.Remote, "git", "show", "master:note.md")
+	assert.NoError(t, err)
+	assert.Equal(t, "Hello again", out)
+}
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const defaultEntropyThreshold = 4.5
+
+type SecretScanConfig struct {
+	Disabled         bool     `json:"disabled" yaml:"disabled" toml:"disabled"`
+	Allowlist        []string `json:"allowlist" yaml:"allowlist" toml:"allowlist"`
+	AllowPaths       []string `json:"allow_paths" yaml:"allow_paths" toml:"allow_paths"`
+	EntropyThreshold float64  `json:"entropy_threshold" yaml:"entropy_threshold" toml:"entropy_threshold"`
+}
+
+type secretRule struct {
+	name    string
+	pattern *regexp.Regexp
+}
+
+var secretRules = []secretRule{
+	{"aws-access-key", regexp.MustCompile(`\b(?:AKIA|ASIA)[0-9A-Z]{16}\b`)},
+	{"github-token", regexp.MustCompile(`\bgh[pousr]_[A-Za-z0-9]{36,255}\b`)},
+	{"github-token", regexp.MustCompile(`\bgithub_pat_[A-Za-z0-9_]{22,255}\b`)},
+	{"slack-token", regexp.MustCompile(`\bxox[abposr]-[A-Za-z0-9-]{10,}`)},
+	{"slack-webhook", regexp.MustCompile(`https://hooks\.slack\.com/services/[A-Za-z0-9/_]+`)},
+	{"private-key", regexp.MustCompile(`-----BEGIN[A-Z ]*PRIVATE KEY-----`)},
+}
+
+var entropyCandidate = regexp.MustCompile(`[A-Za-z0-9+/=_\-]{20,}`)
+
+var hunkHeader = regexp.MustCompile(`^@@ -[0-9,]+ \+([0-9]+)`)
+
+// SecretFinding is a possible secret on an added line. Match is redacted so that the finding can be
+// logged and sent in notifications.
+type SecretFinding struct {
+	File  string
+	Line  int
+	Rule  string
+	Match string
+}
+
+func (f SecretFinding) String() string {
+	return fmt.Sprintf("%s:%d: %s (%s)", f.File, f.Line, f.Rule, f.Match)
+}
+
+// SecretsFoundError holds back the commit until the secrets are removed or allowlisted.
+type SecretsFoundError struct {
+	Findings []SecretFinding
+}
+
+func (e *SecretsFoundError) Error() string {
+	var findings []string
+	for _, finding := range e.Findings {
+		findings = append(findings, finding.String())
+	}
+	return fmt.Sprintf("found possible secrets in the staged changes: %s", strings.Join(findings, ", "))
+}
+
+type SecretScanner struct {
+	disabled         bool
+	allowlist        []*regexp.Regexp
+	allowPaths       []string
+	entropyThreshold float64
+}
+
+func NewSecretScanner(config SecretScanConfig) (*SecretScanner, error) {
+	scanner := &SecretScanner{
+		disabled:         config.Disabled,
+		allowPaths:       config.AllowPaths,
+		entropyThreshold: config.EntropyThreshold,
+	}
+	if scanner.entropyThreshold == 0 {
+		scanner.entropyThreshold = defaultEntropyThreshold
+	}
+
+	for _, pattern := range config.Allowlist {
+		allowed, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid allowlist pattern %s. Err: %v", pattern, err)
+		}
+		scanner.allowlist = append(scanner.allowlist, allowed)
+	}
+	for _, pattern := range config.AllowPaths {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid allow_paths pattern %s. Err: %v", pattern, err)
+		}
+	}
+	return scanner, nil
+}
+
+var defaultSecretScanner, _ = NewSecretScanner(SecretScanConfig{})
+
+// ScanStaged scans the lines added by the staged changes. Binary files, which include the encrypted
+// files, are skipped. During a merge, the lines that come from either side aren't scanned again.
+func (s *SecretScanner) ScanStaged(path string) ([]SecretFinding, error) {
+	if s.disabled {
+		return nil, nil
+	}
+
+	var diffs []string
+	err := timeGitOp(path, "diff", func() (err error) {
+		diffs, err = DiffStaged(path, "--no-color", "--no-ext-diff", "--no-textconv", "--unified=0")
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to get the staged changes. Err: %w", err)
+	}
+
+	// Both diffs number the lines of the staged files, so a line added on neither side is in both.
+	findings := s.Scan(diffs[0])
+	for _, diff := range diffs[1:] {
+		found := map[SecretFinding]bool{}
+		for _, finding := range s.Scan(diff) {
+			found[finding] = true
+		}
+		var here []SecretFinding
+		for _, finding := range findings {
+			if found[finding] {
+				here = append(here, finding)
+			}
+		}
+		findings = here
+	}
+	return findings, nil
+}
+
+// Check holds back the commit if the staged changes contain possible secrets.
+func (s *SecretScanner) Check(path string) error {
+	findings, err := s.ScanStaged(path)
+	if err != nil {
+		return err
+	}
+	if len(findings) > 0 {
+		return &SecretsFoundError{Findings: findings}
+	}
+	return nil
+}
+
+// Scan finds the possible secrets on the added lines of a unified diff.
+func (s *SecretScanner) Scan(diff string) []SecretFinding {
+	var findings []SecretFinding
+	file := ""
+	line := 0
+	for _, text := range strings.Split(diff, "\n") {
+		switch {
+		case strings.HasPrefix(text, "+++ "):
+			file = strings.TrimPrefix(strings.TrimPrefix(text, "+++ "), "b/")
+		case strings.HasPrefix(text, "@@ "):
+			if matches := hunkHeader.FindStringSubmatch(text); matches != nil {
+				line, _ = strconv.Atoi(matches[1])
+			}
+		case strings.HasPrefix(text, "+"):
+			if !s.isPathAllowed(file) {
+				for _, finding := range s.scanLine(text[1:]) {
+					finding.File = file
+					finding.Line = line
+					findings = append(findings, finding)
+				}
+			}
+			line++
+		}
+	}
+	return findings
+}
+
+func (s *SecretScanner) scanLine(text string) []SecretFinding {
+	var findings []SecretFinding
+	var matched []string
+	for _, rule := range secretRules {
+		for _, match := range rule.pattern.FindAllString(text, -1) {
+			matched = append(matched, match)
+			if !s.isAllowed(match) {
+				findings = append(findings, SecretFinding{Rule: rule.name, Match: redact(match)})
+			}
+		}
+	}
+
+	for _, candidate := range entropyCandidate.FindAllString(text, -1) {
+		if overlaps(candidate, matched) || !hasLetterAndDigit(candidate) || shannonEntropy(candidate) < s.entropyThreshold {
+			continue
+		}
+		if !s.isAllowed(candidate) {
+			findings = append(findings, SecretFinding{Rule: "high-entropy-string", Match: redact(candidate)})
+		}
+	}
+	return findings
+}
+
+func (s *SecretScanner) isAllowed(secret string) bool {
+	for _, allowed := range s.allowlist {
+		if allowed.MatchString(secret) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *SecretScanner) isPathAllowed(file string) bool {
+	for _, pattern := range s.allowPaths {
+		if ok, _ := filepath.Match(pattern, file); ok {
+			return true
+		}
+		if ok, _ := filepath.Match(pattern, filepath.Base(file)); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func overlaps(candidate string, matched []string) bool {
+	for _, match := range matched {
+		if strings.Contains(candidate, match) || strings.Contains(match, candidate) {
+			return true
+		}
+	}
+	return false
+}
+
+func hasLetterAndDigit(value string) bool {
+	return strings.ContainsAny(value, "0123456789") &&
+		strings.ContainsAny(strings.ToLower(value), "abcdefghijklmnopqrstuvwxyz")
+}
+
+// shannonEntropy is the number of bits per character. Random tokens of 30 characters or more score
+// above 4.5 while words and hex hashes, e.g. commit hashes, stay below.
+func shannonEntropy(value string) float64 {
+	counts := map[rune]int{}
+	for _, c := range value {
+		counts[c]++
+	}
+
+	entropy := 0.0
+	length := float64(len(value))
+	for _, count := range counts {
+		p := float64(count) / length
+		entropy -= p * math.Log2(p)
+	}
+	return entropy
+}
+
+func redact(secret string) string {
+	if len(secret) <= 4 {
+		return strings.Repeat("*", len(secret))
+	}
+	return secret[:4] + strings.Repeat("*", len(secret)-4)
+}
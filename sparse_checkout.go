@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"reflect"
+	"strings"
+)
+
+// SparsePathspecs returns the pathspecs that cover the cone of the sparse-checkout patterns: the
+// top-level files, the files directly inside the parents of every pattern, and everything under
+// every pattern. It returns nil when the repo isn't sparse.
+func SparsePathspecs(patterns []string) []string {
+	if len(patterns) == 0 {
+		return nil
+	}
+
+	pathspecs := []string{":(top,glob)*"}
+	seen := map[string]bool{}
+	for _, pattern := range patterns {
+		pattern = strings.Trim(pattern, "/")
+		for parent := path.Dir(pattern); parent != "."; parent = path.Dir(parent) {
+			if !seen[parent] {
+				seen[parent] = true
+				pathspecs = append(pathspecs, fmt.Sprintf(":(top,glob)%s/*", parent))
+			}
+		}
+		pathspecs = append(pathspecs, ":(top,literal)"+pattern)
+	}
+	return pathspecs
+}
+
+// ApplySparseCheckout sets the sparse-checkout cone when it differs from the configured patterns.
+// A repo without patterns is left as it is.
+func ApplySparseCheckout(repoPath string, options RepoOptions) error {
+	if len(options.SparseCheckout) == 0 {
+		return nil
+	}
+
+	var wanted []string
+	for _, pattern := range options.SparseCheckout {
+		wanted = append(wanted, strings.Trim(pattern, "/"))
+	}
+
+	out, err := runCmd(repoPath, "git", "sparse-checkout", "list")
+	if err == nil && reflect.DeepEqual(strings.Fields(out), wanted) {
+		return nil
+	}
+
+	return timeGitOp(repoPath, "sparse-checkout", func() error {
+		out, err := runCmd(repoPath, "git", append([]string{"sparse-checkout", "set", "--cone"}, wanted...)...)
+		if err != nil {
+			return fmt.Errorf("%v. Out: %s", err, out)
+		}
+		return nil
+	})
+}
+
+func validateSparsePattern(pattern string) error {
+	trimmed := strings.Trim(pattern, "/")
+	if trimmed == "" {
+		return fmt.Errorf("must name a directory")
+	}
+	if strings.ContainsAny(trimmed, "*?[]!\\") {
+		return fmt.Errorf("must be a directory, not a glob")
+	}
+	for _, part := range strings.Split(trimmed, "/") {
+		if part == "." || part == ".." {
+			return fmt.Errorf("must not contain . or ..")
+		}
+	}
+	return nil
+}
+
+func validatePartialCloneFilter(filter string) error {
+	if filter == "blob:none" || strings.HasPrefix(filter, "blob:limit=") || strings.HasPrefix(filter, "tree:") {
+		return nil
+	}
+	return fmt.Errorf("must be blob:none, blob:limit=<size>, or tree:<depth>")
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/tanin47/git-notes/internal/test_helpers"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestSparsePathspecs(t *testing.T) {
+	assert.Nil(t, SparsePathspecs(nil))
+	assert.Equal(t, []string{
+		":(top,glob)*",
+		":(top,literal)work",
+		":(top,glob)projects/2020/*",
+		":(top,glob)projects/*",
+		":(top,literal)projects/2020/notes",
+		":(top,literal)projects/2021",
+	}, SparsePathspecs([]string{"work/", "projects/2020/notes", "/projects/2021"}))
+}
+
+func TestValidateSparsePattern(t *testing.T) {
+	assert.NoError(t, validateSparsePattern("work/notes/"))
+	assert.Error(t, validateSparsePattern("/"))
+	assert.Error(t, validateSparsePattern("work/*.md"))
+	assert.Error(t, validateSparsePattern("../work"))
+}
+
+func TestGoGit_SparseCheckout(t *testing.T) {
+	repos := test_helpers.SetupRepos()
+	defer test_helpers.CleanupRepos(repos)
+
+	assert.NoError(t, os.MkdirAll(repos.Local+"/work/2020", 0755))
+	assert.NoError(t, os.MkdirAll(repos.Local+"/attachments", 0755))
+	test_helpers.WriteFile(t, repos.Local, "index.md", "Index")
+	test_helpers.WriteFile(t, repos.Local, "work/todo.md", "Todo")
+	test_helpers.WriteFile(t, repos.Local, "work/2020/notes.md", "Notes")
+	test_helpers.WriteFile(t, repos.Local, "attachments/big.pdf", "PDF")
+	performSync(t, repos.Local)
+
+	gogit := GitCmd{options: map[string]RepoOptions{
+		repos.Local: {SparseCheckout: []string{"work/2020"}},
+	}}
+	assert.NoError(t, gogit.Sync(repos.Local))
+
+	_, err := os.Stat(repos.Local + "/attachments/big.pdf")
+	assert.True(t, os.IsNotExist(err))
+
+	assert.NoError(t, os.MkdirAll(repos.Local+"/attachments", 0755))
+	test_helpers.WriteFile(t, repos.Local, "attachments/new.pdf", "Outside the cone")
+
+	dirty, err := gogit.IsDirty(repos.Local)
+	assert.NoError(t, err)
+	assert.False(t, dirty)
+
+	test_helpers.WriteFile(t, repos.Local, "work/2020/notes.md", "Notes2")
+	test_helpers.WriteFile(t, repos.Local, "work/todo.md", "Todo2")
+	test_helpers.WriteFile(t, repos.Local, "index.md", "Index2")
+
+	dirty, err = gogit.IsDirty(repos.Local)
+	assert.NoError(t, err)
+	assert.True(t, dirty)
+
+	assert.NoError(t, gogit.Sync(repos.Local))
+	state, err := gogit.GetState(repos.Local)
+	assert.NoError(t, err)
+	assert.Equal(t, Sync, state)
+
+	out, err := runCmd(repos.Remote, "git", "ls-tree", "-r", "--name-only", "master")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"attachments/big.pdf", "index.md", "work/2020/notes.md", "work/todo.md"}, strings.Fields(out))
+
+	out, err = runCmd(repos.Remote, "git", "show", "master:work/todo.md")
+	assert.NoError(t, err)
+	assert.Equal(t, "Todo2", out)
+}
+
+func TestGoGit_PartialClone(t *testing.T) {
+	repos := test_helpers.SetupRepos()
+	defer test_helpers.CleanupRepos(repos)
+
+	test_helpers.WriteFile(t, repos.Local, "test.md", "TestContent")
+	performSync(t, repos.Local)
+
+	gogit := GitCmd{options: map[string]RepoOptions{
+		repos.Local: {PartialClone: "blob:none"},
+	}}
+	state, err := gogit.GetState(repos.Local)
+	assert.NoError(t, err)
+	assert.Equal(t, Sync, state)
+
+	out, err := runCmd(repos.Local, "git", "config", "remote.origin.partialclonefilter")
+	assert.NoError(t, err)
+	assert.Equal(t, "blob:none", strings.TrimSpace(out))
+}
+
+func TestConfig_ResolveReposRepoOptions(t *testing.T) {
+	repos := test_helpers.SetupRepos()
+	defer test_helpers.CleanupRepos(repos)
+
+	dir, err := ioutil.TempDir("", "git-notes-link-dir")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	assert.NoError(t, os.Symlink(repos.Local, dir+"/notes"))
+
+	config := Config{
+		Repos: []string{repos.Local},
+		RepoOptions: map[string]RepoOptions{
+			dir + "/notes": {SparseCheckout: []string{"work"}},
+		},
+	}
+	assert.NoError(t, config.ResolveRepos("git-notes.json"))
+	assert.Equal(t, map[string]RepoOptions{resolvedPath(t, repos.Local): {SparseCheckout: []string{"work"}}}, config.RepoOptions)
+
+	config.RepoOptions = map[string]RepoOptions{"/non-existent-notes": {}}
+	assert.EqualError(t, config.ResolveRepos("git-notes.json"), "git-notes.json: repo_options[/non-existent-notes] names a repo that does not exist")
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// StateDir is where git-notes keeps what must survive a restart, e.g. the paused repos. It follows the
+// XDG base directory spec: $XDG_STATE_HOME/git-notes, or ~/.local/state/git-notes by default.
+func StateDir() (string, error) {
+	if dir := os.Getenv("XDG_STATE_HOME"); dir != "" && filepath.IsAbs(dir) {
+		return filepath.Join(dir, "git-notes"), nil
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("unable to find the state directory. Err: %v", err)
+	}
+	return filepath.Join(home, ".local", "state", "git-notes"), nil
+}
+
+// writeFileAtomically replaces the file in one step, so that the daemon never reads a file that a
+// command is halfway through writing.
+func writeFileAtomically(path string, data []byte) error {
+	err := os.MkdirAll(filepath.Dir(path), 0700)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// JSONStore is a JSON file in the state directory that the daemon and the commands both update. An
+// update holds a lock next to the file, so that the processes never overwrite each other's changes.
+type JSONStore struct {
+	path  string
+	mutex sync.Mutex
+}
+
+func NewJSONStore(path string) *JSONStore {
+	return &JSONStore{path: path}
+}
+
+// Load reads the file into v, which is left as it is if the file doesn't exist.
+func (s *JSONStore) Load(v interface{}) error {
+	data, err := ioutil.ReadFile(s.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	err = json.Unmarshal(data, v)
+	if err != nil {
+		return fmt.Errorf("unable to read %s. Err: %v", s.path, err)
+	}
+	return nil
+}
+
+// Update loads the file into v, and writes v back only if fn reports a change.
+func (s *JSONStore) Update(v interface{}, fn func() (bool, error)) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	err := os.MkdirAll(filepath.Dir(s.path), 0700)
+	if err != nil {
+		return err
+	}
+	unlock, err := lockFile(s.path+".lock", 0)
+	if err != nil {
+		return fmt.Errorf("unable to lock %s. Err: %v", s.path, err)
+	}
+	defer unlock()
+
+	err = s.Load(v)
+	if err != nil {
+		return err
+	}
+	changed, err := fn()
+	if err != nil || !changed {
+		return err
+	}
+
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomically(s.path, data)
+}
+
+// resolveRepoArg turns a path given on the command line, possibly inside the repo, into the repo path
+// that the daemon uses, i.e. the root of the work tree with the symlinks resolved.
+func resolveRepoArg(arg string) (string, error) {
+	path, err := expandPath(arg)
+	if err != nil {
+		return "", err
+	}
+	path, err = filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+
+	out, err := runCmd(path, "git", "rev-parse", "--show-toplevel")
+	if err != nil {
+		return "", fmt.Errorf("%s is not inside a git work tree", arg)
+	}
+	return filepath.EvalSymlinks(strings.TrimSpace(out))
+}
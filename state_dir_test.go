@@ -0,0 +1,50 @@
+package main
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func TestJSONStore_Update(t *testing.T) {
+	dir, err := ioutil.TempDir("", "git-notes-json-store")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "state", "counts.json")
+
+	counts := map[string]int{}
+	assert.NoError(t, NewJSONStore(path).Load(&counts))
+	assert.Empty(t, counts)
+
+	// Every store has its own mutex, like the stores of different processes, so only the lock keeps
+	// the updates from overwriting each other.
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			counts := map[string]int{}
+			assert.NoError(t, NewJSONStore(path).Update(&counts, func() (bool, error) {
+				counts[fmt.Sprint(i)] = i
+				return true, nil
+			}))
+		}(i)
+	}
+	wg.Wait()
+
+	assert.NoError(t, NewJSONStore(path).Load(&counts))
+	assert.Equal(t, 10, len(counts))
+
+	unchanged := map[string]int{}
+	assert.EqualError(t, NewJSONStore(path).Update(&unchanged, func() (bool, error) {
+		unchanged["1"] = 100
+		return false, fmt.Errorf("failed")
+	}), "failed")
+	counts = map[string]int{}
+	assert.NoError(t, NewJSONStore(path).Load(&counts))
+	assert.Equal(t, 1, counts["1"])
+}
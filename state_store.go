@@ -0,0 +1,166 @@
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const defaultHistoryLimit = 200
+
+const (
+	OutcomeSynced            = "synced"
+	OutcomeConflict          = "conflict"
+	OutcomeHeld              = "held"
+	OutcomeBlocked           = "blocked"
+	OutcomeNeedsConfirmation = "needs-confirmation"
+	OutcomeFailed            = "failed"
+)
+
+var AllOutcomes = []string{OutcomeSynced, OutcomeConflict, OutcomeHeld, OutcomeBlocked, OutcomeNeedsConfirmation, OutcomeFailed}
+
+// MergeOutcome is the result of merging the remote branch. Conflicts lists the files left with
+// conflict markers.
+type MergeOutcome struct {
+	Conflicts []string `json:"conflicts,omitempty"`
+}
+
+func (m MergeOutcome) String() string {
+	if len(m.Conflicts) == 0 {
+		return "clean"
+	}
+	return fmt.Sprintf("conflict in %s", strings.Join(m.Conflicts, ", "))
+}
+
+// SyncAttempt is one run of GitCmd.Sync. States are the states visited in order, Commits are the
+// commits made on the local branch, newest first, and Files are the files that these commits changed.
+type SyncAttempt struct {
+	Started  time.Time      `json:"started"`
+	Duration time.Duration  `json:"duration"`
+	States   []State        `json:"states"`
+	Commits  []string       `json:"commits,omitempty"`
+	Files    []string       `json:"files,omitempty"`
+	Merges   []MergeOutcome `json:"merges,omitempty"`
+	Outcome  string         `json:"outcome"`
+	Error    string         `json:"error,omitempty"`
+}
+
+// RepoRecord is everything the daemon remembers about a repo across restarts. History is bounded and
+// ordered from the oldest to the newest attempt.
+type RepoRecord struct {
+	Repo                string        `json:"repo"`
+	State               State         `json:"state,omitempty"`
+	LastAttempt         time.Time     `json:"last_attempt"`
+	LastSuccess         time.Time     `json:"last_success"`
+	LastError           string        `json:"last_error,omitempty"`
+	LastErrorAt         time.Time     `json:"last_error_at"`
+	FailingSince        time.Time     `json:"failing_since"`
+	ConsecutiveFailures int           `json:"consecutive_failures"`
+	History             []SyncAttempt `json:"history"`
+}
+
+// StateStore keeps one JSON file per repo under the state directory.
+type StateStore struct {
+	dir          string
+	historyLimit int
+	mutex        sync.Mutex
+}
+
+func NewStateStore(dir string) *StateStore {
+	return &StateStore{dir: filepath.Join(dir, "repos"), historyLimit: defaultHistoryLimit}
+}
+
+var (
+	stateStoresMutex sync.Mutex
+	stateStores      = map[string]*StateStore{}
+)
+
+// DefaultStateStore returns the same store for the same state directory, so that its mutex covers
+// every sync of the process.
+func DefaultStateStore() (*StateStore, error) {
+	dir, err := StateDir()
+	if err != nil {
+		return nil, err
+	}
+
+	stateStoresMutex.Lock()
+	defer stateStoresMutex.Unlock()
+	store, ok := stateStores[dir]
+	if !ok {
+		store = NewStateStore(dir)
+		stateStores[dir] = store
+	}
+	return store, nil
+}
+
+func (s *StateStore) pathFor(repo string) string {
+	sum := sha1.Sum([]byte(repo))
+	return filepath.Join(s.dir, hex.EncodeToString(sum[:8])+".json")
+}
+
+// Load returns an empty record if nothing has been recorded for the repo.
+func (s *StateStore) Load(repo string) (RepoRecord, error) {
+	record := RepoRecord{Repo: repo}
+	data, err := ioutil.ReadFile(s.pathFor(repo))
+	if os.IsNotExist(err) {
+		return record, nil
+	}
+	if err != nil {
+		return record, err
+	}
+
+	err = json.Unmarshal(data, &record)
+	if err != nil {
+		return RepoRecord{Repo: repo}, fmt.Errorf("unable to read the state of %s. Err: %v", repo, err)
+	}
+	return record, nil
+}
+
+// Record adds the attempt to the history of the repo and updates the summary fields.
+func (s *StateStore) Record(repo string, attempt SyncAttempt) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	record, err := s.Load(repo)
+	if err != nil {
+		return err
+	}
+
+	record.LastAttempt = attempt.Started
+	if len(attempt.States) > 0 {
+		record.State = attempt.States[len(attempt.States)-1]
+	}
+	if attempt.Error == "" {
+		record.LastSuccess = attempt.Started.Add(attempt.Duration)
+		record.FailingSince = time.Time{}
+		record.ConsecutiveFailures = 0
+	} else {
+		record.LastError = attempt.Error
+		record.LastErrorAt = attempt.Started
+	}
+	// A change held back for the user isn't a failure of the sync.
+	if attempt.Error != "" && attempt.Outcome != OutcomeBlocked && attempt.Outcome != OutcomeNeedsConfirmation {
+		if record.ConsecutiveFailures == 0 {
+			record.FailingSince = attempt.Started
+		}
+		record.ConsecutiveFailures++
+	}
+
+	record.History = append(record.History, attempt)
+	if len(record.History) > s.historyLimit {
+		record.History = record.History[len(record.History)-s.historyLimit:]
+	}
+
+	data, err := json.MarshalIndent(record, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomically(s.pathFor(repo), data)
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Submodules lists the absolute paths of the checked-out submodules. The submodules that aren't
+// initialized are left out.
+func Submodules(path string) ([]string, error) {
+	out, err := runCmd(path, "git", "submodule", "--quiet", "foreach", `printf '%s\0' "$sm_path"`)
+	if err != nil {
+		return nil, fmt.Errorf("unable to list the submodules. Out: %s, Err: %w", out, err)
+	}
+
+	var submodules []string
+	for _, sub := range strings.Split(out, "\x00") {
+		if sub != "" {
+			submodules = append(submodules, filepath.Join(path, sub))
+		}
+	}
+	return submodules, nil
+}
+
+// syncSubmodules syncs every submodule as its own repo, deepest first, so that the parent commits
+// pointers to pushed commits. A submodule on a detached HEAD has no branch to sync, so it's skipped.
+// The syncs of the submodules are part of the parent's, so they aren't recorded or notified about.
+func (g *GitCmd) syncSubmodules(path string) error {
+	submodules, err := Submodules(path)
+	if err != nil {
+		return err
+	}
+
+	for _, sub := range submodules {
+		status, err := GetStatus(sub, g.optionsFor(sub))
+		if err != nil {
+			return err
+		}
+		if status.Detached() {
+			g.logger(path).Printf("Skipped syncing the submodule %s: HEAD is detached. Check out a branch to sync it", sub)
+			continue
+		}
+
+		// A submodule with its own submodules option syncs them itself.
+		if !g.optionsFor(sub).Submodules {
+			err = g.syncSubmodules(sub)
+			if err != nil {
+				return err
+			}
+		}
+		err = g.sync(sub, &SyncAttempt{Started: time.Now()})
+		if errors.Is(err, ErrPaused) || errors.Is(err, ErrBusy) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("unable to sync the submodule %s. Err: %w", sub, err)
+		}
+	}
+	return nil
+}
+
+// pushSubmodules pushes the submodules that are ahead, deepest first, e.g. after a merge of the parent
+// brought in local submodule commits. The parent can't be pushed before them.
+func (g *GitCmd) pushSubmodules(path string) error {
+	submodules, err := Submodules(path)
+	if err != nil {
+		return err
+	}
+
+	for _, sub := range submodules {
+		err = g.pushSubmodules(sub)
+		if err != nil {
+			return err
+		}
+		status, err := GetStatus(sub, g.optionsFor(sub))
+		if err != nil {
+			return err
+		}
+		if status.State() != Ahead {
+			continue
+		}
+		err = Push(sub, g.optionsFor(sub), g.logger(sub))
+		if err != nil {
+			return fmt.Errorf("unable to push the submodule %s. Err: %w", sub, err)
+		}
+	}
+	return nil
+}
+
+// UpdateSubmodules brings the submodules to the commits that a merge of the parent recorded. The
+// commits are merged into the checked-out branches, so that no local commit is lost. The submodules
+// that aren't initialized are left alone.
+func UpdateSubmodules(path string) error {
+	return timeGitOp(path, "submodule", func() error {
+		out, err := runCmd(path, "git", "-c", "user.name='Git notes'", "-c", "user.email='git-notes@noemail.com'", "submodule", "update", "--recursive", "--merge")
+		if err != nil {
+			return fmt.Errorf("unable to update the submodules. Out: %s, Err: %w", out, err)
+		}
+		return nil
+	})
+}
+
+// SkipNestedRepos excludes the repos inside the work tree that aren't submodules in .git/info/exclude.
+// Otherwise, `git add --all` would commit them as broken submodules.
+func SkipNestedRepos(path string) error {
+	out, err := runCmd(path, "git", "-c", "core.quotePath=false", "ls-files", "--others", "--directory", "--exclude-standard", "-z")
+	if err != nil {
+		return fmt.Errorf("unable to list the untracked files. Out: %s, Err: %w", out, err)
+	}
+
+	var nested []string
+	for _, dir := range strings.Split(out, "\x00") {
+		if !strings.HasSuffix(dir, "/") {
+			continue
+		}
+		if _, err := os.Stat(filepath.Join(path, dir, ".git")); err == nil {
+			log.Printf("Skipped %s in %s: it's a git repo but not a submodule. Add it with `git submodule add` to sync it", dir, path)
+			nested = append(nested, "/"+dir)
+		}
+	}
+	if len(nested) == 0 {
+		return nil
+	}
+
+	out, err = runCmd(path, "git", "rev-parse", "--git-path", "info/exclude")
+	if err != nil {
+		return fmt.Errorf("unable to find info/exclude. Out: %s, Err: %w", out, err)
+	}
+	exclude := strings.TrimSpace(out)
+	if !filepath.IsAbs(exclude) {
+		exclude = filepath.Join(path, exclude)
+	}
+
+	existing, err := ioutil.ReadFile(exclude)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	content := string(existing)
+	if content != "" && !strings.HasSuffix(content, "\n") {
+		content += "\n"
+	}
+	content += "# Nested git repos skipped by git-notes\n" + strings.Join(nested, "\n") + "\n"
+
+	err = os.MkdirAll(filepath.Dir(exclude), 0755)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(exclude, []byte(content), 0644)
+}
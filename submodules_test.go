@@ -0,0 +1,163 @@
+package main
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/tanin47/git-notes/internal/test_helpers"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// setupSubmodule adds the repo of the glossary as a submodule of the notes and pushes both.
+func setupSubmodule(t *testing.T, notes test_helpers.Repos, glossary test_helpers.Repos) string {
+	// Git refuses to clone submodules from local paths by default.
+	os.Setenv("GIT_ALLOW_PROTOCOL", "file")
+
+	test_helpers.WriteFile(t, glossary.Local, "terms.md", "Terms")
+	performSync(t, glossary.Local)
+
+	test_helpers.WriteFile(t, notes.Local, "test.md", "TestContent")
+	test_helpers.PerformCmd(t, notes.Local, "git", "submodule", "add", glossary.Remote, "glossary")
+	test_helpers.PerformCmd(t, notes.Local, "git", "add", "--all")
+	performSync(t, notes.Local)
+	return filepath.Join(notes.Local, "glossary")
+}
+
+func TestSubmodules(t *testing.T) {
+	notes := test_helpers.SetupRepos()
+	defer test_helpers.CleanupRepos(notes)
+	glossary := test_helpers.SetupRepos()
+	defer test_helpers.CleanupRepos(glossary)
+	defer os.Unsetenv("GIT_ALLOW_PROTOCOL")
+	sub := setupSubmodule(t, notes, glossary)
+
+	submodules, err := Submodules(notes.Local)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{sub}, submodules)
+
+	submodules, err = Submodules(glossary.Local)
+	assert.NoError(t, err)
+	assert.Empty(t, submodules)
+}
+
+func TestGoGit_SyncSubmodules(t *testing.T) {
+	notes := test_helpers.SetupRepos()
+	defer test_helpers.CleanupRepos(notes)
+	glossary := test_helpers.SetupRepos()
+	defer test_helpers.CleanupRepos(glossary)
+	defer os.Unsetenv("GIT_ALLOW_PROTOCOL")
+	sub := setupSubmodule(t, notes, glossary)
+
+	gogit := GitCmd{options: map[string]RepoOptions{notes.Local: {Submodules: true}}}
+	test_helpers.WriteFile(t, sub, "terms.md", "Terms changed")
+	assert.NoError(t, gogit.Sync(notes.Local))
+	assertState(t, sub, Sync)
+	assertState(t, notes.Local, Sync)
+
+	pointer, err := runCmd(notes.Remote, "git", "rev-parse", "master:glossary")
+	assert.NoError(t, err)
+	assert.Equal(t, headCommit(sub), strings.TrimSpace(pointer))
+
+	// The submodule isn't a repo of its own in the state and the metrics.
+	store, err := DefaultStateStore()
+	assert.NoError(t, err)
+	record, err := store.Load(sub)
+	assert.NoError(t, err)
+	assert.Empty(t, record.History)
+	assert.Empty(t, metrics.State(sub))
+
+	// Another machine changes the glossary and the pointer.
+	test_helpers.PerformCmd(t, glossary.Local, "git", "pull", "origin", "master")
+	test_helpers.WriteFile(t, glossary.Local, "terms.md", "Terms from another machine")
+	performSync(t, glossary.Local)
+	another, err := ioutil.TempDir("", "git_test_another")
+	assert.NoError(t, err)
+	defer os.RemoveAll(another)
+	test_helpers.PerformCmd(t, another, "git", "clone", "--recurse-submodules", notes.Remote, ".")
+	test_helpers.PerformCmd(t, filepath.Join(another, "glossary"), "git", "pull", "origin", "master")
+	test_helpers.PerformCmd(t, another, "git", "commit", "-am", "Update the glossary")
+	test_helpers.PerformCmd(t, another, "git", "push", "origin", "master")
+
+	assert.NoError(t, gogit.Sync(notes.Local))
+	assertState(t, notes.Local, Sync)
+	content, err := ioutil.ReadFile(filepath.Join(sub, "terms.md"))
+	assert.NoError(t, err)
+	assert.Equal(t, "Terms from another machine", string(content))
+}
+
+func TestGoGit_LeavesUninitializedSubmodule(t *testing.T) {
+	notes := test_helpers.SetupRepos()
+	defer test_helpers.CleanupRepos(notes)
+	glossary := test_helpers.SetupRepos()
+	defer test_helpers.CleanupRepos(glossary)
+	defer os.Unsetenv("GIT_ALLOW_PROTOCOL")
+	sub := setupSubmodule(t, notes, glossary)
+	test_helpers.PerformCmd(t, notes.Local, "git", "submodule", "deinit", "glossary")
+
+	// Another machine changes the glossary and the pointer.
+	another, err := ioutil.TempDir("", "git_test_another")
+	assert.NoError(t, err)
+	defer os.RemoveAll(another)
+	test_helpers.PerformCmd(t, another, "git", "clone", "--recurse-submodules", notes.Remote, ".")
+	test_helpers.WriteFile(t, filepath.Join(another, "glossary"), "terms.md", "Terms from another machine")
+	test_helpers.PerformCmd(t, filepath.Join(another, "glossary"), "git", "commit", "-am", "Change the terms")
+	test_helpers.PerformCmd(t, filepath.Join(another, "glossary"), "git", "push", "origin", "HEAD:master")
+	test_helpers.PerformCmd(t, another, "git", "commit", "-am", "Update the glossary")
+	test_helpers.PerformCmd(t, another, "git", "push", "origin", "master")
+
+	gogit := GitCmd{options: map[string]RepoOptions{notes.Local: {Submodules: true}}}
+	test_helpers.WriteFile(t, notes.Local, "test.md", "Changed")
+	assert.NoError(t, gogit.Sync(notes.Local))
+	assertState(t, notes.Local, Sync)
+	entries, err := ioutil.ReadDir(sub)
+	assert.NoError(t, err)
+	assert.Empty(t, entries)
+}
+
+func TestGoGit_SkipsDetachedSubmodule(t *testing.T) {
+	notes := test_helpers.SetupRepos()
+	defer test_helpers.CleanupRepos(notes)
+	glossary := test_helpers.SetupRepos()
+	defer test_helpers.CleanupRepos(glossary)
+	defer os.Unsetenv("GIT_ALLOW_PROTOCOL")
+	sub := setupSubmodule(t, notes, glossary)
+
+	gogit := GitCmd{options: map[string]RepoOptions{notes.Local: {Submodules: true}}}
+	test_helpers.PerformCmd(t, sub, "git", "checkout", "--detach")
+	test_helpers.WriteFile(t, sub, "terms.md", "Detached change")
+	test_helpers.WriteFile(t, notes.Local, "test.md", "Changed")
+	assert.NoError(t, gogit.Sync(notes.Local))
+	assertState(t, sub, Busy)
+
+	status, err := GetStatus(notes.Local, RepoOptions{Submodules: true})
+	assert.NoError(t, err)
+	assert.Equal(t, Sync, status.State())
+}
+
+func TestSkipNestedRepos(t *testing.T) {
+	repos := test_helpers.SetupRepos()
+	defer test_helpers.CleanupRepos(repos)
+
+	nested := filepath.Join(repos.Local, "nested")
+	assert.NoError(t, os.Mkdir(nested, 0755))
+	test_helpers.PerformCmd(t, nested, "git", "init")
+	test_helpers.WriteFile(t, nested, "inner.md", "Inner")
+	test_helpers.WriteFile(t, repos.Local, "test.md", "TestContent")
+	performSync(t, repos.Local)
+	assertState(t, repos.Local, Sync)
+
+	files, err := runCmd(repos.Local, "git", "ls-files")
+	assert.NoError(t, err)
+	assert.Equal(t, "test.md\n", files)
+	exclude, err := ioutil.ReadFile(filepath.Join(repos.Local, ".git", "info", "exclude"))
+	assert.NoError(t, err)
+	assert.True(t, strings.HasSuffix(string(exclude), "\n# Nested git repos skipped by git-notes\n/nested/\n"), string(exclude))
+
+	// The repo isn't listed again once it's excluded.
+	assert.NoError(t, SkipNestedRepos(repos.Local))
+	exclude2, err := ioutil.ReadFile(filepath.Join(repos.Local, ".git", "info", "exclude"))
+	assert.NoError(t, err)
+	assert.Equal(t, string(exclude), string(exclude2))
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"log"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+var ErrSyncing = errors.New("another git-notes process is syncing the repo")
+
+// lockSync takes the lock of the repo in the state directory, so that the daemon and the dashboard
+// never sync the same repo at once. The lock goes away with the process that holds it.
+func lockSync(path string) (func(), error) {
+	return lockRepoFile(path, syscall.LOCK_NB)
+}
+
+func lockRepoFile(path string, flags int) (func(), error) {
+	dir, err := StateDir()
+	if err != nil {
+		return nil, err
+	}
+	err = os.MkdirAll(filepath.Join(dir, "locks"), 0700)
+	if err != nil {
+		return nil, err
+	}
+
+	unlock, err := lockFile(filepath.Join(dir, "locks", repoFileName(path)+".lock"), flags)
+	if errors.Is(err, syscall.EWOULDBLOCK) {
+		return nil, ErrSyncing
+	}
+	return unlock, err
+}
+
+// lockRepo takes the lock of the repo for a command that changes it, e.g. restore, so that the daemon
+// doesn't commit or merge halfway through. It waits for the sync in progress to finish.
+func lockRepo(path string) (func(), error) {
+	unlock, err := lockSync(path)
+	if !errors.Is(err, ErrSyncing) {
+		return unlock, err
+	}
+	log.Printf("Waiting for the sync of %s to finish", path)
+	return lockRepoFile(path, 0)
+}
+
+// repoFileName names the files of a repo in the state directory, e.g. its lock, after a hash of its path.
+func repoFileName(path string) string {
+	sum := sha1.Sum([]byte(path))
+	return hex.EncodeToString(sum[:8])
+}
+
+// lockFile takes an exclusive flock on the file, creating it if needed. With syscall.LOCK_NB, it fails
+// with syscall.EWOULDBLOCK instead of waiting for another holder.
+func lockFile(path string, flags int) (func(), error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return nil, err
+	}
+	err = syscall.Flock(int(file.Fd()), syscall.LOCK_EX|flags)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	return func() {
+		_ = syscall.Flock(int(file.Fd()), syscall.LOCK_UN)
+		file.Close()
+	}, nil
+}
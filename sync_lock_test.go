@@ -0,0 +1,47 @@
+package main
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestLockSync(t *testing.T) {
+	unlock, err := lockSync("/notes")
+	assert.NoError(t, err)
+
+	_, err = lockSync("/notes")
+	assert.Equal(t, ErrSyncing, err)
+	other, err := lockSync("/other-notes")
+	assert.NoError(t, err)
+	other()
+
+	unlock()
+	unlock, err = lockSync("/notes")
+	assert.NoError(t, err)
+	unlock()
+}
+
+func TestLockRepo(t *testing.T) {
+	unlock, err := lockSync("/notes")
+	assert.NoError(t, err)
+
+	locked := make(chan func())
+	go func() {
+		unlock, err := lockRepo("/notes")
+		assert.NoError(t, err)
+		locked <- unlock
+	}()
+
+	select {
+	case <-locked:
+		t.Fatal("the lock was taken during the sync")
+	case <-time.After(50 * time.Millisecond):
+	}
+	unlock()
+
+	unlock = <-locked
+	_, err = lockSync("/notes")
+	assert.Equal(t, ErrSyncing, err)
+	unlock()
+}
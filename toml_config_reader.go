@@ -0,0 +1,68 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/BurntSushi/toml"
+	"io/ioutil"
+	"regexp"
+	"strings"
+)
+
+type TomlConfigReader struct{}
+
+func (c *TomlConfigReader) Read(path string) (*Config, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var config Config
+	metadata, err := toml.Decode(string(data), &config)
+	if err != nil {
+		var parseErr toml.ParseError
+		if errors.As(err, &parseErr) {
+			return nil, &ConfigError{Path: path, Line: parseErr.Position.Line, Column: parseErr.Position.Col, Message: parseErr.Message}
+		}
+		return nil, &ConfigError{Path: path, Message: err.Error()}
+	}
+
+	var errs ConfigErrors
+	reported := map[string]bool{}
+	for _, key := range metadata.Undecoded() {
+		// The keys inside an unknown table are undecoded too. Reporting the table is enough.
+		if len(key) > 1 && reported[key[:len(key)-1].String()] {
+			reported[key.String()] = true
+			continue
+		}
+		reported[key.String()] = true
+
+		line, column := locateTomlKey(data, key)
+		errs = append(errs, &ConfigError{Path: path, Line: line, Column: column, Message: fmt.Sprintf("unknown key %q", key.String())})
+	}
+	if len(errs) > 0 {
+		return nil, errs
+	}
+
+	return &config, config.Validate(path)
+}
+
+// locateTomlKey finds where a key is defined. The TOML decoder doesn't keep the positions of
+// undecoded keys, so we look for the key as an assignment or as a table header.
+func locateTomlKey(data []byte, key toml.Key) (int, int) {
+	last := regexp.QuoteMeta(key[len(key)-1])
+	full := regexp.QuoteMeta(strings.Join(key, "."))
+	patterns := []*regexp.Regexp{
+		regexp.MustCompile(`(?m)^[ \t]*\[\[?[ \t]*` + full + `[ \t]*\]`),
+		regexp.MustCompile(`(?m)^[ \t]*(` + full + `|` + last + `)[ \t]*=`),
+	}
+
+	for _, pattern := range patterns {
+		match := pattern.FindIndex(data)
+		if match != nil {
+			start := match[0] + len(data[match[0]:match[1]]) - len(strings.TrimLeft(string(data[match[0]:match[1]]), " \t"))
+			return position(data, start)
+		}
+	}
+	return 0, 0
+}
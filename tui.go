@@ -0,0 +1,469 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	dashboardRefreshInterval = 5 * time.Second
+	dashboardLogLines        = 10
+)
+
+func init() {
+	commands["tui"] = Command{
+		Usage: "tui <config-file>",
+		Run:   runTui,
+	}
+}
+
+// RepoRow is a repo as the dashboard shows it. The state is computed locally against the remote
+// branch as of the last fetch, so that refreshing doesn't hit the network.
+type RepoRow struct {
+	Repo      string
+	State     State
+	LastSync  time.Time
+	Dirty     bool
+	Ahead     int
+	Behind    int
+	Conflicts int
+	Error     string
+}
+
+// Dashboard is the model of `git-notes tui`. It reads the state that the daemon keeps in the state
+// directory, so it works whether the daemon is running or not. A sync started from the dashboard is
+// handed to the daemon when it's running, and runs in the dashboard's process otherwise, taking the
+// same lock of the repo as the daemon's syncs.
+type Dashboard struct {
+	git       *GitCmd
+	repos     []string
+	stateDir  string
+	states    *StateStore
+	pauses    *PauseStore
+	conflicts *ConflictStore
+	// daemon is nil if the daemon can't be reached, i.e. the config has no metrics address.
+	daemon *DaemonClient
+	now    func() time.Time
+	// suspend runs fn while the dashboard is off the screen, e.g. to open an editor.
+	suspend func(fn func() error) error
+
+	rows          []RepoRow
+	daemonRunning bool
+	selected      int
+	showLog       bool
+	message       string
+}
+
+func NewDashboard(git *GitCmd, repos []string) (*Dashboard, error) {
+	dir, err := StateDir()
+	if err != nil {
+		return nil, err
+	}
+	states, err := DefaultStateStore()
+	if err != nil {
+		return nil, err
+	}
+	return &Dashboard{
+		git:       git,
+		repos:     repos,
+		stateDir:  dir,
+		states:    states,
+		pauses:    NewPauseStore(dir),
+		conflicts: NewConflictStore(dir),
+		now:       time.Now,
+		suspend:   func(fn func() error) error { return fn() },
+	}, nil
+}
+
+func (d *Dashboard) loadRow(repo string) RepoRow {
+	row := RepoRow{Repo: repo}
+	record, err := d.states.Load(repo)
+	if err == nil {
+		row.LastSync = record.LastSuccess
+		if record.ConsecutiveFailures > 0 {
+			row.Error = record.LastError
+		}
+	}
+
+	status, err := d.git.Status(repo)
+	row.State = status.State()
+	if err != nil {
+		row.State = Error
+		row.Error = err.Error()
+	} else if d.git.busy(repo, status) {
+		row.State = Busy
+	}
+	// A commit held back by the checks stays staged, so the repo looks dirty.
+	if row.State == Dirty && (record.State == Blocked || record.State == NeedsConfirmation) {
+		row.State = record.State
+	}
+	if _, paused, err := d.pauses.Paused(repo, d.now()); err == nil && paused {
+		row.State = Paused
+	}
+
+	row.Dirty = status.Dirty()
+	row.Ahead, row.Behind = status.Ahead, status.Behind
+	if conflicts, err := UnresolvedConflicts(d.conflicts, repo); err == nil {
+		row.Conflicts = len(conflicts)
+	}
+	return row
+}
+
+func (d *Dashboard) Refresh() {
+	var rows []RepoRow
+	for _, repo := range d.repos {
+		rows = append(rows, d.loadRow(repo))
+	}
+	d.rows = rows
+	d.daemonRunning = d.daemon != nil && d.daemon.Running()
+	if d.selected >= len(rows) {
+		d.selected = len(rows) - 1
+	}
+	if d.selected < 0 {
+		d.selected = 0
+	}
+}
+
+func (d *Dashboard) selectedRepo() (string, bool) {
+	if len(d.rows) == 0 {
+		return "", false
+	}
+	return d.rows[d.selected].Repo, true
+}
+
+// HandleKey runs the action of the key and returns whether the dashboard should quit.
+func (d *Dashboard) HandleKey(key string) bool {
+	switch key {
+	case "q", "ctrl-c":
+		return true
+	case "up", "k":
+		if d.selected > 0 {
+			d.selected--
+		}
+		return false
+	case "down", "j":
+		if d.selected < len(d.rows)-1 {
+			d.selected++
+		}
+		return false
+	case "l":
+		d.showLog = !d.showLog
+		return false
+	case "r":
+		d.message = ""
+		d.Refresh()
+		return false
+	}
+
+	repo, ok := d.selectedRepo()
+	if !ok {
+		return false
+	}
+	switch key {
+	case "s":
+		d.sync(repo)
+	case "p":
+		d.togglePause(repo)
+	case "c":
+		d.openConflict(repo)
+	default:
+		return false
+	}
+	d.Refresh()
+	return false
+}
+
+func (d *Dashboard) sync(repo string) {
+	// The dashboard syncs the repo itself if the daemon doesn't.
+	if d.daemon != nil {
+		if err := d.daemon.RequestSync(repo); err == nil {
+			d.message = fmt.Sprintf("Asked the daemon to sync %s", repo)
+			return
+		}
+	}
+
+	err := d.git.Sync(repo)
+	if err != nil {
+		d.message = fmt.Sprintf("Syncing %s failed: %v", repo, err)
+		return
+	}
+	// A repo that the daemon is syncing, or that you are using by hand, is left alone.
+	switch state := metrics.State(repo); state {
+	case Busy, Paused, Blocked, NeedsConfirmation:
+		d.message = fmt.Sprintf("Didn't sync %s: it's %s", repo, state)
+		return
+	}
+	d.message = fmt.Sprintf("Synced %s", repo)
+}
+
+func (d *Dashboard) togglePause(repo string) {
+	_, paused, err := d.pauses.Paused(repo, d.now())
+	if err == nil {
+		if paused {
+			err = d.pauses.Resume(repo)
+			d.message = fmt.Sprintf("Resumed %s", repo)
+		} else {
+			err = d.pauses.Pause(repo, Pause{Since: d.now()})
+			d.message = fmt.Sprintf("Paused %s", repo)
+		}
+	}
+	if err != nil {
+		d.message = fmt.Sprintf("Unable to pause or resume %s: %v", repo, err)
+	}
+}
+
+// openConflict opens the first unresolved conflict of the repo in the editor.
+func (d *Dashboard) openConflict(repo string) {
+	conflicts, err := UnresolvedConflicts(d.conflicts, repo)
+	if err != nil {
+		d.message = err.Error()
+		return
+	}
+	if len(conflicts) == 0 {
+		d.message = fmt.Sprintf("%s has no unresolved conflicts", repo)
+		return
+	}
+
+	file := conflicts[0].File
+	err = d.suspend(func() error {
+		return ResolveConflict(repo, file, "edit")
+	})
+	if err != nil {
+		d.message = err.Error()
+		return
+	}
+	d.message = fmt.Sprintf("Resolved %s", file)
+}
+
+func lastLines(lines []string, n int) []string {
+	if len(lines) > n {
+		return lines[len(lines)-n:]
+	}
+	return lines
+}
+
+// formatAgo formats the time since t in the largest whole unit.
+func formatAgo(t time.Time, now time.Time) string {
+	if t.IsZero() {
+		return "never"
+	}
+	since := now.Sub(t)
+	switch {
+	case since < time.Minute:
+		return "just now"
+	case since < time.Hour:
+		return fmt.Sprintf("%dm ago", int(since.Minutes()))
+	case since < 24*time.Hour:
+		return fmt.Sprintf("%dh ago", int(since.Hours()))
+	}
+	return t.Local().Format("2006-01-02")
+}
+
+func shortenHome(path string) string {
+	if home, err := os.UserHomeDir(); err == nil && isInside(path, home) {
+		return "~" + path[len(home):]
+	}
+	return path
+}
+
+func truncate(line string, width int) string {
+	runes := []rune(line)
+	if width > 0 && len(runes) > width {
+		return string(runes[:width])
+	}
+	return line
+}
+
+// Lines renders the dashboard as plain text. The selected repo starts with `>`.
+func (d *Dashboard) Lines(width int) []string {
+	now := d.now()
+	header := fmt.Sprintf("git-notes  %d repos  %s", len(d.rows), now.Local().Format("15:04:05"))
+	if d.daemon != nil {
+		if d.daemonRunning {
+			header += "  daemon running"
+		} else {
+			header += "  daemon not running"
+		}
+	}
+	lines := []string{header, ""}
+
+	repoWidth := len("REPO")
+	for _, row := range d.rows {
+		if len(shortenHome(row.Repo)) > repoWidth {
+			repoWidth = len(shortenHome(row.Repo))
+		}
+	}
+	format := fmt.Sprintf("%%-2s%%-%ds  %%-18s  %%-9s  %%-7s  %%-12s  %%-9s  %%s", repoWidth)
+	lines = append(lines, fmt.Sprintf(format, "", "REPO", "STATE", "LAST SYNC", "CHANGES", "AHEAD/BEHIND", "CONFLICTS", "ERROR"))
+	for i, row := range d.rows {
+		cursor := ""
+		if i == d.selected {
+			cursor = ">"
+		}
+		changes := "-"
+		if row.Dirty {
+			changes = "yes"
+		}
+		conflicts := "-"
+		if row.Conflicts > 0 {
+			conflicts = strconv.Itoa(row.Conflicts)
+		}
+		lines = append(lines, fmt.Sprintf(format, cursor, shortenHome(row.Repo), row.State, formatAgo(row.LastSync, now),
+			changes, fmt.Sprintf("%d/%d", row.Ahead, row.Behind), conflicts, strings.SplitN(row.Error, "\n", 2)[0]))
+	}
+
+	if repo, ok := d.selectedRepo(); ok && d.showLog {
+		lines = append(lines, "", fmt.Sprintf("Log of %s", shortenHome(repo)))
+		tail, err := NewRepoLog(d.stateDir, repo).Tail(dashboardLogLines)
+		if err != nil {
+			tail = []string{err.Error()}
+		}
+		lines = append(lines, tail...)
+	}
+
+	lines = append(lines, "", d.message, "↑/↓ select  s sync  p pause/resume  c open conflict  l log  r refresh  q quit")
+	for i := range lines {
+		lines[i] = truncate(lines[i], width)
+	}
+	return lines
+}
+
+func stty(args ...string) (string, error) {
+	cmd := exec.Command("stty", args...)
+	cmd.Stdin = os.Stdin
+	out, err := cmd.Output()
+	return strings.TrimSpace(string(out)), err
+}
+
+// enterTerminal switches to the alternate screen and reads the keys one by one without echoing them.
+// A read returns after a second without a key, so that the dashboard refreshes, and only the main
+// loop reads, so that an editor started from the dashboard gets all the keys.
+func enterTerminal() (func(), error) {
+	saved, err := stty("-g")
+	if err != nil {
+		return nil, fmt.Errorf("the dashboard needs a terminal. Err: %v", err)
+	}
+	_, err = stty("-icanon", "-echo", "-isig", "min", "0", "time", "10")
+	if err != nil {
+		return nil, err
+	}
+	fmt.Fprint(os.Stdout, "\x1b[?1049h\x1b[?25l")
+	return func() {
+		fmt.Fprint(os.Stdout, "\x1b[?25h\x1b[?1049l")
+		_, _ = stty(saved)
+	}, nil
+}
+
+func terminalWidth() int {
+	out, err := stty("size")
+	if err == nil {
+		if fields := strings.Fields(out); len(fields) == 2 {
+			if width, err := strconv.Atoi(fields[1]); err == nil {
+				return width
+			}
+		}
+	}
+	return 80
+}
+
+// readKey returns an empty string when no key was pressed.
+func readKey(r io.Reader) string {
+	buf := make([]byte, 8)
+	n, err := r.Read(buf)
+	if err != nil || n == 0 {
+		return ""
+	}
+	switch key := string(buf[:n]); key {
+	case "\x1b[A", "\x1bOA":
+		return "up"
+	case "\x1b[B", "\x1bOB":
+		return "down"
+	case "\x03":
+		return "ctrl-c"
+	default:
+		return key[:1]
+	}
+}
+
+func drawDashboard(w io.Writer, lines []string, selected int) {
+	var screen strings.Builder
+	screen.WriteString("\x1b[H")
+	for i, line := range lines {
+		// The repos start at the fourth line.
+		if i == selected+3 {
+			line = "\x1b[7m" + line + "\x1b[0m"
+		}
+		screen.WriteString(line + "\x1b[K\r\n")
+	}
+	screen.WriteString("\x1b[J")
+	fmt.Fprint(w, screen.String())
+}
+
+func runTui(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: git-notes %s", commands["tui"].Usage)
+	}
+	config, err := (&FileConfigReader{}).Read(args[0])
+	if err != nil {
+		return err
+	}
+
+	git := NewGoGit()
+	git.Configure(config)
+	// The syncs log to the repo logs alone, which the dashboard shows, so that they don't draw over it.
+	git.output = ioutil.Discard
+	dashboard, err := NewDashboard(&git, config.Repos)
+	if err != nil {
+		return err
+	}
+	dashboard.daemon = NewDaemonClient(config.MetricsAddress)
+
+	restore, err := enterTerminal()
+	if err != nil {
+		return err
+	}
+	// The restore func changes after an editor has been opened.
+	defer func() { restore() }()
+	oldLog := log.Writer()
+	log.SetOutput(ioutil.Discard)
+	defer log.SetOutput(oldLog)
+
+	dashboard.suspend = func(fn func() error) error {
+		restore()
+		log.SetOutput(oldLog)
+		defer func() {
+			log.SetOutput(ioutil.Discard)
+			// If the terminal can't be set up again, the old restore func still resets it on exit.
+			if entered, err := enterTerminal(); err == nil {
+				restore = entered
+			}
+		}()
+		return fn()
+	}
+
+	dashboard.Refresh()
+	refreshed := time.Now()
+	for {
+		if time.Since(refreshed) >= dashboardRefreshInterval {
+			dashboard.Refresh()
+			refreshed = time.Now()
+		}
+		drawDashboard(os.Stdout, dashboard.Lines(terminalWidth()), dashboard.selected)
+
+		key := readKey(os.Stdin)
+		if key == "" {
+			continue
+		}
+		if dashboard.HandleKey(key) {
+			return nil
+		}
+		refreshed = time.Now()
+	}
+}
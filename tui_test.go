@@ -0,0 +1,195 @@
+package main
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/tanin47/git-notes/internal/test_helpers"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFormatAgo(t *testing.T) {
+	now := time.Date(2020, 1, 2, 10, 0, 0, 0, time.Local)
+	assert.Equal(t, "never", formatAgo(time.Time{}, now))
+	assert.Equal(t, "just now", formatAgo(now.Add(-30*time.Second), now))
+	assert.Equal(t, "5m ago", formatAgo(now.Add(-5*time.Minute), now))
+	assert.Equal(t, "3h ago", formatAgo(now.Add(-3*time.Hour-10*time.Minute), now))
+	assert.Equal(t, "2019-12-30", formatAgo(now.Add(-72*time.Hour), now))
+}
+
+func TestReadKey(t *testing.T) {
+	assert.Equal(t, "up", readKey(strings.NewReader("\x1b[A")))
+	assert.Equal(t, "down", readKey(strings.NewReader("\x1bOB")))
+	assert.Equal(t, "ctrl-c", readKey(strings.NewReader("\x03")))
+	assert.Equal(t, "s", readKey(strings.NewReader("s")))
+	assert.Equal(t, "", readKey(strings.NewReader("")))
+}
+
+func setupDashboard(t *testing.T, repos ...string) *Dashboard {
+	dashboard, err := NewDashboard(&GitCmd{}, repos)
+	assert.NoError(t, err)
+	dashboard.Refresh()
+	return dashboard
+}
+
+func dashboardLine(dashboard *Dashboard, prefix string) string {
+	for _, line := range dashboard.Lines(0) {
+		if strings.HasPrefix(line, prefix) {
+			return line
+		}
+	}
+	return ""
+}
+
+func TestDashboard_Rows(t *testing.T) {
+	repos := test_helpers.SetupRepos()
+	defer test_helpers.CleanupRepos(repos)
+	test_helpers.WriteFile(t, repos.Local, "test.md", "TestContent")
+	performSync(t, repos.Local)
+
+	dashboard := setupDashboard(t, repos.Local)
+	assert.Equal(t, Sync, dashboard.rows[0].State)
+	assert.False(t, dashboard.rows[0].Dirty)
+	assert.WithinDuration(t, time.Now(), dashboard.rows[0].LastSync, time.Minute)
+	assert.Contains(t, dashboardLine(dashboard, "> "), "just now")
+
+	test_helpers.WriteFile(t, repos.Local, "test.md", "Changed")
+	dashboard.Refresh()
+	assert.Equal(t, Dirty, dashboard.rows[0].State)
+	assert.True(t, dashboard.rows[0].Dirty)
+
+	makeConflict(t, repos.Remote)
+	test_helpers.PerformCmd(t, repos.Local, "git", "fetch")
+	test_helpers.PerformCmd(t, repos.Local, "git", "commit", "-am", "Local")
+	dashboard.Refresh()
+	assert.Equal(t, OutOfSync, dashboard.rows[0].State)
+	assert.Equal(t, 1, dashboard.rows[0].Ahead)
+	assert.Equal(t, 1, dashboard.rows[0].Behind)
+	assert.Contains(t, dashboardLine(dashboard, "> "), "1/1")
+}
+
+func TestDashboard_Keys(t *testing.T) {
+	repos := test_helpers.SetupRepos()
+	defer test_helpers.CleanupRepos(repos)
+	another := test_helpers.SetupRepos()
+	defer test_helpers.CleanupRepos(another)
+
+	dashboard := setupDashboard(t, repos.Local, another.Local)
+	assert.Equal(t, 0, dashboard.selected)
+	assert.False(t, dashboard.HandleKey("down"))
+	assert.False(t, dashboard.HandleKey("down"))
+	assert.Equal(t, 1, dashboard.selected)
+	assert.False(t, dashboard.HandleKey("k"))
+	assert.Equal(t, 0, dashboard.selected)
+
+	dashboard.HandleKey("p")
+	assert.Equal(t, Paused, dashboard.rows[0].State)
+	assert.Equal(t, "Paused "+repos.Local, dashboard.message)
+	dashboard.HandleKey("p")
+	assert.Equal(t, "Resumed "+repos.Local, dashboard.message)
+	assert.NotEqual(t, Paused, dashboard.rows[0].State)
+
+	test_helpers.WriteFile(t, repos.Local, "test.md", "TestContent")
+	dashboard.HandleKey("s")
+	assert.Equal(t, "Synced "+repos.Local, dashboard.message)
+	assert.Equal(t, Sync, dashboard.rows[0].State)
+
+	// The daemon is syncing the repo.
+	unlock, err := lockSync(repos.Local)
+	assert.NoError(t, err)
+	test_helpers.WriteFile(t, repos.Local, "test.md", "Changed")
+	dashboard.HandleKey("s")
+	unlock()
+	assert.Equal(t, "Didn't sync "+repos.Local+": it's busy", dashboard.message)
+	assert.Equal(t, Dirty, dashboard.rows[0].State)
+
+	dashboard.HandleKey("l")
+	lines := strings.Join(dashboard.Lines(0), "\n")
+	assert.Contains(t, lines, "Log of ")
+	assert.Contains(t, lines, "Leaving "+repos.Local+" alone: another git-notes process is syncing the repo")
+	// The other repo has never been synced, so its log is empty.
+	dashboard.HandleKey("down")
+	assert.NotContains(t, strings.Join(dashboard.Lines(0), "\n"), "Leaving ")
+	dashboard.HandleKey("up")
+
+	dashboard.HandleKey("c")
+	assert.Equal(t, repos.Local+" has no unresolved conflicts", dashboard.message)
+	assert.True(t, dashboard.HandleKey("q"))
+}
+
+func TestDashboard_OpenConflict(t *testing.T) {
+	repos := test_helpers.SetupRepos()
+	defer test_helpers.CleanupRepos(repos)
+	setupConflict(t, repos)
+
+	dashboard := setupDashboard(t, repos.Local)
+	assert.Equal(t, 1, dashboard.rows[0].Conflicts)
+
+	oldVisual := os.Getenv("VISUAL")
+	defer os.Setenv("VISUAL", oldVisual)
+	os.Setenv("VISUAL", "printf 'Edited\\n' >")
+	suspended := false
+	dashboard.suspend = func(fn func() error) error {
+		suspended = true
+		return fn()
+	}
+
+	dashboard.HandleKey("c")
+	assert.True(t, suspended)
+	assert.Equal(t, "Resolved test.md", dashboard.message)
+	assert.Equal(t, 0, dashboard.rows[0].Conflicts)
+	assert.Equal(t, Ahead, dashboard.rows[0].State)
+}
+
+func TestDashboard_RefreshOnlyReads(t *testing.T) {
+	repos := test_helpers.SetupRepos()
+	defer test_helpers.CleanupRepos(repos)
+	setupConflict(t, repos)
+	test_helpers.WriteFile(t, repos.Local, "test.md", "Fixed by hand")
+
+	dashboard := setupDashboard(t, repos.Local)
+	assert.Equal(t, 0, dashboard.rows[0].Conflicts)
+	recorded, err := dashboard.conflicts.Load()
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(recorded[repos.Local]))
+
+	// The sync forgets the conflict that was resolved by hand.
+	performSync(t, repos.Local)
+	recorded, err = dashboard.conflicts.Load()
+	assert.NoError(t, err)
+	assert.Empty(t, recorded[repos.Local])
+}
+
+func TestDashboard_Truncate(t *testing.T) {
+	dashboard := setupDashboard(t)
+	for _, line := range dashboard.Lines(10) {
+		assert.LessOrEqual(t, len([]rune(line)), 10)
+	}
+}
+
+func TestDashboard_SyncThroughDaemon(t *testing.T) {
+	repos := test_helpers.SetupRepos()
+	defer test_helpers.CleanupRepos(repos)
+	server, requester, client := setupDaemon(t, repos.Local)
+	defer server.Close()
+
+	dashboard := setupDashboard(t, repos.Local)
+	dashboard.daemon = client
+	dashboard.Refresh()
+	assert.Contains(t, dashboard.Lines(0)[0], "daemon running")
+
+	test_helpers.WriteFile(t, repos.Local, "test.md", "TestContent")
+	dashboard.HandleKey("s")
+	assert.Equal(t, "Asked the daemon to sync "+repos.Local, dashboard.message)
+	assert.Equal(t, []string{repos.Local}, requester.requested)
+	assert.Equal(t, Dirty, dashboard.rows[0].State)
+
+	// Without the daemon, the dashboard syncs the repo itself.
+	server.Close()
+	dashboard.Refresh()
+	assert.Contains(t, dashboard.Lines(0)[0], "daemon not running")
+	dashboard.HandleKey("s")
+	assert.Equal(t, "Synced "+repos.Local, dashboard.message)
+	assert.Equal(t, Sync, dashboard.rows[0].State)
+}
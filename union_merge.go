@@ -0,0 +1,179 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const unionMergeDriver = "git-notes-union"
+
+func init() {
+	commands["union-merge"] = Command{Hidden: true, Run: runUnionMerge}
+}
+
+// runUnionMerge is the merge driver of the append-only files. Git passes the base, ours, and theirs,
+// and expects the result in ours.
+func runUnionMerge(args []string) error {
+	if len(args) != 5 {
+		return errors.New("usage: union-merge <base> <ours> <theirs> <marker-size> <path>")
+	}
+	base, ours, theirs, path := args[0], args[1], args[2], args[4]
+	markerSize, err := strconv.Atoi(args[3])
+	if err != nil {
+		return fmt.Errorf("%s is not a marker size", args[3])
+	}
+
+	var contents [][]byte
+	for _, file := range []string{base, ours, theirs} {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		contents = append(contents, data)
+	}
+	// git's own union merge keeps both sides' lines too, but without ordering the entries.
+	if tooLargeToMerge(contents...) {
+		out, err := runCmd("", "git", "merge-file", "--union", ours, base, theirs)
+		if err != nil {
+			return fmt.Errorf("unable to merge %s. Out: %s, Err: %v", path, out, err)
+		}
+		return nil
+	}
+
+	merged, conflicts := MergeUnion(contents[0], contents[1], contents[2], markerSize, path)
+	err = ioutil.WriteFile(ours, merged, 0644)
+	if err != nil {
+		return err
+	}
+	if conflicts {
+		return fmt.Errorf("%s has conflicts", path)
+	}
+	return nil
+}
+
+// journalTimestamp matches an entry that starts with a date, a date and a time, or a time, optionally
+// after a heading, a list marker, or emphasis, e.g. `## 2024-05-01 09:30`, `- 14:05 lunch`, or
+// `**2024-05-01T09:30:00**`.
+var journalTimestamp = regexp.MustCompile(`^[#>*_\-\s\[]*(?:(\d{4}-\d{2}-\d{2})(?:[T ](\d{1,2}:\d{2}(?::\d{2})?))?|(\d{1,2}:\d{2}(?::\d{2})?))\b`)
+
+// entryTimestamp returns the date and the time that start the line. Either can be empty.
+func entryTimestamp(line string) (string, string, bool) {
+	matches := journalTimestamp.FindStringSubmatch(line)
+	if matches == nil {
+		return "", "", false
+	}
+	clock := matches[2]
+	if clock == "" {
+		clock = matches[3]
+	}
+	if len(clock) > 0 && strings.Index(clock, ":") == 1 {
+		clock = "0" + clock
+	}
+	if len(clock) == 5 {
+		clock += ":00"
+	}
+	return matches[1], clock, true
+}
+
+// journalEntry is a block of appended lines. It starts at a timestamp and goes until the next one. key
+// sorts the entries by their timestamps. An entry without a date takes the date of the entry before it.
+type journalEntry struct {
+	lines []string
+	key   string
+}
+
+// journalEntries splits the appended lines into entries. date and clock are the timestamp of the last
+// entry before the appended lines, which the first lines take if they have no timestamp.
+func journalEntries(lines []string, date string, clock string) []journalEntry {
+	var entries []journalEntry
+	for _, line := range lines {
+		if d, c, ok := entryTimestamp(line); ok || len(entries) == 0 {
+			if ok {
+				if d != "" {
+					date = d
+				}
+				clock = c
+			}
+			entries = append(entries, journalEntry{key: date + " " + clock})
+		}
+		last := &entries[len(entries)-1]
+		last.lines = append(last.lines, line)
+	}
+	return entries
+}
+
+// lastTimestamp returns the timestamp of the last entry of the lines.
+func lastTimestamp(lines []string) (string, string) {
+	date, clock := "", ""
+	for _, line := range lines {
+		if d, c, ok := entryTimestamp(line); ok {
+			if d != "" {
+				date = d
+			}
+			clock = c
+		}
+	}
+	return date, clock
+}
+
+// appended returns the lines that the side added after base, or false if the side changed base. The
+// last line of base might have got a line ending.
+func appended(base []string, side []string) ([]string, bool) {
+	if len(side) < len(base) {
+		return nil, false
+	}
+	for i := range base {
+		if side[i] != base[i] && !(i == len(base)-1 && side[i] == base[i]+"\n") {
+			return nil, false
+		}
+	}
+	return side[len(base):], true
+}
+
+// MergeUnion keeps both sides' appended entries, ordered by their timestamps. Each side's entries stay
+// in their order, and an entry appended by both sides is kept once. If a side changed more than the
+// end of the file, the file is merged line by line like MergeText.
+func MergeUnion(base []byte, ours []byte, theirs []byte, markerSize int, label string) ([]byte, bool) {
+	baseLines := splitLinesKeepEnds(base)
+	oursAppended, oursOk := appended(baseLines, splitLinesKeepEnds(ours))
+	theirsAppended, theirsOk := appended(baseLines, splitLinesKeepEnds(theirs))
+	if !oursOk || !theirsOk {
+		return MergeText(base, ours, theirs, markerSize, label)
+	}
+
+	date, clock := lastTimestamp(baseLines)
+	oursEntries := journalEntries(withFinalNewline(oursAppended), date, clock)
+	theirsEntries := journalEntries(withFinalNewline(theirsAppended), date, clock)
+
+	seen := map[string]bool{}
+	for _, entry := range oursEntries {
+		seen[strings.Join(entry.lines, "")] = true
+	}
+	var unique []journalEntry
+	for _, entry := range theirsEntries {
+		if !seen[strings.Join(entry.lines, "")] {
+			unique = append(unique, entry)
+		}
+	}
+	theirsEntries = unique
+
+	result := withFinalNewline(baseLines)
+	if len(oursEntries) == 0 && len(theirsEntries) == 0 {
+		result = baseLines
+	}
+	i, j := 0, 0
+	for i < len(oursEntries) || j < len(theirsEntries) {
+		if j == len(theirsEntries) || (i < len(oursEntries) && oursEntries[i].key <= theirsEntries[j].key) {
+			result = append(result, oursEntries[i].lines...)
+			i++
+		} else {
+			result = append(result, theirsEntries[j].lines...)
+			j++
+		}
+	}
+	return []byte(strings.Join(result, "")), false
+}
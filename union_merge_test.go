@@ -0,0 +1,125 @@
+package main
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/tanin47/git-notes/internal/test_helpers"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func mergeUnion(base string, ours string, theirs string) (string, bool) {
+	merged, conflicts := MergeUnion([]byte(base), []byte(ours), []byte(theirs), 7, "journal.md")
+	return string(merged), conflicts
+}
+
+func TestEntryTimestamp(t *testing.T) {
+	cases := []struct {
+		line  string
+		date  string
+		clock string
+		ok    bool
+	}{
+		{"## 2024-05-01\n", "2024-05-01", "", true},
+		{"2024-05-01 9:30 Standup\n", "2024-05-01", "09:30:00", true},
+		{"**2024-05-01T09:30:15** Standup\n", "2024-05-01", "09:30:15", true},
+		{"- 14:05 Lunch\n", "", "14:05:00", true},
+		{"Met Alice at 14:05\n", "", "", false},
+		{"- 3 apples\n", "", "", false},
+	}
+	for _, c := range cases {
+		date, clock, ok := entryTimestamp(c.line)
+		assert.Equal(t, c.date, date, c.line)
+		assert.Equal(t, c.clock, clock, c.line)
+		assert.Equal(t, c.ok, ok, c.line)
+	}
+}
+
+func TestMergeUnion_TimestampOrder(t *testing.T) {
+	base := "# Journal\n\n## 2024-05-01 08:00\nWoke up.\n"
+	ours := base + "## 2024-05-01 09:00\nStandup.\n## 2024-05-01 12:00\nLunch.\nWith Bob.\n"
+	theirs := base + "## 2024-05-01 10:30\nReview.\n## 2024-05-02 08:00\nNext day.\n"
+
+	merged, conflicts := mergeUnion(base, ours, theirs)
+	assert.False(t, conflicts)
+	assert.Equal(t, base+"## 2024-05-01 09:00\nStandup.\n## 2024-05-01 10:30\nReview.\n## 2024-05-01 12:00\nLunch.\nWith Bob.\n## 2024-05-02 08:00\nNext day.\n", merged)
+}
+
+func TestMergeUnion_TimesTakeTheDateBefore(t *testing.T) {
+	base := "# 2024-05-01\n- 08:00 Woke up\n"
+	ours := base + "- 13:00 Gym\n"
+	theirs := base + "- 09:15 Coffee\n- 17:00 Home\n"
+
+	merged, conflicts := mergeUnion(base, ours, theirs)
+	assert.False(t, conflicts)
+	assert.Equal(t, base+"- 09:15 Coffee\n- 13:00 Gym\n- 17:00 Home\n", merged)
+}
+
+func TestMergeUnion_WithoutTimestamps(t *testing.T) {
+	merged, conflicts := mergeUnion("first\n", "first\nfrom ours\n", "first\nfrom theirs\n")
+	assert.False(t, conflicts)
+	assert.Equal(t, "first\nfrom ours\nfrom theirs\n", merged)
+}
+
+func TestMergeUnion_SameEntry(t *testing.T) {
+	merged, conflicts := mergeUnion("", "10:00 a\n11:00 b\n", "10:00 a\n")
+	assert.False(t, conflicts)
+	assert.Equal(t, "10:00 a\n11:00 b\n", merged)
+}
+
+func TestMergeUnion_WithoutFinalNewline(t *testing.T) {
+	merged, conflicts := mergeUnion("10:00 a", "10:00 a\n12:00 c", "10:00 a\n11:00 b")
+	assert.False(t, conflicts)
+	assert.Equal(t, "10:00 a\n11:00 b\n12:00 c\n", merged)
+}
+
+func TestMergeUnion_EditedBefore(t *testing.T) {
+	// An entry was edited, so the file is not append-only. It is merged line by line.
+	merged, conflicts := mergeUnion("10:00 a\n", "10:00 a, edited\n", "10:00 a\n11:00 b\n")
+	assert.False(t, conflicts)
+	assert.Equal(t, "10:00 a, edited\n11:00 b\n", merged)
+}
+
+func TestPrepareMerge_Attributes(t *testing.T) {
+	repos := test_helpers.SetupRepos()
+	defer test_helpers.CleanupRepos(repos)
+
+	test_helpers.WriteFile(t, repos.Local, ".gitattributes", "*.md merge=git-notes-text\n*.pdf binary\n")
+	assert.NoError(t, PrepareMerge(repos.Local, MergeOptions{Text: []string{"*.md"}, Union: []string{"journal/*.md"}}))
+
+	content, err := ioutil.ReadFile(repos.Local + "/.gitattributes")
+	assert.NoError(t, err)
+	assert.Equal(t, "*.md merge=git-notes-text\njournal/*.md merge=git-notes-union\n*.pdf binary\n", string(content))
+
+	out, err := runCmd(repos.Local, "git", "check-attr", "merge", "--", "journal/today.md", "notes.md")
+	assert.NoError(t, err)
+	assert.Equal(t, "journal/today.md: merge: git-notes-union\nnotes.md: merge: git-notes-text\n", out)
+}
+
+func TestGoGit_UnionMerge(t *testing.T) {
+	repos := test_helpers.SetupRepos()
+	defer test_helpers.CleanupRepos(repos)
+
+	options := RepoOptions{Merge: MergeOptions{Union: []string{"journal.md"}}}
+	test_helpers.WriteFile(t, repos.Local, "journal.md", "## 2024-05-01 08:00\nWoke up.\n")
+	gogit := GitCmd{options: map[string]RepoOptions{repos.Local: options}}
+	assert.NoError(t, gogit.Sync(repos.Local))
+
+	anotherLocal := test_helpers.SetupGitRepo("another_local", false)
+	defer os.RemoveAll(anotherLocal)
+	test_helpers.SetupRemote(anotherLocal, repos.Remote)
+	test_helpers.PerformCmd(t, anotherLocal, "git", "fetch")
+	test_helpers.PerformCmd(t, anotherLocal, "git", "checkout", "master")
+	gogit.options[anotherLocal] = options
+
+	test_helpers.WriteFile(t, anotherLocal, "journal.md", "## 2024-05-01 08:00\nWoke up.\n## 2024-05-01 11:00\nFrom another.\n")
+	assert.NoError(t, gogit.Sync(anotherLocal))
+
+	test_helpers.WriteFile(t, repos.Local, "journal.md", "## 2024-05-01 08:00\nWoke up.\n## 2024-05-01 09:00\nFrom local.\n## 2024-05-01 12:00\nFrom local again.\n")
+	assert.NoError(t, gogit.Sync(repos.Local))
+
+	content, err := ioutil.ReadFile(repos.Local + "/journal.md")
+	assert.NoError(t, err)
+	assert.Equal(t, "## 2024-05-01 08:00\nWoke up.\n## 2024-05-01 09:00\nFrom local.\n## 2024-05-01 11:00\nFrom another.\n## 2024-05-01 12:00\nFrom local again.\n", string(content))
+	assertState(t, repos.Local, Sync)
+}
@@ -2,11 +2,13 @@ package main
 
 import (
 	"log"
+	"sync"
 	"time"
 )
 
 type Watcher interface {
 	Watch(path string, channel chan string)
+	Unwatch(path string)
 }
 
 type GitWatcher struct {
@@ -15,12 +17,32 @@ type GitWatcher struct {
 	checkInterval time.Duration
 	delayBeforeFiringEvent time.Duration
 	delayAfterFiringEvent time.Duration
+
+	mutex sync.Mutex
+	stops map[string]chan struct{}
 }
 
 func (f *GitWatcher) Stop() {
 	f.running = false
 }
 
+func (f *GitWatcher) Unwatch(path string) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if stop, ok := f.stops[path]; ok {
+		close(stop)
+		delete(f.stops, path)
+	}
+}
+
+// stopChannel returns nil if the path isn't watched, so that receiving from it blocks forever.
+func (f *GitWatcher) stopChannel(path string) chan struct{} {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return f.stops[path]
+}
+
 func (f *GitWatcher) Check(path string, channel chan string) {
 	dirty, err := f.git.IsDirty(path)
 
@@ -31,16 +53,33 @@ func (f *GitWatcher) Check(path string, channel chan string) {
 	if dirty {
 		log.Printf("Changes have been detected.")
 		time.Sleep(f.delayBeforeFiringEvent)
-		channel <- path
+		select {
+		case channel <- path:
+		case <-f.stopChannel(path):
+			return
+		}
 		time.Sleep(f.delayAfterFiringEvent)
 	}
 }
 
 func (f *GitWatcher) Watch(path string, channel chan string) {
 	f.running = true
+
+	stop := make(chan struct{})
+	f.mutex.Lock()
+	if f.stops == nil {
+		f.stops = map[string]chan struct{}{}
+	}
+	f.stops[path] = stop
+	f.mutex.Unlock()
+
 	go func() {
 		for f.running {
-			time.Sleep(f.checkInterval)
+			select {
+			case <-time.After(devicePolicy.Stretch(f.checkInterval)):
+			case <-stop:
+				return
+			}
 			f.Check(path, channel)
 		}
 	}()
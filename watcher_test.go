@@ -97,3 +97,15 @@ func TestGitWatcher_CreateAndModify(t *testing.T) {
 	watcher.Check(path, channel)
 	assert.Equal(t, 2, len(listener.paths))
 }
+
+func TestGitWatcher_Unwatch(t *testing.T) {
+	var watcher, listener, path, channel = setup()
+	defer cleanup(watcher, path)
+
+	watcher.Watch(path, channel)
+	watcher.Unwatch(path)
+
+	test_helpers.WriteFile(t, path, "test.md", "Unwatch")
+	time.Sleep(200 * time.Millisecond)
+	assert.Equal(t, 0, len(listener.paths))
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+// fetchReuse is how long the fetch of a worktree stands for the other worktrees of the repo. The
+// worktrees share the remote branches, and they are usually synced at about the same time, e.g. on start.
+const fetchReuse = 10 * time.Second
+
+var ErrDetached = errors.New("HEAD is detached. Check out a branch to sync it")
+
+// Tracking is the remote branch that the checked-out branch syncs with. Without an upstream, it's the
+// branch of the same name on origin, or on the only remote if there's no origin.
+type Tracking struct {
+	Branch       string
+	Remote       string
+	RemoteBranch string
+}
+
+// Upstream is the remote-tracking branch, e.g. origin/master.
+func (t Tracking) Upstream() string {
+	return t.Remote + "/" + t.RemoteBranch
+}
+
+// CurrentTracking reads the branch of the worktree, so that every worktree syncs its own branch.
+func CurrentTracking(path string) (Tracking, error) {
+	out, err := runCmd(path, "git", "symbolic-ref", "--quiet", "--short", "HEAD")
+	if err != nil {
+		return Tracking{}, ErrDetached
+	}
+	tracking := Tracking{Branch: strings.TrimSpace(out), Remote: "origin"}
+	tracking.RemoteBranch = tracking.Branch
+	if remotes, err := Remotes(path); err == nil && len(remotes) == 1 {
+		tracking.Remote = remotes[0]
+	}
+
+	if out, err := runCmd(path, "git", "config", "branch."+tracking.Branch+".remote"); err == nil && strings.TrimSpace(out) != "." {
+		tracking.Remote = strings.TrimSpace(out)
+	}
+	if out, err := runCmd(path, "git", "config", "branch."+tracking.Branch+".merge"); err == nil {
+		tracking.RemoteBranch = strings.TrimPrefix(strings.TrimSpace(out), "refs/heads/")
+	}
+	return tracking, nil
+}
+
+func Remotes(path string) ([]string, error) {
+	out, err := runCmd(path, "git", "remote")
+	if err != nil {
+		return nil, fmt.Errorf("unable to list the remotes. Out: %s, Err: %w", out, err)
+	}
+	return strings.Fields(out), nil
+}
+
+// CommonDir returns the .git directory that the worktrees of a repo share.
+func CommonDir(path string) (string, error) {
+	out, err := runCmd(path, "git", "rev-parse", "--path-format=absolute", "--git-common-dir")
+	if err != nil {
+		return "", fmt.Errorf("unable to find the git directory. Out: %s, Err: %w", out, err)
+	}
+	return strings.TrimSpace(out), nil
+}
+
+// Fetch fetches the remote that the branch syncs with, which isn't always the one `git fetch` picks.
+func Fetch(path string, remote string, options RepoOptions) error {
+	args := []string{"fetch"}
+	if options.PartialClone != "" {
+		args = append(args, "--filter="+options.PartialClone)
+	}
+	args = append(args, remote)
+
+	return timeGitOp(path, "fetch", func() error {
+		_, err := runCmd(path, "git", args...)
+		return err
+	})
+}
+
+type sharedFetch struct {
+	mutex    sync.Mutex
+	worktree string
+	at       time.Time
+}
+
+// SharedFetcher fetches a remote once for all the worktrees of a repo that sync with it. A worktree
+// always fetches again for its own syncs, so a repo with one worktree fetches as often as before.
+type SharedFetcher struct {
+	mutex   sync.Mutex
+	fetches map[string]*sharedFetch
+	now     func() time.Time
+}
+
+var defaultSharedFetcher = NewSharedFetcher()
+
+func NewSharedFetcher() *SharedFetcher {
+	return &SharedFetcher{fetches: map[string]*sharedFetch{}, now: time.Now}
+}
+
+func (f *SharedFetcher) fetchOf(commonDir string, remote string) *sharedFetch {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	key := commonDir + "\x00" + remote
+	fetch, ok := f.fetches[key]
+	if !ok {
+		fetch = &sharedFetch{}
+		f.fetches[key] = fetch
+	}
+	return fetch
+}
+
+// Fetch waits for the fetch of another worktree of the repo in progress and reuses it if it's recent.
+func (f *SharedFetcher) Fetch(path string, options RepoOptions) error {
+	tracking, err := CurrentTracking(path)
+	if err != nil {
+		return err
+	}
+	commonDir, err := CommonDir(path)
+	if err != nil {
+		return err
+	}
+	fetch := f.fetchOf(commonDir, tracking.Remote)
+	fetch.mutex.Lock()
+	defer fetch.mutex.Unlock()
+
+	if fetch.worktree != "" && fetch.worktree != path && f.now().Sub(fetch.at) < fetchReuse {
+		log.Printf("Reusing the fetch of %s for %s", fetch.worktree, path)
+		return nil
+	}
+	err = Fetch(path, tracking.Remote, options)
+	if err != nil {
+		return err
+	}
+	fetch.worktree = path
+	fetch.at = f.now()
+	return nil
+}
+
+func (g *GitCmd) fetcher() *SharedFetcher {
+	if g.fetches == nil {
+		return defaultSharedFetcher
+	}
+	return g.fetches
+}
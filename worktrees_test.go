@@ -0,0 +1,147 @@
+package main
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/tanin47/git-notes/internal/test_helpers"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func revParse(t *testing.T, path string, rev string) string {
+	out, err := runCmd(path, "git", "rev-parse", rev)
+	assert.NoError(t, err)
+	return strings.TrimSpace(out)
+}
+
+// setupWorktree adds a worktree of the local repo on a new branch.
+func setupWorktree(t *testing.T, repos test_helpers.Repos, branch string) string {
+	test_helpers.WriteFile(t, repos.Local, "test.md", "TestContent")
+	performSync(t, repos.Local)
+
+	dir, err := ioutil.TempDir("", "git_test_worktree")
+	assert.NoError(t, err)
+	worktree := filepath.Join(dir, branch)
+	test_helpers.PerformCmd(t, repos.Local, "git", "worktree", "add", "-b", branch, worktree)
+	return worktree
+}
+
+func TestCurrentTracking(t *testing.T) {
+	repos := test_helpers.SetupRepos()
+	defer test_helpers.CleanupRepos(repos)
+	worktree := setupWorktree(t, repos, "drafts")
+	defer os.RemoveAll(filepath.Dir(worktree))
+
+	tracking, err := CurrentTracking(repos.Local)
+	assert.NoError(t, err)
+	assert.Equal(t, Tracking{Branch: "master", Remote: "origin", RemoteBranch: "master"}, tracking)
+	assert.Equal(t, "origin/master", tracking.Upstream())
+
+	tracking, err = CurrentTracking(worktree)
+	assert.NoError(t, err)
+	assert.Equal(t, Tracking{Branch: "drafts", Remote: "origin", RemoteBranch: "drafts"}, tracking)
+
+	test_helpers.PerformCmd(t, worktree, "git", "checkout", "--detach")
+	_, err = CurrentTracking(worktree)
+	assert.Equal(t, ErrDetached, err)
+}
+
+func TestSharedFetcher(t *testing.T) {
+	repos := test_helpers.SetupRepos()
+	defer test_helpers.CleanupRepos(repos)
+	worktree := setupWorktree(t, repos, "drafts")
+	defer os.RemoveAll(filepath.Dir(worktree))
+
+	now := time.Now()
+	fetcher := NewSharedFetcher()
+	fetcher.now = func() time.Time { return now }
+	before := revParse(t, repos.Local, "origin/master")
+	makeConflict(t, repos.Remote)
+
+	// The worktree reuses the fetch of the main worktree.
+	assert.NoError(t, fetcher.Fetch(repos.Local, RepoOptions{}))
+	fetched := revParse(t, repos.Local, "origin/master")
+	assert.NotEqual(t, before, fetched)
+	test_helpers.PerformCmd(t, repos.Local, "git", "update-ref", "refs/remotes/origin/master", before)
+	assert.NoError(t, fetcher.Fetch(worktree, RepoOptions{}))
+	assert.Equal(t, before, revParse(t, worktree, "origin/master"))
+
+	// The same worktree always fetches again.
+	assert.NoError(t, fetcher.Fetch(repos.Local, RepoOptions{}))
+	assert.Equal(t, fetched, revParse(t, worktree, "origin/master"))
+
+	test_helpers.PerformCmd(t, repos.Local, "git", "update-ref", "refs/remotes/origin/master", before)
+	now = now.Add(fetchReuse)
+	assert.NoError(t, fetcher.Fetch(worktree, RepoOptions{}))
+	assert.Equal(t, fetched, revParse(t, worktree, "origin/master"))
+
+	// A worktree that syncs with another remote fetches it.
+	test_helpers.PerformCmd(t, repos.Local, "git", "remote", "add", "upstream", repos.Remote)
+	test_helpers.PerformCmd(t, worktree, "git", "config", "branch.drafts.remote", "upstream")
+	test_helpers.PerformCmd(t, worktree, "git", "config", "branch.drafts.merge", "refs/heads/master")
+	assert.NoError(t, fetcher.Fetch(repos.Local, RepoOptions{}))
+	assert.NoError(t, fetcher.Fetch(worktree, RepoOptions{}))
+	assert.Equal(t, fetched, revParse(t, worktree, "upstream/master"))
+}
+
+func TestGoGit_SyncWorktrees(t *testing.T) {
+	repos := test_helpers.SetupRepos()
+	defer test_helpers.CleanupRepos(repos)
+	worktree := setupWorktree(t, repos, "drafts")
+	defer os.RemoveAll(filepath.Dir(worktree))
+
+	gogit := GitCmd{fetches: NewSharedFetcher()}
+	test_helpers.WriteFile(t, repos.Local, "main.md", "Main")
+	test_helpers.WriteFile(t, worktree, "draft.md", "Draft")
+	assert.NoError(t, gogit.Sync(repos.Local))
+	assert.NoError(t, gogit.Sync(worktree))
+	assertState(t, repos.Local, Sync)
+	assertState(t, worktree, Sync)
+
+	// Each worktree pushes only its own branch.
+	assert.Equal(t, headCommit(repos.Local), revParse(t, repos.Remote, "master"))
+	assert.Equal(t, headCommit(worktree), revParse(t, repos.Remote, "drafts"))
+	files, err := runCmd(repos.Remote, "git", "ls-tree", "--name-only", "master")
+	assert.NoError(t, err)
+	assert.Equal(t, "main.md\ntest.md\n", files)
+	files, err = runCmd(repos.Remote, "git", "ls-tree", "--name-only", "drafts")
+	assert.NoError(t, err)
+	assert.Equal(t, "draft.md\ntest.md\n", files)
+
+	// The worktree merges the changes to its own branch only.
+	makeConflict(t, repos.Remote)
+	assert.NoError(t, gogit.Sync(worktree))
+	assertState(t, worktree, Sync)
+	content, err := ioutil.ReadFile(filepath.Join(worktree, "test.md"))
+	assert.NoError(t, err)
+	assert.Equal(t, "TestContent", string(content))
+	assertState(t, repos.Local, OutOfSync)
+}
+
+func TestGoGit_SkipsDetachedWorktree(t *testing.T) {
+	repos := test_helpers.SetupRepos()
+	defer test_helpers.CleanupRepos(repos)
+	worktree := setupWorktree(t, repos, "drafts")
+	defer os.RemoveAll(filepath.Dir(worktree))
+	store, cleanup := setupStateStore(t)
+	defer cleanup()
+
+	test_helpers.PerformCmd(t, worktree, "git", "checkout", "--detach")
+	test_helpers.WriteFile(t, worktree, "draft.md", "Draft")
+	head := headCommit(worktree)
+	gogit := GitCmd{store: store}
+	for i := 0; i < 2; i++ {
+		assert.NoError(t, gogit.Sync(worktree))
+	}
+	assert.Equal(t, Busy, metrics.State(worktree))
+	assert.Equal(t, head, headCommit(worktree))
+
+	// Leaving the worktree alone isn't a failure.
+	record, err := store.Load(worktree)
+	assert.NoError(t, err)
+	assert.Empty(t, record.History)
+	assert.Equal(t, 0, record.ConsecutiveFailures)
+}
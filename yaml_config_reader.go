@@ -0,0 +1,98 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"io/ioutil"
+	"reflect"
+	"regexp"
+	"strconv"
+)
+
+var yamlLinePattern = regexp.MustCompile(`^(?:yaml: )?line ([0-9]+): (.*)$`)
+
+type YamlConfigReader struct{}
+
+func (c *YamlConfigReader) Read(path string) (*Config, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var document yaml.Node
+	err = yaml.Unmarshal(data, &document)
+	if err != nil {
+		return nil, yamlError(path, err)
+	}
+
+	var config Config
+	if len(document.Content) == 0 {
+		return &config, config.Validate(path)
+	}
+	root := document.Content[0]
+
+	var errs ConfigErrors
+	checkYamlKeys(path, root, reflect.TypeOf(config), "", &errs)
+	if len(errs) > 0 {
+		return nil, errs
+	}
+
+	err = root.Decode(&config)
+	if err != nil {
+		return nil, yamlError(path, err)
+	}
+
+	return &config, config.Validate(path)
+}
+
+// checkYamlKeys walks the YAML nodes and reports every key that doesn't exist in the Go type.
+func checkYamlKeys(path string, node *yaml.Node, t reflect.Type, prefix string, errs *ConfigErrors) {
+	if t == nil {
+		return
+	}
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	switch node.Kind {
+	case yaml.AliasNode:
+		checkYamlKeys(path, node.Alias, t, prefix, errs)
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key := node.Content[i]
+			fieldType, ok := lookupConfigField(t, key.Value, "yaml", false)
+			if !ok {
+				*errs = append(*errs, &ConfigError{Path: path, Line: key.Line, Column: key.Column, Message: fmt.Sprintf("unknown key %q", prefix+key.Value)})
+				continue
+			}
+			checkYamlKeys(path, node.Content[i+1], fieldType, prefix+key.Value+".", errs)
+		}
+	case yaml.SequenceNode:
+		if t.Kind() == reflect.Slice || t.Kind() == reflect.Array {
+			for _, item := range node.Content {
+				checkYamlKeys(path, item, t.Elem(), prefix, errs)
+			}
+		}
+	}
+}
+
+// yamlError converts the YAML errors into ConfigErrors. YAML only reports the line of a problem.
+func yamlError(path string, err error) error {
+	messages := []string{err.Error()}
+	var typeErr *yaml.TypeError
+	if errors.As(err, &typeErr) {
+		messages = typeErr.Errors
+	}
+
+	var errs ConfigErrors
+	for _, message := range messages {
+		configErr := &ConfigError{Path: path, Message: message}
+		if match := yamlLinePattern.FindStringSubmatch(message); match != nil {
+			configErr.Line, _ = strconv.Atoi(match[1])
+			configErr.Message = match[2]
+		}
+		errs = append(errs, configErr)
+	}
+	return errs
+}